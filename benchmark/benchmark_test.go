@@ -143,7 +143,8 @@ func (tei *testExpressionInference) testFilters() {
 	for ot := uint(0); ot < 50; ot += 5 {
 		for at := uint(1); at < 2; at += 5 {
 			fmt.Printf("time with ot = %d; at = %d\n", ot, at)
-			tei.compileAndRun("optimized", &cateng.Options{ot, at, true})
+			tei.compileAndRun("optimized", &cateng.Options{
+				OrOptimizationFreqThreshold: ot, AndOptimizationFreqThreshold: at, Verbose: true})
 		}
 	}
 }
@@ -0,0 +1,42 @@
+package benchmark
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/engine"
+	"testing"
+)
+
+// BenchmarkMatchEvents compares matching a batch of events with MatchEvents and with MatchEvent in a loop.
+// Run with -benchmem to compare the allocations.
+func BenchmarkMatchEvents(b *testing.B) {
+	repo := engine.NewRuleEngineRepo()
+	for i := 0; i < 1000; i++ {
+		rule := fmt.Sprintf(`[{"expression": "amount > %d && region == \"r%d\""}]`, i*10, i%10)
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			b.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		b.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	events := make([]map[string]interface{}, 100)
+	for i := range events {
+		events[i] = map[string]interface{}{"amount": float64(i * 37), "region": fmt.Sprintf("r%d", i%10)}
+	}
+
+	b.Run("MatchEvent", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, event := range events {
+				genFilter.MatchEvent(event)
+			}
+		}
+	})
+	b.Run("MatchEvents", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			genFilter.MatchEvents(events)
+		}
+	})
+}
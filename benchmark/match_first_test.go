@@ -0,0 +1,43 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/atlasgurus/rulestone/engine"
+	"testing"
+)
+
+// BenchmarkMatchEventFirst compares MatchEventFirst with MatchEvent for a repo of 10k rules that all match the event.
+func BenchmarkMatchEventFirst(b *testing.B) {
+	const numRules = 10000
+	repo := engine.NewRuleEngineRepo()
+	for i := 0; i < numRules; i++ {
+		rule := fmt.Sprintf(`[{"expression": "amount > %d && region == \"r%d\""}]`, i, i%10)
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			b.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		b.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"amount": 20000, "region": "r3"}`), &event); err != nil {
+		b.Fatalf("failed Unmarshal: %s", err)
+	}
+
+	b.Run("MatchEvent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if matches := genFilter.MatchEvent(event); len(matches) != numRules/10 {
+				b.Fatalf("unexpected number of matches %d", len(matches))
+			}
+		}
+	})
+	b.Run("MatchEventFirst", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, ok := genFilter.MatchEventFirst(event); !ok {
+				b.Fatalf("expected a match")
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/atlasgurus/rulestone/engine"
+	"testing"
+)
+
+// BenchmarkNotEqualToConst compares the rules comparing the same attribute with many constants using == and !=.
+// Both are resolved by a single hash lookup of the attribute value per event.
+func BenchmarkNotEqualToConst(b *testing.B) {
+	const numRules = 1000
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"status": "s3"}`), &event); err != nil {
+		b.Fatalf("failed Unmarshal: %s", err)
+	}
+	for _, bm := range []struct {
+		name       string
+		op         string
+		numMatches int
+	}{
+		{"Equal", "==", 1},
+		{"NotEqual", "!=", numRules - 1},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			repo := engine.NewRuleEngineRepo()
+			for i := 0; i < numRules; i++ {
+				rule := fmt.Sprintf(`[{"expression": "status %s \"s%d\""}]`, bm.op, i)
+				if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+					b.Fatalf("failed RegisterRuleFromString: %s", err)
+				}
+			}
+			genFilter, err := engine.NewRuleEngine(repo)
+			if err != nil {
+				b.Fatalf("failed NewRuleEngine: %s", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if matches := genFilter.MatchEvent(event); len(matches) != bm.numMatches {
+					b.Fatalf("unexpected number of matches %d", len(matches))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"github.com/atlasgurus/rulestone/engine"
+	"strings"
+	"testing"
+)
+
+// BenchmarkPredicateCostOrdering compares the evaluation of an expensive regexp guarded by a cheap comparison
+// that fails for most of the array elements, with and without the predicate cost ordering.
+func BenchmarkPredicateCostOrdering(b *testing.B) {
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, `{"name": "`+strings.Repeat("a", 30)+`", "qty": 1}`)
+	}
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"items": [`+strings.Join(items, ",")+`]}`), &event); err != nil {
+		b.Fatalf("failed Unmarshal: %s", err)
+	}
+
+	rule := `[{"expression": "forSome(\"items\", \"item\", regexpMatch(\"^(a|aa)+b$\", item.name) && item.qty > 100)"}]`
+	for _, bm := range []struct {
+		name string
+		opts []engine.Option
+	}{
+		{"SourceOrder", nil},
+		{"CostOrder", []engine.Option{engine.WithPredicateCostOrdering(true)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			repo := engine.NewRuleEngineRepo()
+			if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+				b.Fatalf("failed RegisterRuleFromString: %s", err)
+			}
+			genFilter, err := engine.NewRuleEngine(repo, bm.opts...)
+			if err != nil {
+				b.Fatalf("failed NewRuleEngine: %s", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if matches := genFilter.MatchEvent(event); len(matches) != 0 {
+					b.Fatalf("unexpected matches %v", matches)
+				}
+			}
+		})
+	}
+}
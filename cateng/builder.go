@@ -6,6 +6,7 @@ import (
 	"github.com/atlasgurus/rulestone/immutable"
 	"github.com/atlasgurus/rulestone/types"
 	"github.com/zyedidia/generic/hashmap"
+	"io"
 	"sort"
 )
 
@@ -124,6 +125,8 @@ type Options struct {
 	OrOptimizationFreqThreshold  uint
 	AndOptimizationFreqThreshold uint
 	Verbose                      bool
+	// TraceWriter receives the TraceRecord of each set merged or inlined by the optimizer, nil for no trace.
+	TraceWriter io.Writer
 }
 
 type FilterBuilder struct {
@@ -422,6 +425,11 @@ func (fb *FilterBuilder) optimiseOrSets() uint {
 							newOrSet.Remove(pf.cp.cat2)
 							newOrSet.Put(-newSetRec.CatFilterSet.CatSetIndex1)
 							countOfSetsRemoved++
+							fb.trace(TraceRecord{
+								Step:              TraceOrSetMerged,
+								Categories:        sortedCategories(*immutable.Of(pf.cp.cat1, pf.cp.cat2)),
+								Freq:              pf.freq,
+								SyntheticCategory: -newSetRec.CatFilterSet.CatSetIndex1})
 						}
 						break
 					}
@@ -540,6 +548,11 @@ func (fb *FilterBuilder) optimiseAndSets() uint {
 						newAndSet.Remove(pf.cp.orSet2)
 						newAndSet.Put(*immutable.Of(-newSetRec.CatFilterSet.CatSetIndex1))
 						countOfSetsRemoved++
+						fb.trace(TraceRecord{
+							Step:              TraceAndSetMerged,
+							Sets:              sortedSets([]immutable.Set[types.Category]{pf.cp.orSet1, pf.cp.orSet2}),
+							Freq:              pf.freq,
+							SyntheticCategory: -newSetRec.CatFilterSet.CatSetIndex1})
 					}
 					break
 				}
@@ -700,6 +713,11 @@ func (fb *FilterBuilder) inlineOrSet(
 				if freq == 1 {
 					newS = fb.inlineOrSet(inlineOrSet, catSetFreq)
 					fb.metrics.OrSetsInlined++
+					fb.trace(TraceRecord{
+						Step:              TraceOrSetInlined,
+						Categories:        sortedCategories(inlineOrSet),
+						Freq:              freq,
+						SyntheticCategory: cat})
 				}
 			}
 		}
@@ -727,6 +745,11 @@ func (fb *FilterBuilder) inlineAndOrSet(
 				if len(catCsr.RuleSet) == 0 {
 					freq, ok := catSetFreq.Get(cat)
 					if ok && freq == 1 {
+						fb.trace(TraceRecord{
+							Step:              TraceAndOrSetInlined,
+							Sets:              sortedSets(catCsr.CatFilterSet.AndSet.ToSlice()),
+							Freq:              freq,
+							SyntheticCategory: cat})
 						newS = fb.inlineAndOrSet(catCsr.CatFilterSet.AndSet, catSetFreq)
 						fb.unregisterFilterSet(catCsr.CatFilterSet.AndSet)
 						fb.metrics.AndOrSetsInlined++
@@ -33,38 +33,97 @@ func NewCategoryEngine(repo *condition.RuleRepo, options *Options) *CategoryEngi
 	return &result
 }
 
-func applyCatSetMasks(csmList []*CatSetMask, matchMaskArray []types.Mask, result *[]condition.RuleIdType, f *CategoryEngine) {
+// applyCatSetMasks calls fn for each rule matched by applying the masks.  It returns false as soon as fn does.
+func applyCatSetMasks(
+	csmList []*CatSetMask, matchMaskArray []types.Mask, fn func(condition.RuleIdType) bool, f *CategoryEngine,
+	metrics *Metrics) bool {
 	for _, csm := range csmList {
 		v := matchMaskArray[csm.Index1-1]
-		f.Metrics.NumMaskArrayLookups++
+		metrics.NumMaskArrayLookups++
 		if v != -1 {
 			newV := v | csm.Mask
 			matchMaskArray[csm.Index1-1] = newV
-			f.Metrics.NumBitMaskChecks++
+			metrics.NumBitMaskChecks++
 			if newV == -1 {
 				// We got a match.
 				catSetFilter := f.FilterTables.CatSetFilters[csm.Index1-1]
 
-				f.Metrics.NumBitMaskMatches++
+				metrics.NumBitMaskMatches++
 
 				// Process the synthetic categories from the set.
 				if len(catSetFilter.CatSetMasks) > 0 {
-					applyCatSetMasks(catSetFilter.CatSetMasks, matchMaskArray, result, f)
+					if !applyCatSetMasks(catSetFilter.CatSetMasks, matchMaskArray, fn, f, metrics) {
+						return false
+					}
 				}
 				for _, cfr := range catSetFilter.RuleSet {
-					*result = append(*result, cfr.RuleId)
+					if !fn(cfr.RuleId) {
+						return false
+					}
 				}
 			}
 		}
 	}
+	return true
 }
 
 func (f *CategoryEngine) MatchEvent(cats []types.Category) []condition.RuleIdType {
-	matchMaskArray := make([]types.Mask, len(f.FilterTables.NegCats)+len(f.FilterTables.CatSetFilters))
 	result := make([]condition.RuleIdType, 0, 100)
+	f.MatchEventFunc(cats, func(ruleId condition.RuleIdType) bool {
+		result = append(result, ruleId)
+		return true
+	})
+	return result
+}
+
+// MatchEventFunc calls fn for each rule matched by the categories instead of collecting the matches in a slice.
+// The matching stops when fn returns false.
+func (f *CategoryEngine) MatchEventFunc(cats []types.Category, fn func(condition.RuleIdType) bool) {
+	matchMaskArray := make([]types.Mask, len(f.FilterTables.NegCats)+len(f.FilterTables.CatSetFilters))
 
 	defaultCatMap := make([]bool, len(f.FilterTables.DefaultCategories))
 
+	f.matchEventFunc(cats, matchMaskArray, defaultCatMap, &f.Metrics, fn)
+}
+
+// MatchScratch holds the buffers for matching the categories that can be reused across the events.  Each scratch
+// must be used by one goroutine at a time.
+type MatchScratch struct {
+	matchMaskArray []types.Mask
+	defaultCatMap  []bool
+	// Metrics accumulates the metrics of the matches made with the scratch.
+	Metrics Metrics
+}
+
+// NewMatchScratch allocates the buffers for matching the categories with MatchEventScratch.
+func (f *CategoryEngine) NewMatchScratch() *MatchScratch {
+	return &MatchScratch{
+		matchMaskArray: make([]types.Mask, len(f.FilterTables.NegCats)+len(f.FilterTables.CatSetFilters)),
+		defaultCatMap:  make([]bool, len(f.FilterTables.DefaultCategories)),
+	}
+}
+
+// MatchEventScratch returns the rules matching the categories like MatchEvent, reusing the buffers of the scratch.
+// The metrics are accumulated in the scratch rather than in the engine.
+func (f *CategoryEngine) MatchEventScratch(cats []types.Category, scratch *MatchScratch) []condition.RuleIdType {
+	for i := range scratch.matchMaskArray {
+		scratch.matchMaskArray[i] = 0
+	}
+	for i := range scratch.defaultCatMap {
+		scratch.defaultCatMap[i] = false
+	}
+	var result []condition.RuleIdType
+	f.matchEventFunc(cats, scratch.matchMaskArray, scratch.defaultCatMap, &scratch.Metrics,
+		func(ruleId condition.RuleIdType) bool {
+			result = append(result, ruleId)
+			return true
+		})
+	return result
+}
+
+func (f *CategoryEngine) matchEventFunc(
+	cats []types.Category, matchMaskArray []types.Mask, defaultCatMap []bool, metrics *Metrics,
+	fn func(condition.RuleIdType) bool) {
 	catToCatSetMask := f.FilterTables.CatToCatSetMask
 	for _, cat := range cats {
 		if i, ok := f.FilterTables.DefaultCategories[cat]; ok {
@@ -74,7 +133,9 @@ func (f *CategoryEngine) MatchEvent(cats []types.Category) []condition.RuleIdTyp
 		}
 		csml := catToCatSetMask.Get(cat)
 		if csml != nil {
-			applyCatSetMasks(csml, matchMaskArray, &result, f)
+			if !applyCatSetMasks(csml, matchMaskArray, fn, f, metrics) {
+				return
+			}
 		}
 	}
 
@@ -87,11 +148,12 @@ func (f *CategoryEngine) MatchEvent(cats []types.Category) []condition.RuleIdTyp
 			}
 			csml := catToCatSetMask.Get(negCat)
 			if csml != nil {
-				applyCatSetMasks(csml, matchMaskArray, &result, f)
+				if !applyCatSetMasks(csml, matchMaskArray, fn, f, metrics) {
+					return
+				}
 			}
 		}
 	}
-	return result
 }
 
 func (f *CategoryEngine) PrintMetrics() {
@@ -0,0 +1,64 @@
+package cateng
+
+import (
+	"encoding/json"
+	"github.com/atlasgurus/rulestone/immutable"
+	"github.com/atlasgurus/rulestone/types"
+	"sort"
+)
+
+// The optimization steps of the trace records.
+const (
+	// TraceOrSetMerged is the pair of categories appearing together in the OR sets of many AND-OR sets replaced
+	// with the synthetic category.
+	TraceOrSetMerged = "orSetMerged"
+	// TraceAndSetMerged is the pair of OR sets appearing together in many AND-OR sets replaced with the synthetic
+	// category.
+	TraceAndSetMerged = "andSetMerged"
+	// TraceOrSetInlined is the synthetic category of a single OR set replaced with the categories of that set.
+	TraceOrSetInlined = "orSetInlined"
+	// TraceAndOrSetInlined is the synthetic category of an AND-OR set replaced with the OR sets of that set.
+	TraceAndOrSetInlined = "andOrSetInlined"
+)
+
+// TraceRecord describes one change of the filter structure made by the optimizer, see Options.TraceWriter.
+// The records are written as JSON, one per line.
+type TraceRecord struct {
+	Step string `json:"step"`
+	// Categories are the categories merged into or inlined from the synthetic category of the OR set steps.
+	Categories []types.Category `json:"categories,omitempty"`
+	// Sets are the OR sets merged into or inlined from the synthetic category of the AND set steps.
+	Sets [][]types.Category `json:"sets,omitempty"`
+	// Freq is the number of the AND-OR sets referencing the merged categories or sets, or the inlined synthetic
+	// category.
+	Freq uint `json:"freq"`
+	// SyntheticCategory is the category of the merged or the inlined set, negative as the synthetic categories are.
+	SyntheticCategory types.Category `json:"syntheticCategory"`
+}
+
+// trace writes the record to the trace writer of the options if there is one.
+func (fb *FilterBuilder) trace(record TraceRecord) {
+	if fb.options.TraceWriter == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	// The trace is diagnostic output, the write errors do not fail the build
+	_, _ = fb.options.TraceWriter.Write(append(data, '\n'))
+}
+
+func sortedCategories(s immutable.Set[types.Category]) []types.Category {
+	result := s.ToSlice()
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func sortedSets(sets []immutable.Set[types.Category]) [][]types.Category {
+	result := make([][]types.Category, len(sets))
+	for i, s := range sets {
+		result[i] = sortedCategories(s)
+	}
+	return result
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/atlasgurus/rulestone/immutable"
 	"github.com/atlasgurus/rulestone/objectmap"
 	"github.com/atlasgurus/rulestone/types"
+	"go/ast"
+	"math"
 	"reflect"
 	"strconv"
 	"time"
@@ -191,6 +193,8 @@ func (c *ErrorCondition) GetKind() CondKind {
 type ExprCondition struct {
 	Expr string
 	Hash uint64
+	// Node is the parsed expression if it has already been parsed, nil otherwise.
+	Node ast.Expr
 }
 
 func NewExprCondition(val string) Condition {
@@ -199,6 +203,14 @@ func NewExprCondition(val string) Condition {
 		Hash: immutable.HashInt([]uint64{uint64(ExprCondKind), immutable.HashString(val)})}
 }
 
+// NewParsedExprCondition creates the expression condition that carries the expression's parsed AST.
+// The AST must not be modified after the condition is created as it may be shared between rules.
+func NewParsedExprCondition(val string, node ast.Expr) Condition {
+	result := NewExprCondition(val).(*ExprCondition)
+	result.Node = node
+	return result
+}
+
 func (c *ExprCondition) GetHash() uint64 {
 	return c.Hash
 }
@@ -429,7 +441,12 @@ func (v FloatOperand) GetKind() OperandKind {
 }
 
 func (v FloatOperand) GetHash() uint64 {
-	return uint64(v)
+	// Hash all the bits so that the fractional values like 0.3 and 0.8 are not truncated to the same hash.
+	// -0 equals 0, so it must hash the same.
+	if v == 0 {
+		return 0
+	}
+	return math.Float64bits(float64(v))
 }
 
 func (v FloatOperand) Equals(o immutable.SetElement) bool {
@@ -450,14 +467,20 @@ func NewStringOperand(val string) Operand {
 	return StringOperand(val)
 }
 
+// ParseTime parses the date string guessing its format.  The dates without an explicit offset are in the loc time
+// zone, or UTC when loc is nil.
+func ParseTime(s string, loc *time.Location) Operand {
+	t, err := dateparse.ParseIn(s, loc)
+	if err != nil {
+		return NewErrorOperand(err)
+	}
+	return NewTimeOperand(t)
+}
+
 func (v StringOperand) Convert(to OperandKind) Operand {
 	switch to {
 	case TimeOperandKind:
-		t, err := dateparse.ParseAny(string(v))
-		if err != nil {
-			return NewErrorOperand(err)
-		}
-		return NewTimeOperand(t)
+		return ParseTime(string(v), nil)
 	case IntOperandKind:
 		i, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
@@ -869,6 +892,12 @@ func NewNullOperand(val *AddressOperand) Operand {
 	return NullOperand{val}
 }
 
+// IsUndefined tells whether the null operand is the value of an attribute missing from the event, which carries the
+// address of the attribute, rather than an explicit null.
+func (v NullOperand) IsUndefined() bool {
+	return v.address != nil
+}
+
 func (v NullOperand) IsConst() bool {
 	return true
 }
@@ -0,0 +1,774 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"github.com/atlasgurus/rulestone/types"
+	"go/ast"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// arrayIterator iterates over the elements of an array attribute evaluating the per-element operands
+// in the scope of each element.  It is the common machinery behind the array functions, e.g. regexpMatchAny.
+type arrayIterator struct {
+	arrayAddress *objectmap.AttributeAddress
+	nestingLevel int
+	path         string
+	evals        []condition.Operand
+}
+
+// forEach calls visit for each array element with the per-element operands evaluated for that element.
+// The visit function returns false to stop the iteration.
+// forEach returns a NullOperand if the array is not present in the event and nil otherwise.
+func (it *arrayIterator) forEach(
+	event *objectmap.ObjectAttributeMap,
+	frames []interface{},
+	visit func(i int, values []condition.Operand) bool) condition.Operand {
+	array := objectmap.GetNestedAttributeByAddress(frames[it.arrayAddress.ParentParameterIndex], it.arrayAddress.Address)
+	if array == nil {
+		return condition.NewNullOperand(nil)
+	}
+	elements, ok := array.([]interface{})
+	if !ok {
+		return condition.NewErrorOperand(fmt.Errorf("attribute %s is not an array", it.path))
+	}
+	values := make([]condition.Operand, len(it.evals))
+	for i, element := range elements {
+		event.NumIterations++
+		frames[it.nestingLevel] = element
+		for j, eval := range it.evals {
+			values[j] = eval.Evaluate(event, frames)
+		}
+		if !visit(i, values) {
+			break
+		}
+	}
+	return nil
+}
+
+// forConsecutivePairs calls visit for each pair of the adjacent array elements with the per-element operands
+// evaluated for the previous and the current element.  The visit function returns false to stop the iteration.
+// It returns a NullOperand if the array is not present in the event and nil otherwise.
+func (it *arrayIterator) forConsecutivePairs(
+	event *objectmap.ObjectAttributeMap,
+	frames []interface{},
+	visit func(i int, prev []condition.Operand, cur []condition.Operand) bool) condition.Operand {
+	var prev []condition.Operand
+	return it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+		if prev == nil {
+			prev = make([]condition.Operand, len(values))
+		} else if !visit(i, prev, values) {
+			return false
+		}
+		// forEach reuses the values across the elements
+		copy(prev, values)
+		return true
+	})
+}
+
+// hashArgs returns the operands identifying the iteration for the purpose of ExprOperand hashing.
+func (it *arrayIterator) hashArgs(funcName string, args ...condition.Operand) []condition.Operand {
+	result := []condition.Operand{condition.NewStringOperand(funcName), condition.NewStringOperand(it.path)}
+	result = append(result, args...)
+	return append(result, it.evals...)
+}
+
+// newArrayIterator sets up the scope for iterating over the array at path, binding each element to the
+// element name, and compiles the per-element expressions in that scope.
+func (repo *CompareCondRepo) newArrayIterator(
+	path string, element string, exprs []ast.Expr, parentScope *ForEachScope) (*arrayIterator, error) {
+	arrayAddress, newScope, err := repo.setupEvalForEach(parentScope, element, path)
+	if err != nil {
+		return nil, err
+	}
+
+	evals := make([]condition.Operand, len(exprs))
+	for i, expr := range exprs {
+		evals[i] = repo.evalAstNode(expr, newScope)
+		if evals[i].GetKind() == condition.ErrorOperandKind {
+			return nil, evals[i].(condition.ErrorOperand).Err
+		}
+	}
+
+	// Make sure the enclosing category is evaluated whenever the array is present in the event.
+	repo.registerCatEvaluatorForAddress(arrayAddress.FullAddress, parentScope.Evaluator)
+
+	return &arrayIterator{
+		arrayAddress: arrayAddress,
+		nestingLevel: newScope.NestingLevel,
+		path:         newScope.Path,
+		evals:        evals}, nil
+}
+
+// evalConstStringArg evaluates a function argument that must be a constant string, e.g. an array path.
+func (repo *CompareCondRepo) evalConstStringArg(funcName string, arg ast.Expr, scope *ForEachScope) (string, error) {
+	operand := repo.evalAstNode(arg, scope)
+	if operand.GetKind() == condition.ErrorOperandKind {
+		return "", operand.(condition.ErrorOperand).Err
+	}
+	if !operand.IsConst() || operand.GetKind() != condition.StringOperandKind {
+		return "", fmt.Errorf("%s() only supports constant string path and element operands", funcName)
+	}
+	return string(operand.(condition.StringOperand)), nil
+}
+
+// setupArrayFunc parses the path and element operands of an array function, e.g. count("orders", "o", ...),
+// and compiles the numExprs per-element expressions following them.
+func (repo *CompareCondRepo) setupArrayFunc(
+	funcName string, n *ast.CallExpr, numExprs int, scope *ForEachScope) (*arrayIterator, error) {
+	if len(n.Args) < 2+numExprs {
+		return nil, fmt.Errorf("wrong number of arguments for %s() function", funcName)
+	}
+	path, err := repo.evalConstStringArg(funcName, n.Args[0], scope)
+	if err != nil {
+		return nil, err
+	}
+	element, err := repo.evalConstStringArg(funcName, n.Args[1], scope)
+	if err != nil {
+		return nil, err
+	}
+	return repo.newArrayIterator(path, element, n.Args[2:2+numExprs], scope)
+}
+
+// funcRegexpMatchAny implements regexpMatchAny(pattern, arrayPath, element) that is true when any element
+// of the array converted to string matches the pattern.  Missing or empty array evaluates to false.
+func funcRegexpMatchAny(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for regexpMatchAny() function"))
+	}
+	patternOperand := repo.evalAstNode(n.Args[0], scope)
+	re, err := repo.compileRegexpOperand("regexpMatchAny", patternOperand)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	path, err := repo.evalConstStringArg("regexpMatchAny", n.Args[1], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	element, err := repo.evalConstStringArg("regexpMatchAny", n.Args[2], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	it, err := repo.newArrayIterator(path, element, []ast.Expr{ast.NewIdent(element)}, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var result condition.Operand = condition.NewBooleanOperand(false)
+			it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind, condition.NullOperandKind:
+					return true
+				}
+				s := values[0].Convert(condition.StringOperandKind)
+				if s.GetKind() == condition.StringOperandKind && re.MatchString(string(s.(condition.StringOperand))) {
+					result = condition.NewBooleanOperand(true)
+					return false
+				}
+				return true
+			})
+			return result
+		}, it.hashArgs("regexpMatchAny", patternOperand)...)
+}
+
+// funcAllDistinct implements allDistinct(arrayPath, element, expr) that is true when the key computed by expr
+// is unique across all the array elements.  Elements with undefined key are skipped and do not count as
+// collisions.  Empty array evaluates to true, while a missing array is undefined.
+func funcAllDistinct(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for allDistinct() function"))
+	}
+	it, err := repo.setupArrayFunc("allDistinct", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var result condition.Operand = condition.NewBooleanOperand(true)
+			seen := types.NewHashSet[condition.Operand]()
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					result = values[0]
+					return false
+				case condition.NullOperandKind:
+					return true
+				}
+				if seen.Has(values[0]) {
+					result = condition.NewBooleanOperand(false)
+					return false
+				}
+				seen.Put(values[0])
+				return true
+			}); r != nil {
+				return r
+			}
+			return result
+		}, it.hashArgs("allDistinct")...)
+}
+
+// arrayRangeFuncs compute the results of arrayMax(), arrayMin() and arraySpread() from the maximum and the minimum.
+var arrayRangeFuncs = map[string]func(max, min condition.FloatOperand) condition.Operand{
+	"arrayMax":    func(max, min condition.FloatOperand) condition.Operand { return max },
+	"arrayMin":    func(max, min condition.FloatOperand) condition.Operand { return min },
+	"arraySpread": func(max, min condition.FloatOperand) condition.Operand { return max - min },
+}
+
+// funcArrayRange implements arrayMax(arrayPath, element, expr), arrayMin(arrayPath, element, expr) and
+// arraySpread(arrayPath, element, expr) computing the maximum, the minimum or the difference between the two of expr
+// over the array elements converted to floats.  Elements with undefined expr are skipped.  The result is undefined
+// for a missing or empty array, or when all the elements are undefined.
+func funcArrayRange(repo *CompareCondRepo, funcName string, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+	}
+	it, err := repo.setupArrayFunc(funcName, n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	rangeFunc := arrayRangeFuncs[funcName]
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var errOperand condition.Operand
+			var max, min condition.FloatOperand
+			count := 0
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					errOperand = values[0]
+					return false
+				case condition.NullOperandKind:
+					return true
+				}
+				v := values[0].Convert(condition.FloatOperandKind)
+				if v.GetKind() != condition.FloatOperandKind {
+					errOperand = v
+					return false
+				}
+				f := v.(condition.FloatOperand)
+				if count == 0 || f > max {
+					max = f
+				}
+				if count == 0 || f < min {
+					min = f
+				}
+				count++
+				return true
+			}); r != nil {
+				return r
+			}
+			switch {
+			case errOperand != nil:
+				return errOperand
+			case count == 0:
+				return condition.NewNullOperand(nil)
+			}
+			return rangeFunc(max, min)
+		}, it.hashArgs(funcName)...)
+}
+
+// funcArrayAggregate implements sum(elementPath) and avg(elementPath) aggregating the array element attributes
+// denoted by the constant path, e.g. sum("order.items[].price"), or the array elements themselves when the path has
+// no "[]", e.g. avg("scores").  sum(arrayPath, element, expr) and avg(arrayPath, element, expr) aggregate the
+// expression of the elements instead, e.g. sum("items", "i", i.amount * i.qty).  Undefined elements are skipped.
+// The result is undefined for a missing or empty array.
+func funcArrayAggregate(
+	repo *CompareCondRepo, funcName string, isAvg bool, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	var it *arrayIterator
+	var err error
+	switch len(n.Args) {
+	case 1:
+		it, err = repo.setupArrayPathAggregate(funcName, n.Args[0], scope)
+	case 3:
+		it, err = repo.setupArrayFunc(funcName, n, 1, scope)
+	default:
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+	}
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var errOperand condition.Operand
+			var sum compensatedSum
+			count := 0
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					errOperand = values[0]
+					return false
+				case condition.NullOperandKind:
+					return true
+				}
+				v := values[0].Convert(condition.FloatOperandKind)
+				if v.GetKind() != condition.FloatOperandKind {
+					errOperand = v
+					return false
+				}
+				sum.add(float64(v.(condition.FloatOperand)))
+				count++
+				return true
+			}); r != nil {
+				return r
+			}
+			switch {
+			case errOperand != nil:
+				return errOperand
+			case count == 0:
+				return condition.NewNullOperand(nil)
+			case isAvg:
+				return condition.NewFloatOperand(sum.value() / float64(count))
+			}
+			return condition.NewFloatOperand(sum.value())
+		}, it.hashArgs(funcName)...)
+}
+
+// setupArrayPathAggregate sets up the iteration over the array elements, or over their attribute, denoted by the
+// constant element path of sum() and avg(), e.g. "order.items[].price".
+func (repo *CompareCondRepo) setupArrayPathAggregate(
+	funcName string, arg ast.Expr, scope *ForEachScope) (*arrayIterator, error) {
+	elementPath, err := repo.evalConstStringArg(funcName, arg, scope)
+	if err != nil {
+		return nil, err
+	}
+	path, field := elementPath, ""
+	if i := strings.Index(elementPath, "[]"); i >= 0 {
+		path, field = elementPath[:i], elementPath[i+2:]
+		if (field != "" && field[0] != '.') || strings.Contains(field, "[]") {
+			return nil, fmt.Errorf("%s() only supports paths of the form array[].attribute", funcName)
+		}
+	}
+	// The element name can't clash with the attribute names as it is not a valid identifier
+	element := "$" + funcName
+	var expr ast.Expr = ast.NewIdent(element)
+	if field != "" {
+		for _, attr := range strings.Split(field[1:], ".") {
+			expr = &ast.SelectorExpr{X: expr, Sel: ast.NewIdent(attr)}
+		}
+	}
+	return repo.newArrayIterator(path, element, []ast.Expr{expr}, scope)
+}
+
+// compensatedSum adds the floats with the Neumaier compensated summation, so that the rounding errors do not
+// accumulate over the additions, e.g. the amounts 0.1, 0.2 and 0.3 sum to the float nearest to 0.6.
+type compensatedSum struct {
+	sum, compensation float64
+}
+
+func (s *compensatedSum) add(v float64) {
+	t := s.sum + v
+	if math.Abs(s.sum) >= math.Abs(v) {
+		s.compensation += (s.sum - t) + v
+	} else {
+		s.compensation += (v - t) + s.sum
+	}
+	s.sum = t
+}
+
+func (s *compensatedSum) value() float64 {
+	return s.sum + s.compensation
+}
+
+// funcSumWhere implements sumWhere(arrayPath, element, valueExpr, cond) summing valueExpr over the array elements
+// for which cond is true, e.g. sumWhere("orders", "o", o.amount, o.status == "failed").  Undefined values are skipped.
+// The result is 0 when no element matches and undefined for a missing array.
+func funcSumWhere(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 4 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for sumWhere() function"))
+	}
+	it, err := repo.setupArrayFunc("sumWhere", n, 2, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var errOperand condition.Operand
+			var sum compensatedSum
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[1].GetKind() {
+				case condition.ErrorOperandKind:
+					errOperand = values[1]
+					return false
+				case condition.BooleanOperandKind:
+					if !values[1].(condition.BooleanOperand) {
+						return true
+					}
+				default:
+					return true
+				}
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					errOperand = values[0]
+					return false
+				case condition.NullOperandKind:
+					return true
+				}
+				v := values[0].Convert(condition.FloatOperandKind)
+				if v.GetKind() != condition.FloatOperandKind {
+					errOperand = v
+					return false
+				}
+				sum.add(float64(v.(condition.FloatOperand)))
+				return true
+			}); r != nil {
+				return r
+			}
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewFloatOperand(sum.value())
+		}, it.hashArgs("sumWhere")...)
+}
+
+// funcIndexOfFirst implements indexOfFirst(arrayPath, element, cond) returning the index of the first array element
+// for which cond is true, or -1 if there is no such element.  The result is undefined for a missing array.
+func funcIndexOfFirst(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for indexOfFirst() function"))
+	}
+	it, err := repo.setupArrayFunc("indexOfFirst", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var result condition.Operand = condition.NewIntOperand(-1)
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					result = values[0]
+					return false
+				case condition.BooleanOperandKind:
+					if values[0].(condition.BooleanOperand) {
+						result = condition.NewIntOperand(int64(i))
+						return false
+					}
+				}
+				return true
+			}); r != nil {
+				return r
+			}
+			return result
+		}, it.hashArgs("indexOfFirst")...)
+}
+
+// funcLongestRun implements longestRun(arrayPath, element, cond) returning the length of the longest run of
+// consecutive array elements for which cond is true, e.g. longestRun("attempts", "a", a.status == "failed") >= 3.
+// The elements with undefined cond break the run.  The result is 0 for an empty array and undefined for a missing one.
+func funcLongestRun(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for longestRun() function"))
+	}
+	it, err := repo.setupArrayFunc("longestRun", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var errOperand condition.Operand
+			longest, run := 0, 0
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind:
+					errOperand = values[0]
+					return false
+				case condition.BooleanOperandKind:
+					if values[0].(condition.BooleanOperand) {
+						run++
+						if run > longest {
+							longest = run
+						}
+						return true
+					}
+				}
+				run = 0
+				return true
+			}); r != nil {
+				return r
+			}
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewIntOperand(int64(longest))
+		}, it.hashArgs("longestRun")...)
+}
+
+// funcIncreasesByAtLeast implements increasesByAtLeast(arrayPath, element, expr, step) that is true when expr
+// increases by at least the constant step between every pair of the adjacent array elements, e.g.
+// increasesByAtLeast("readings", "r", r.value, 1).  Empty and single element arrays evaluate to true, a missing
+// array or an undefined expr of any element are undefined.
+func funcIncreasesByAtLeast(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 4 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for increasesByAtLeast() function"))
+	}
+	stepOperand := repo.evalAstNode(n.Args[3], scope)
+	if stepOperand.GetKind() == condition.ErrorOperandKind {
+		return stepOperand
+	}
+	if stepOperand.IsConst() {
+		stepOperand = stepOperand.Convert(condition.FloatOperandKind)
+	}
+	if !stepOperand.IsConst() || stepOperand.GetKind() != condition.FloatOperandKind {
+		return condition.NewErrorOperand(fmt.Errorf("increasesByAtLeast() only supports constant numeric step"))
+	}
+	step := float64(stepOperand.(condition.FloatOperand))
+	it, err := repo.setupArrayFunc("increasesByAtLeast", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	toFloat := func(v condition.Operand) condition.Operand {
+		switch v.GetKind() {
+		case condition.ErrorOperandKind, condition.NullOperandKind:
+			return v
+		}
+		return v.Convert(condition.FloatOperandKind)
+	}
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var result condition.Operand = condition.NewBooleanOperand(true)
+			if r := it.forConsecutivePairs(event, frames, func(i int, prev, cur []condition.Operand) bool {
+				a, b := toFloat(prev[0]), toFloat(cur[0])
+				for _, v := range []condition.Operand{a, b} {
+					if v.GetKind() != condition.FloatOperandKind {
+						result = v
+						return false
+					}
+				}
+				if float64(b.(condition.FloatOperand))-float64(a.(condition.FloatOperand)) < step {
+					result = condition.NewBooleanOperand(false)
+					return false
+				}
+				return true
+			}); r != nil {
+				return r
+			}
+			return result
+		}, it.hashArgs("increasesByAtLeast", stepOperand)...)
+}
+
+// funcContainsSubsequence implements containsSubsequence(arrayPath, element, expr, v1, v2, ...) that is true when
+// expr of a run of adjacent array elements is equal to the constants in order, e.g.
+// containsSubsequence("events", "e", e.name, "login", "purchase", "logout").  The elements with undefined expr
+// break the runs, so they never match a constant.  Empty arrays evaluate to false, while a missing array is undefined.
+func funcContainsSubsequence(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) < 4 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for containsSubsequence() function"))
+	}
+	pattern := make([]condition.Operand, len(n.Args)-3)
+	for i, arg := range n.Args[3:] {
+		pattern[i] = repo.evalAstNode(arg, scope)
+		if pattern[i].GetKind() == condition.ErrorOperandKind {
+			return pattern[i]
+		}
+		if !pattern[i].IsConst() {
+			return condition.NewErrorOperand(
+				fmt.Errorf("containsSubsequence() only supports constant subsequence values"))
+		}
+	}
+	it, err := repo.setupArrayFunc("containsSubsequence", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			var errOperand condition.Operand
+			// values are the expr values of the last len(pattern) elements, in a ring buffer
+			values := make([]condition.Operand, len(pattern))
+			found := false
+			if r := it.forEach(event, frames, func(i int, elemValues []condition.Operand) bool {
+				if elemValues[0].GetKind() == condition.ErrorOperandKind {
+					errOperand = elemValues[0]
+					return false
+				}
+				values[i%len(values)] = elemValues[0]
+				if i+1 < len(pattern) {
+					return true
+				}
+				start := i + 1 - len(pattern)
+				for j, expected := range pattern {
+					value := values[(start+j)%len(values)]
+					if value.GetKind() == condition.NullOperandKind {
+						return true
+					}
+					if equal := compareOperandValues(condition.CompareEqualOp, value, expected); equal.GetKind() !=
+						condition.BooleanOperandKind || !bool(equal.(condition.BooleanOperand)) {
+						return true
+					}
+				}
+				found = true
+				return false
+			}); r != nil {
+				return r
+			}
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewBooleanOperand(found)
+		}, it.hashArgs("containsSubsequence", pattern...)...)
+}
+
+// isArrayMembership tells whether the call is isIn(value, arrayAttr) testing the membership in an array attribute
+// rather than in a list of constants.
+func isArrayMembership(n *ast.CallExpr) bool {
+	if len(n.Args) != 2 {
+		return false
+	}
+	_, ok := astToAttributePath(n.Args[1])
+	return ok
+}
+
+// funcIsIn implements isIn(value, arrayAttr) testing that the value is equal to any element of the array attribute,
+// e.g. isIn(userRole, allowedRoles).  Empty array evaluates to false, while a missing array is undefined.
+// isIn(value, const1, const2, ...) with the constant match list is the same as isEqualToAny().
+func funcIsIn(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if !isArrayMembership(n) {
+		return repo.funcIsEqualToAny(n, scope)
+	}
+	valueOperand := repo.evalAstNode(n.Args[0], scope)
+	if valueOperand.GetKind() == condition.ErrorOperandKind {
+		return valueOperand
+	}
+	path, _ := astToAttributePath(n.Args[1])
+	// The element name can't clash with the attribute names as it is not a valid identifier
+	const element = "$isIn"
+	it, err := repo.newArrayIterator(path, element, []ast.Expr{ast.NewIdent(element)}, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			value := valueOperand.Evaluate(event, frames)
+			switch value.GetKind() {
+			case condition.ErrorOperandKind, condition.NullOperandKind:
+				return value
+			}
+			var result condition.Operand = condition.NewBooleanOperand(false)
+			if r := it.forEach(event, frames, func(i int, values []condition.Operand) bool {
+				switch values[0].GetKind() {
+				case condition.ErrorOperandKind, condition.NullOperandKind:
+					return true
+				}
+				if equal := compareOperandValues(condition.CompareEqualOp, value, values[0]); equal.GetKind() ==
+					condition.BooleanOperandKind && bool(equal.(condition.BooleanOperand)) {
+					result = equal
+					return false
+				}
+				return true
+			}); r != nil {
+				return r
+			}
+			return result
+		}, it.hashArgs("isIn", valueOperand)...)
+}
+
+// astToAttributePath returns the dotted attribute path of an identifier or selector expression, e.g. order.items.
+func astToAttributePath(node ast.Expr) (string, bool) {
+	switch n := node.(type) {
+	case *ast.Ident:
+		return n.Name, true
+	case *ast.SelectorExpr:
+		if base, ok := astToAttributePath(n.X); ok {
+			return base + "." + n.Sel.Name, true
+		}
+	case *ast.ParenExpr:
+		return astToAttributePath(n.X)
+	}
+	return "", false
+}
+
+// stringLength returns the number of elements of the list operand or the number of characters of the operand
+// converted to string.
+func stringLength(operand condition.Operand) condition.Operand {
+	switch operand.GetKind() {
+	case condition.ErrorOperandKind, condition.NullOperandKind:
+		return operand
+	case condition.ListOperandKind:
+		return condition.NewIntOperand(int64(len(operand.(*condition.ListOperand).List)))
+	}
+	s := operand.Convert(condition.StringOperandKind)
+	if s.GetKind() != condition.StringOperandKind {
+		return s
+	}
+	return condition.NewIntOperand(int64(utf8.RuneCountInString(string(s.(condition.StringOperand)))))
+}
+
+// funcLength implements length(x) returning the number of characters of a string or the number of elements of
+// an array.  Since the attribute type is not known until the event is matched, an attribute argument is
+// registered both as a scalar and as an array, and the one present in the event is measured.
+func funcLength(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 1 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for length() function"))
+	}
+	valueOperand := repo.evalAstNode(n.Args[0], scope)
+	if valueOperand.GetKind() == condition.ErrorOperandKind {
+		return valueOperand
+	}
+	path, ok := astToAttributePath(n.Args[0])
+	if !ok || valueOperand.IsConst() {
+		return repo.newFuncOperand("length", []condition.Operand{valueOperand},
+			func(args []condition.Operand) condition.Operand { return stringLength(args[0]) })
+	}
+
+	arrayAddress, err := getAttributePathAddress(path+"[]", scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	repo.registerCatEvaluatorForAddress(arrayAddress.FullAddress, scope.Evaluator)
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			array := objectmap.GetNestedAttributeByAddress(
+				frames[arrayAddress.ParentParameterIndex], arrayAddress.Address)
+			if elements, ok := array.([]interface{}); ok {
+				return condition.NewIntOperand(int64(len(elements)))
+			}
+			return stringLength(valueOperand.Evaluate(event, frames))
+		}, condition.NewStringOperand("length"), condition.NewStringOperand(arrayAddress.Path), valueOperand)
+}
+
+// funcEmptiness returns the implementation of isNotEmpty(x), testing that x is a non-empty string or array, or of
+// isEmpty(x), testing that x is undefined, null, the empty string or the empty array.  The arrays are detected the
+// same way as by length().
+func funcEmptiness(empty bool) boolFuncT {
+	funcName := "isNotEmpty"
+	if empty {
+		funcName = "isEmpty"
+	}
+	isEmpty := func(length condition.Operand) condition.Operand {
+		switch length.GetKind() {
+		case condition.ErrorOperandKind:
+			return length
+		case condition.IntOperandKind:
+			return condition.NewBooleanOperand((length.(condition.IntOperand) == 0) == empty)
+		}
+		// Undefined
+		return condition.NewBooleanOperand(empty)
+	}
+	return func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+		if len(n.Args) != 1 {
+			return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+		}
+		lengthOperand := funcLength(repo, n, scope)
+		if lengthOperand.IsConst() {
+			return isEmpty(lengthOperand)
+		}
+		return repo.CondFactory.NewExprOperand(
+			func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+				return isEmpty(lengthOperand.Evaluate(event, frames))
+			}, condition.NewStringOperand(funcName), lengthOperand)
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+)
+
+// MatchEvents matches a batch of events, returning the matches of each event at the same index.  Unlike calling
+// MatchEvent in a loop it reuses the attribute map, the evaluation buffers and the category match masks across the
+// events of the batch, which reduces the allocations per event.  The batches get their own buffers, so MatchEvents
+// may be called concurrently with separate batches.
+func (f *RuleEngine) MatchEvents(events []map[string]interface{}) [][]condition.RuleIdType {
+	mapper := f.compCondRepo.ObjectAttributeMapper
+	obj := mapper.AcquireObjectAttributeMap()
+	defer mapper.ReleaseObjectAttributeMap(obj)
+	scratch := newCategoryScratch()
+	matchScratch := f.catEngine.NewMatchScratch()
+
+	result := make([][]condition.RuleIdType, len(events))
+	for i, v := range events {
+		cats, timedOut := f.evalCategoriesScratch(scratch,
+			func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+				return mapper.MapObjectInto(obj, v, attrCallback, scratch.address)
+			}, nil)
+		result[i] = f.excludeRules(f.catEngine.MatchEventScratch(cats, matchScratch), timedOut)
+	}
+
+	f.metricsMu.Lock()
+	f.Metrics.NumCatEvals += scratch.stats.NumCatEvals
+	metrics := &f.catEngine.Metrics
+	metrics.NumMaskArrayLookups += matchScratch.Metrics.NumMaskArrayLookups
+	metrics.NumBitMaskChecks += matchScratch.Metrics.NumBitMaskChecks
+	metrics.NumBitMaskMatches += matchScratch.Metrics.NumBitMaskMatches
+	f.metricsMu.Unlock()
+	return result
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"container/list"
+	"github.com/atlasgurus/rulestone/condition"
+	"sync"
+)
+
+// ConditionCache is a bounded cache mapping rule expression text to the parsed expression condition.
+// It allows the identical expressions registered by different repos to be parsed only once.
+// The cache is safe for concurrent use.  The least recently used entries are evicted when the cache is full.
+type ConditionCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+	hits       uint64
+	misses     uint64
+}
+
+type conditionCacheEntry struct {
+	expr string
+	cond condition.Condition
+}
+
+func NewConditionCache(maxEntries int) *ConditionCache {
+	return &ConditionCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New()}
+}
+
+// Get returns the condition for the expression, parsing it and caching the result on a cache miss.
+// Expressions with syntax errors are not cached.
+func (c *ConditionCache) Get(expr string) condition.Condition {
+	c.mu.Lock()
+	if e, ok := c.entries[expr]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*conditionCacheEntry).cond
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Parse outside the lock, concurrent misses for the same expression simply race to insert it.
+	node, err := parseExpr(expr)
+	if err != nil {
+		return condition.NewErrorCondition(err)
+	}
+	cond := condition.NewParsedExprCondition(expr, node)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[expr]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*conditionCacheEntry).cond
+	}
+	c.entries[expr] = c.lru.PushFront(&conditionCacheEntry{expr: expr, cond: cond})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*conditionCacheEntry).expr)
+	}
+	return cond
+}
+
+// Len returns the number of cached expressions.
+func (c *ConditionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the number of cache hits and misses.
+func (c *ConditionCache) Stats() (hits uint64, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+var (
+	conditionCacheMu sync.RWMutex
+	conditionCache   *ConditionCache
+)
+
+// SetConditionCache enables the process level condition cache used when registering rules.
+// Passing nil disables the cache, which is the default.
+func SetConditionCache(cache *ConditionCache) {
+	conditionCacheMu.Lock()
+	defer conditionCacheMu.Unlock()
+	conditionCache = cache
+}
+
+// GetConditionCache returns the process level condition cache or nil if it is not enabled.
+func GetConditionCache() *ConditionCache {
+	conditionCacheMu.RLock()
+	defer conditionCacheMu.RUnlock()
+	return conditionCache
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+)
+
+// RegisterFunction registers the function callable from the rule expressions under the name, e.g.
+// isBusinessDay(date), to extend the built-in functions, which take precedence over the registered ones.  The
+// function gets the evaluated arguments, any number of them, and is not called when an argument is undefined or an
+// error, the result is then the same undefined or error value.  With all the arguments constant the function is
+// called once when the rule is compiled.  The identical calls are evaluated once per event.
+//
+// The functions must be registered before the rule engine referencing them is created with NewRuleEngine, and the
+// registration is not safe for concurrent use.  The function itself must be safe for concurrent calls if the events
+// are matched concurrently.
+func (repo *RuleEngineRepo) RegisterFunction(name string, fn func(args []condition.Operand) condition.Operand) {
+	if repo.functions == nil {
+		repo.functions = make(map[string]valueFuncT)
+	}
+	repo.functions[name] = fn
+}
+
+// customFunc returns the registered function of the name, if any.
+func (repo *CompareCondRepo) customFunc(name string) (valueFuncT, bool) {
+	fn, ok := repo.ruleEngineRepo.functions[name]
+	return fn, ok
+}
+
+// funcCustom returns the implementation of the call to the registered function.
+func funcCustom(fn valueFuncT) boolFuncT {
+	return func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+		funcName := n.Fun.(*ast.Ident).Name
+		return repo.compileValueFunc(funcName, n, len(n.Args), scope, fn)
+	}
+}
@@ -0,0 +1,513 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimeOfDay parses the "15:04" or "15:04:05" clock time and returns the offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Duration(t.Hour())*time.Hour +
+				time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day \"%s\", expected HH:MM or HH:MM:SS", s)
+}
+
+// toTimeArg converts the operand to time returning the error operand if the conversion fails.
+func toTimeArg(arg condition.Operand) (time.Time, condition.Operand) {
+	t := arg.Convert(condition.TimeOperandKind)
+	if t.GetKind() != condition.TimeOperandKind {
+		return time.Time{}, t
+	}
+	return time.Time(t.(condition.TimeOperand)), nil
+}
+
+// funcTimeOfDayBetween implements timeOfDayBetween(date, "09:00", "17:00") testing that the clock time of the date,
+// in the date's own time zone, is within the inclusive window.  The window wraps past midnight when the start
+// is after the end, e.g. "22:00" to "06:00".  Undefined date evaluates to false.
+func funcTimeOfDayBetween(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for timeOfDayBetween() function"))
+	}
+	var bounds [2]time.Duration
+	for i := range bounds {
+		s, err := repo.evalConstStringArg("timeOfDayBetween", n.Args[i+1], scope)
+		if err != nil {
+			return condition.NewErrorOperand(err)
+		}
+		if bounds[i], err = parseTimeOfDay(s); err != nil {
+			return condition.NewErrorOperand(err)
+		}
+	}
+	start, end := bounds[0], bounds[1]
+
+	dateOperand := repo.evalAstNode(n.Args[0], scope)
+	if dateOperand.GetKind() == condition.ErrorOperandKind {
+		return dateOperand
+	}
+	return repo.newFuncOperand("timeOfDayBetween",
+		[]condition.Operand{dateOperand, condition.NewFloatOperand(float64(start)), condition.NewFloatOperand(float64(end))},
+		func(args []condition.Operand) condition.Operand {
+			t, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			clock := time.Duration(t.Hour())*time.Hour +
+				time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second
+			if start <= end {
+				return condition.NewBooleanOperand(clock >= start && clock <= end)
+			}
+			return condition.NewBooleanOperand(clock >= start || clock <= end)
+		})
+}
+
+// funcDayOfMonthIn implements dayOfMonthIn(date, 1, 15) testing that the day of the month of the date, in the date's
+// own time zone, is one of the constant days.  The days past the end of a short month, e.g. 31 in April, never match
+// in it.  Undefined date is undefined.
+func funcDayOfMonthIn(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) < 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for dayOfMonthIn() function"))
+	}
+	dateOperand := repo.evalAstNode(n.Args[0], scope)
+	if dateOperand.GetKind() == condition.ErrorOperandKind {
+		return dateOperand
+	}
+	args := []condition.Operand{dateOperand}
+	var days [32]bool
+	for _, arg := range n.Args[1:] {
+		dayOperand := repo.evalAstNode(arg, scope)
+		if dayOperand.GetKind() == condition.ErrorOperandKind {
+			return dayOperand
+		}
+		if dayOperand.IsConst() {
+			dayOperand = dayOperand.Convert(condition.FloatOperandKind)
+		}
+		if !dayOperand.IsConst() || dayOperand.GetKind() != condition.FloatOperandKind {
+			return condition.NewErrorOperand(fmt.Errorf("dayOfMonthIn() only supports constant integer days"))
+		}
+		day := float64(dayOperand.(condition.FloatOperand))
+		if day != math.Trunc(day) || day < 1 || day > 31 {
+			return condition.NewErrorOperand(fmt.Errorf("invalid day %v passed to dayOfMonthIn(), expected 1 to 31", day))
+		}
+		days[int(day)] = true
+		args = append(args, dayOperand)
+	}
+	return repo.newFuncOperand("dayOfMonthIn", args,
+		func(args []condition.Operand) condition.Operand {
+			t, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewBooleanOperand(days[t.Day()])
+		})
+}
+
+// funcBusinessDaysBetween implements businessDaysBetween(a, b) returning the number of weekdays from the date of a
+// up to but excluding the date of b, e.g. 5 from a Monday to the next Monday.  The times of day are ignored and the
+// result is negative when b is before a.  businessDaysBetween(a, b, "holidays") does not count the weekdays listed
+// as YYYY-MM-DD dates in the named set registered with the repo, see RegisterSet.
+func funcBusinessDaysBetween(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return repo.compileValueFunc("businessDaysBetween", n, 2, scope, businessDaysBetween(nil))
+	}
+	nameOperand := repo.evalAstNode(n.Args[2], scope)
+	if nameOperand.GetKind() == condition.ErrorOperandKind {
+		return nameOperand
+	}
+	if !nameOperand.IsConst() || nameOperand.GetKind() != condition.StringOperandKind {
+		return condition.NewErrorOperand(
+			fmt.Errorf("the third operand of businessDaysBetween() must be a constant set name"))
+	}
+	name := string(nameOperand.(condition.StringOperand))
+	holidays := repo.ruleEngineRepo.getSet(name)
+	if holidays == nil {
+		return condition.NewErrorOperand(fmt.Errorf("unknown set: %s", name))
+	}
+	return repo.compileValueFunc("businessDaysBetween", n, 3, scope, businessDaysBetween(holidays))
+}
+
+// businessDaysBetween returns the businessDaysBetween() implementation excluding the holidays, if any.
+func businessDaysBetween(holidays *namedSet) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		var dates [2]time.Time
+		for i, arg := range args[:2] {
+			t, errOperand := toTimeArg(arg)
+			if errOperand != nil {
+				return errOperand
+			}
+			dates[i] = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		start, end := dates[0], dates[1]
+		sign := int64(1)
+		if end.Before(start) {
+			start, end, sign = end, start, -1
+		}
+		days := int64(end.Sub(start).Hours()) / 24
+		result := days / 7 * 5
+		for weekday := start.Weekday(); days%7 > 0; days-- {
+			if isWeekday(weekday) {
+				result++
+			}
+			weekday = (weekday + 1) % 7
+		}
+		if holidays != nil {
+			holidays.forEach(func(value string) {
+				day, err := time.Parse("2006-01-02", value)
+				if err == nil && !day.Before(start) && day.Before(end) && isWeekday(day.Weekday()) {
+					result--
+				}
+			})
+		}
+		return condition.NewIntOperand(sign * result)
+	}
+}
+
+func isWeekday(weekday time.Weekday) bool {
+	return weekday != time.Saturday && weekday != time.Sunday
+}
+
+// newDatePartFunc creates the function returning the part of its argument converted to date, in the date's own
+// time zone.
+func newDatePartFunc(part func(time.Time) int) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		t, errOperand := toTimeArg(args[0])
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewIntOperand(int64(part(t)))
+	}
+}
+
+// datePartFuncs implement year(date), month(date) etc. extracting the parts of the date.  The months are numbered
+// from 1 for January and the weekdays from 0 for Sunday.
+var datePartFuncs = map[string]valueFuncT{
+	"year":    newDatePartFunc(time.Time.Year),
+	"month":   newDatePartFunc(func(t time.Time) int { return int(t.Month()) }),
+	"day":     newDatePartFunc(time.Time.Day),
+	"hour":    newDatePartFunc(time.Time.Hour),
+	"minute":  newDatePartFunc(time.Time.Minute),
+	"weekday": newDatePartFunc(func(t time.Time) int { return int(t.Weekday()) }),
+}
+
+// dateUnits are the units of the dateDiff() results and of the afterByAtLeast() gaps.
+var dateUnits = map[string]time.Duration{
+	"milliseconds": time.Millisecond,
+	"seconds":      time.Second,
+	"minutes":      time.Minute,
+	"hours":        time.Hour,
+	"days":         24 * time.Hour,
+	"weeks":        7 * 24 * time.Hour,
+}
+
+// evalDateUnitArg evaluates the constant unit argument listed in dateUnits.
+func (repo *CompareCondRepo) evalDateUnitArg(
+	funcName string, arg ast.Expr, scope *ForEachScope) (string, time.Duration, error) {
+	unitName, err := repo.evalConstStringArg(funcName, arg, scope)
+	if err != nil {
+		return "", 0, err
+	}
+	unit, ok := dateUnits[unitName]
+	if !ok {
+		return "", 0, fmt.Errorf("unsupported %s() unit: %s", funcName, unitName)
+	}
+	return unitName, unit, nil
+}
+
+// funcDateDiff implements dateDiff(a, b, "hours") returning the fractional number of the constant units from the
+// date a until the date b.  The result is negative when b is before a.
+func funcDateDiff(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 3 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for dateDiff() function"))
+	}
+	unitName, unit, err := repo.evalDateUnitArg("dateDiff", n.Args[2], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	argOperands := make([]condition.Operand, 0, 3)
+	for _, arg := range n.Args[:2] {
+		argOperand := repo.evalAstNode(arg, scope)
+		if argOperand.GetKind() == condition.ErrorOperandKind {
+			return argOperand
+		}
+		argOperands = append(argOperands, argOperand)
+	}
+	return repo.newFuncOperand("dateDiff", append(argOperands, condition.NewStringOperand(unitName)),
+		func(args []condition.Operand) condition.Operand {
+			a, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			b, errOperand := toTimeArg(args[1])
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewFloatOperand(float64(b.Sub(a)) / float64(unit))
+		})
+}
+
+// funcAfterByAtLeast implements afterByAtLeast(a, b, amount, "hours") testing that the date a is at least the amount
+// of the constant units after the date b, e.g. that an order shipped at least an hour after it was paid.
+func funcAfterByAtLeast(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 4 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for afterByAtLeast() function"))
+	}
+	unitName, unit, err := repo.evalDateUnitArg("afterByAtLeast", n.Args[3], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+
+	argOperands := make([]condition.Operand, 0, 4)
+	for _, arg := range n.Args[:3] {
+		argOperand := repo.evalAstNode(arg, scope)
+		if argOperand.GetKind() == condition.ErrorOperandKind {
+			return argOperand
+		}
+		argOperands = append(argOperands, argOperand)
+	}
+	return repo.newFuncOperand("afterByAtLeast", append(argOperands, condition.NewStringOperand(unitName)),
+		func(args []condition.Operand) condition.Operand {
+			a, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			b, errOperand := toTimeArg(args[1])
+			if errOperand != nil {
+				return errOperand
+			}
+			amount := args[2].Convert(condition.FloatOperandKind)
+			if amount.GetKind() != condition.FloatOperandKind {
+				return amount
+			}
+			gap := time.Duration(float64(amount.(condition.FloatOperand)) * float64(unit))
+			return condition.NewBooleanOperand(!a.Before(b.Add(gap)))
+		})
+}
+
+// funcDate implements date(value) converting the value to date.  The strings without an explicit offset are in
+// the default time zone of the engine, see WithDefaultTimeZone.
+func funcDate(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	loc := repo.options.DefaultTimeZone
+	if loc == nil {
+		return repo.convertToType(n, scope, condition.TimeOperandKind)
+	}
+	return repo.compileValueFunc("date", n, 1, scope, func(args []condition.Operand) condition.Operand {
+		if s, ok := args[0].(condition.StringOperand); ok {
+			return condition.ParseTime(string(s), loc)
+		}
+		return args[0].Convert(condition.TimeOperandKind)
+	})
+}
+
+// newDateParseFunc creates the implementation of dateParse(value, layout) parsing the string with the Go reference
+// layout, e.g. "02/01/2006", rather than guessing the format like date() does.  The dates without an explicit offset
+// are in the loc time zone, or UTC when loc is nil.  The value not matching the layout is an error.
+func newDateParseFunc(loc *time.Location) valueFuncT {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		t, err := time.ParseInLocation(strs[1], strs[0], loc)
+		if err != nil {
+			return condition.NewErrorOperand(err)
+		}
+		return condition.NewTimeOperand(t)
+	}
+}
+
+// funcDateFormat implements dateFormat(date, layout) formatting the date with the Go reference layout.
+func funcDateFormat(args []condition.Operand) condition.Operand {
+	t, errOperand := toTimeArg(args[0])
+	if errOperand != nil {
+		return errOperand
+	}
+	strs, errOperand := toStringArgs(args[1:])
+	if errOperand != nil {
+		return errOperand
+	}
+	return condition.NewStringOperand(t.Format(strs[0]))
+}
+
+// funcInZone implements inZone(date, "America/New_York") returning the same instant in the constant time zone, so
+// that e.g. dateFormat() and timeOfDayBetween() see the local clock time of the zone.
+func funcInZone(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for inZone() function"))
+	}
+	name, err := repo.evalConstStringArg("inZone", n.Args[1], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	dateOperand := repo.evalAstNode(n.Args[0], scope)
+	if dateOperand.GetKind() == condition.ErrorOperandKind {
+		return dateOperand
+	}
+	return repo.newFuncOperand("inZone", []condition.Operand{dateOperand, condition.NewStringOperand(name)},
+		func(args []condition.Operand) condition.Operand {
+			t, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewTimeOperand(t.In(loc))
+		})
+}
+
+// parseBucketDuration parses the timeBucket() granularity in the time.ParseDuration format, e.g. "5m" or "1h",
+// or as the whole number of days, e.g. "1d".  The granularity must divide the day evenly, or be a whole number of
+// days, so that the windows are aligned on the UTC midnight.
+func parseBucketDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		var n int
+		if n, err = strconv.Atoi(days); err == nil {
+			d = time.Duration(n) * 24 * time.Hour
+		}
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeBucket() granularity \"%s\", expected e.g. 5m, 1h or 1d", s)
+	}
+	const day = 24 * time.Hour
+	if d < day && day%d != 0 || d > day && d%day != 0 {
+		return 0, fmt.Errorf(
+			"timeBucket() granularity \"%s\" must divide the day evenly or be a whole number of days", s)
+	}
+	return d, nil
+}
+
+// funcTimeBucket implements timeBucket(date, "1h") returning the start of the fixed window of the constant
+// granularity containing the date, e.g. the start of its hour, so that the dates of the same window compare equal.
+// The windows are aligned on the UTC midnight, and the result keeps the time zone of the date.
+func funcTimeBucket(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for timeBucket() function"))
+	}
+	granularity, err := repo.evalConstStringArg("timeBucket", n.Args[1], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	d, err := parseBucketDuration(granularity)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	dateOperand := repo.evalAstNode(n.Args[0], scope)
+	if dateOperand.GetKind() == condition.ErrorOperandKind {
+		return dateOperand
+	}
+	return repo.newFuncOperand("timeBucket", []condition.Operand{dateOperand, condition.NewStringOperand(granularity)},
+		func(args []condition.Operand) condition.Operand {
+			t, errOperand := toTimeArg(args[0])
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewTimeOperand(t.Truncate(d))
+		})
+}
+
+// eventNow returns the current time of the event evaluation, see RuleEngine.MatchEventAt.  The wall clock is read
+// once per event, so that all the time relative functions evaluated for the event see the same time.
+func eventNow(event *objectmap.ObjectAttributeMap) time.Time {
+	if event.Now.IsZero() {
+		event.Now = time.Now()
+	}
+	return event.Now
+}
+
+// funcNow implements now() returning the current time of the event evaluation, see eventNow.
+func funcNow(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 0 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for now() function"))
+	}
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			return condition.NewTimeOperand(eventNow(event))
+		}, condition.NewStringOperand("now"))
+}
+
+// funcDuration implements duration("24h") returning the constant duration in the time.ParseDuration format as
+// the number of nanoseconds, which is how the difference of two dates is represented.  Adding the duration to
+// a date or subtracting it from one yields a date, e.g. now() - duration("24h").
+func funcDuration(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 1 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for duration() function"))
+	}
+	s, err := repo.evalConstStringArg("duration", n.Args[0], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	return condition.NewFloatOperand(float64(d))
+}
+
+// evalTimeArithmetic evaluates the addition of the number of nanoseconds to a date, the subtraction of it from
+// a date, and the difference of the dates in nanoseconds.  It reports false for the other operands that follow
+// the numeric arithmetic.
+func evalTimeArithmetic(op token.Token, x, y condition.Operand) (condition.Operand, bool) {
+	xTime := x.GetKind() == condition.TimeOperandKind
+	yTime := y.GetKind() == condition.TimeOperandKind
+	switch {
+	case op == token.SUB && xTime && yTime:
+		return condition.NewFloatOperand(float64(time.Time(x.(condition.TimeOperand)).Sub(time.Time(y.(condition.TimeOperand))))), true
+	case op == token.ADD && !xTime && yTime:
+		x, y = y, x
+	case (op == token.ADD || op == token.SUB) && xTime && !yTime:
+	default:
+		return nil, false
+	}
+	d := y.Convert(condition.FloatOperandKind)
+	if d.GetKind() != condition.FloatOperandKind {
+		return d, true
+	}
+	if op == token.SUB {
+		d = -d.(condition.FloatOperand)
+	}
+	return condition.NewTimeOperand(time.Time(x.(condition.TimeOperand)).Add(time.Duration(d.(condition.FloatOperand)))), true
+}
+
+// funcDaysSince implements daysSince(date) returning the fractional number of days elapsed from the date until now.
+// The result is negative for the future dates.
+func funcDaysSince(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	args, err := repo.evalFuncArgs("daysSince", n, 1, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	dateOperand := args[0]
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			arg := dateOperand.Evaluate(event, frames)
+			switch arg.GetKind() {
+			case condition.ErrorOperandKind, condition.NullOperandKind:
+				return arg
+			}
+			t, errOperand := toTimeArg(arg)
+			if errOperand != nil {
+				return errOperand
+			}
+			return condition.NewFloatOperand(eventNow(event).Sub(t).Hours() / 24)
+		}, condition.NewStringOperand("daysSince"), dateOperand)
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/parser"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DecisionTableActionColumn is the name of the decision table column holding the rule action.
+// The action is stored in the rule metadata under the same name.
+const DecisionTableActionColumn = "action"
+
+// RegisterDecisionTableCSV registers a rule for each row of the decision table in CSV format.
+// The header row names the attributes tested by the condition columns and one action column named "action".
+// The constraints of the non-empty condition cells in a row are ANDed together.
+// Supported cell formats:
+//
+//	empty, "*" or "-"   don't care
+//	">100", ">=100"     greater, greater or equal
+//	"<100", "<=100"     less, less or equal
+//	"=gold", "!=gold"   equal, not equal
+//	"10..20"            inclusive range
+//	"gold|silver"       equal to any of the values
+//	"gold"              equal
+//
+// Values that parse as numbers are compared as numbers, other values as strings.
+// The metadata of each rule contains the action and the 1-based row number under "row".
+// The optional "priority" and "enabled" columns hold the integer priority and the boolean enabled flag of the rule,
+// see RulePriorityField and RuleEnabledField.  Their empty cells leave the defaults.  The other header cells must be
+// attribute paths, e.g. customer.tier.
+func (repo *RuleEngineRepo) RegisterDecisionTableCSV(r io.Reader) ([]uint, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, repo.ctx.Errorf("error reading decision table header: %s", err)
+	}
+
+	actionColumn, priorityColumn, enabledColumn := -1, -1, -1
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		switch {
+		case strings.EqualFold(header[i], DecisionTableActionColumn):
+			actionColumn = i
+		case strings.EqualFold(header[i], RulePriorityField):
+			priorityColumn = i
+		case strings.EqualFold(header[i], RuleEnabledField):
+			enabledColumn = i
+		case !isDecisionTableAttribute(header[i]):
+			return nil, repo.ctx.Errorf("decision table column %q is not an attribute path", header[i])
+		}
+	}
+	if actionColumn == -1 {
+		return nil, repo.ctx.Errorf("decision table is missing the %s column", DecisionTableActionColumn)
+	}
+
+	var rules []*InternalRule
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, repo.ctx.Errorf("error reading decision table row %d: %s", row, err)
+		}
+
+		metadata := map[string]interface{}{
+			DecisionTableActionColumn: strings.TrimSpace(record[actionColumn]),
+			"row":                     row}
+		var operands []condition.Condition
+		for i, cell := range record {
+			switch i {
+			case actionColumn:
+				continue
+			case priorityColumn, enabledColumn:
+				if err := setDecisionTableMetadata(metadata, header[i], strings.TrimSpace(cell)); err != nil {
+					return nil, repo.ctx.Errorf("decision table row %d column %s: %s", row, header[i], err)
+				}
+				continue
+			}
+			expr, err := decisionTableCellToExpr(header[i], strings.TrimSpace(cell))
+			if err != nil {
+				return nil, repo.ctx.Errorf("decision table row %d column %s: %s", row, header[i], err)
+			}
+			if expr != "" {
+				operands = append(operands, condition.NewExprCondition(expr))
+			}
+		}
+		if len(operands) == 0 {
+			return nil, repo.ctx.Errorf("decision table row %d has no conditions", row)
+		}
+
+		priority, err := metadataPriority(metadata)
+		if err != nil {
+			return nil, repo.ctx.Errorf("decision table row %d: %s", row, err)
+		}
+		enabled, err := metadataEnabled(metadata)
+		if err != nil {
+			return nil, repo.ctx.Errorf("decision table row %d: %s", row, err)
+		}
+		rules = append(rules, &InternalRule{
+			Metadata:  metadata,
+			Condition: condition.NewAndCond(operands...),
+			Priority:  priority,
+			Disabled:  !enabled})
+	}
+
+	// Register the rules only after the whole table is successfully read.
+	ruleIds := make([]uint, 0, len(rules))
+	for _, rule := range rules {
+		ruleIds = append(ruleIds, repo.Register(rule))
+	}
+	return ruleIds, nil
+}
+
+// isDecisionTableAttribute tells whether the header cell is an attribute path, e.g. tier or customer.tier.
+func isDecisionTableAttribute(column string) bool {
+	node, err := parser.ParseExpr(column)
+	if err != nil {
+		return false
+	}
+	path, ok := astToAttributePath(node)
+	return ok && path == column
+}
+
+// setDecisionTableMetadata stores the priority or enabled cell in the rule metadata, see RulePriorityField and
+// RuleEnabledField.  The empty cells are not stored.
+func setDecisionTableMetadata(metadata map[string]interface{}, column string, cell string) error {
+	if cell == "" {
+		return nil
+	}
+	if strings.EqualFold(column, RulePriorityField) {
+		priority, err := strconv.Atoi(cell)
+		if err != nil {
+			return fmt.Errorf("priority must be an integer: %q", cell)
+		}
+		metadata[RulePriorityField] = priority
+	} else {
+		enabled, err := strconv.ParseBool(cell)
+		if err != nil {
+			return fmt.Errorf("enabled must be a boolean: %q", cell)
+		}
+		metadata[RuleEnabledField] = enabled
+	}
+	return nil
+}
+
+// decisionTableCellToExpr converts a decision table cell to the rule expression constraining the attribute.
+// It returns an empty string for the don't care cells.
+func decisionTableCellToExpr(attribute string, cell string) (string, error) {
+	switch cell {
+	case "", "*", "-":
+		return "", nil
+	}
+
+	for _, op := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+		if strings.HasPrefix(cell, op) {
+			value := strings.TrimSpace(cell[len(op):])
+			if value == "" {
+				return "", fmt.Errorf("missing value in %q", cell)
+			}
+			if op == "=" {
+				op = "=="
+			}
+			return fmt.Sprintf("%s %s %s", attribute, op, decisionTableValue(value)), nil
+		}
+	}
+
+	if from, to, found := strings.Cut(cell, ".."); found {
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !isDecisionTableNumber(from) || !isDecisionTableNumber(to) {
+			return "", fmt.Errorf("range bounds must be numbers in %q", cell)
+		}
+		return fmt.Sprintf("(%s >= %s && %s <= %s)", attribute, from, attribute, to), nil
+	}
+
+	if strings.Contains(cell, "|") {
+		values := strings.Split(cell, "|")
+		for i, value := range values {
+			values[i] = decisionTableValue(strings.TrimSpace(value))
+		}
+		return fmt.Sprintf("isEqualToAny(%s, %s)", attribute, strings.Join(values, ", ")), nil
+	}
+
+	return fmt.Sprintf("%s == %s", attribute, decisionTableValue(cell)), nil
+}
+
+func isDecisionTableNumber(value string) bool {
+	f, err := strconv.ParseFloat(value, 64)
+	return err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// decisionTableValue converts the cell value to a numeric or quoted string literal.
+func decisionTableValue(value string) string {
+	if isDecisionTableNumber(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
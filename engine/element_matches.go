@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"github.com/atlasgurus/rulestone/types"
+	"sort"
+)
+
+// ElementMatch reports the array elements that satisfied a forSome() condition of a matched rule, or that were
+// counted by its count() comparison.
+type ElementMatch struct {
+	RuleId condition.RuleIdType
+	// Path is the array path of the forSome() condition, e.g. "items".
+	Path string
+	// Indexes are the indexes of the satisfying array elements in ascending order.
+	Indexes []int
+}
+
+// MatchEventElements matches the event like MatchEvent and also reports which array elements satisfied the
+// forSome() conditions of the matched rules, e.g. the orders that made the rule fire, and which were counted by
+// their count() comparisons, e.g. count("orders", "o", o.status == "failed") > 2.  Unlike MatchEvent all the
+// elements of the arrays are evaluated rather than stopping at the first match.  The forSome() calls nested in
+// other functions or in forAll() and forSome(), and the count() calls nested in forAll() and forSome(), or compared
+// with the count of another array, are not reported.
+func (f *RuleEngine) MatchEventElements(v interface{}) ([]condition.RuleIdType, []ElementMatch) {
+	matchedElements := make(map[types.Category][]int)
+	matches := f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			event := f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+			event.MatchedElements = matchedElements
+			return event
+		}))
+	if len(matchedElements) == 0 {
+		return matches, nil
+	}
+
+	matched := make(map[condition.RuleIdType]bool, len(matches))
+	for _, ruleId := range matches {
+		matched[ruleId] = true
+	}
+	var result []ElementMatch
+	for cat, indexes := range matchedElements {
+		path := f.compCondRepo.forSomePaths[cat]
+		if path == "" {
+			continue
+		}
+		for _, ruleId := range f.compCondRepo.categoryRules[cat] {
+			if matched[ruleId] {
+				result = append(result, ElementMatch{
+					RuleId: ruleId, Path: path, Indexes: indexes})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RuleId != result[j].RuleId {
+			return result[i].RuleId < result[j].RuleId
+		}
+		return result[i].Path < result[j].Path
+	})
+	return matches, result
+}
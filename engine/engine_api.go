@@ -2,23 +2,31 @@ package engine
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/atlasgurus/rulestone/cateng"
 	"github.com/atlasgurus/rulestone/condition"
 	"github.com/atlasgurus/rulestone/objectmap"
 	"github.com/atlasgurus/rulestone/types"
 	"github.com/zyedidia/generic/hashmap"
 	"github.com/zyedidia/generic/hashset"
-	"gopkg.in/yaml.v3"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ExternalRule struct {
-	Metadata   map[string]interface{} `json:"metadata,omitempty"`
-	Expression string                 `json:"expression"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// When defines the named clauses referenced by the expressions of the rules with clause("name").
+	When       map[string]string `json:"when,omitempty" yaml:"when,omitempty"`
+	Expression string            `json:"expression"`
 }
 
 type RuleApi struct {
@@ -28,41 +36,75 @@ type RuleApi struct {
 type InternalRule struct {
 	Metadata  map[string]interface{}
 	Condition condition.Condition
+	// Priority is the integer "priority" field of the metadata, 0 when missing.  It orders the results of
+	// MatchEventSorted.
+	Priority int
+	// Disabled is set when the "enabled" field of the metadata is false.  The disabled rules are compiled, but do
+	// not match until enabled with SetRuleEnabled.
+	Disabled bool
+	// When are the named clauses defined by the rule, see ExternalRule.When.
+	When map[string]string
+	// Tenant is the "tenant" field of the metadata, empty when missing.  The rules of a tenant are matched with
+	// MatchEventForTenant.
+	Tenant string
+	// Default is the "default" field of the metadata.  The default rules match only when no other rule does.
+	Default bool
 }
 
 func externalToInternalRule(rule *ExternalRule) (*InternalRule, error) {
-	cond := condition.NewExprCondition(rule.Expression)
+	var cond condition.Condition
+	if cache := GetConditionCache(); cache != nil {
+		cond = cache.Get(rule.Expression)
+	} else {
+		cond = condition.NewExprCondition(rule.Expression)
+	}
 	if cond.GetKind() == condition.ErrorCondKind {
 		return nil, cond.(*condition.ErrorCondition).Err
 	}
+	priority, err := metadataPriority(rule.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := metadataEnabled(rule.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := metadataTenant(rule.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	isDefault, err := metadataDefault(rule.Metadata)
+	if err != nil {
+		return nil, err
+	}
 	return &InternalRule{
 		Metadata:  rule.Metadata,
-		Condition: cond}, nil
+		Condition: cond,
+		Priority:  priority,
+		Disabled:  !enabled,
+		When:      rule.When,
+		Tenant:    tenant,
+		Default:   isDefault}, nil
 }
 
 func (api *RuleApi) ReadRules(r io.Reader, fileType string) ([]InternalRule, error) {
-	var result []ExternalRule
-
-	switch strings.ToLower(fileType) {
-	case "json":
-		decoder := json.NewDecoder(r)
-		if err := decoder.Decode(&result); err != nil {
-			return nil, api.ctx.Errorf("error parsing JSON: %s", err)
-		}
-	case "yaml", "yml":
-		decoder := yaml.NewDecoder(r)
-		if err := decoder.Decode(&result); err != nil {
-			return nil, api.ctx.Errorf("error parsing YAML: %s", err)
+	file, err := api.decodeRuleFile(r, fileType)
+	if err != nil {
+		return nil, err
+	}
+	result := file.Rules
+	macros := newMacroExpander(file.Macros)
+	for i := range result {
+		if err := macros.expandRuleMacros(&result[i]); err != nil {
+			return nil, api.ctx.Errorf("error expanding macros of rule %d: %s", i, err)
 		}
-	default:
-		return nil, api.ctx.Errorf("unsupported file type: %s", fileType)
 	}
 
 	internalRules := make([]InternalRule, len(result))
 	for i, rule := range result {
 		internalRule, err := externalToInternalRule(&rule)
 		if err != nil {
-			return nil, err
+			return nil, api.ctx.Errorf("error reading %s: %w", describeRule(i, rule.Metadata), err)
 		}
 		internalRules[i] = *internalRule
 	}
@@ -77,6 +119,10 @@ type RuleEngineRepo struct {
 	Rules   []*GeneralRuleRecord
 	ctx     *types.AppContext
 	ruleApi *RuleApi
+	sets    map[string]*namedSet
+	setsMu  sync.Mutex
+	// functions are the functions registered with RegisterFunction by name.
+	functions map[string]valueFuncT
 }
 
 func (repo *RuleEngineRepo) Register(f *InternalRule) uint {
@@ -85,6 +131,17 @@ func (repo *RuleEngineRepo) Register(f *InternalRule) uint {
 	return result
 }
 
+// UnregisterRule removes the rule from the repo, so that the rule engines built from the repo afterwards, with
+// NewRuleEngine, no longer compile and match it.  The ids of the other rules do not change and the ids of the removed
+// rules are not reused.  The engines built before are not affected.
+func (repo *RuleEngineRepo) UnregisterRule(id condition.RuleIdType) error {
+	if int(id) >= len(repo.Rules) || repo.Rules[id] == nil {
+		return repo.ctx.Errorf("unknown rule: %d", id)
+	}
+	repo.Rules[id] = nil
+	return nil
+}
+
 func (repo *RuleEngineRepo) RegisterRuleFromString(rule string, format string) (uint, error) {
 	r := strings.NewReader(rule)
 	rules, err := repo.ruleApi.ReadRules(r, format)
@@ -117,14 +174,33 @@ func (repo *RuleEngineRepo) RegisterRulesFromFile(path string) ([]uint, error) {
 }
 
 func RuleEngineRepoToCompareCondRepo(repo *RuleEngineRepo) (*CompareCondRepo, error) {
+	return newCompareCondRepo(repo, newOptions(nil))
+}
+
+func newCompareCondRepo(repo *RuleEngineRepo, options *Options) (*CompareCondRepo, error) {
 	result := CompareCondRepo{
 		CondToCompareCondRecord:      types.NewHashMap[condition.Condition, *EvalCategoryRec](),
 		CondToCategoryMap:            types.NewHashMap[condition.Condition, *hashmap.Map[condition.Operand, []condition.Operand]](),
 		CondToStringMatcher:          types.NewHashMap[condition.Condition, *StringMatcher](),
+		CondToPrefixMatcher:          types.NewHashMap[condition.Condition, *PrefixMatcher](),
 		AttributeToCompareCondRecord: make(map[string]*hashset.Set[*EvalCategoryRec]),
 		ObjectAttributeMapper:        objectmap.NewObjectAttributeMapper(repo),
 		CondFactory:                  condition.NewFactory(),
 		ctx:                          repo.ctx,
+		options:                      options,
+		ruleEngineRepo:               repo,
+		categoryRules:                make(map[types.Category][]condition.RuleIdType),
+		forSomePaths:                 make(map[types.Category]string),
+		aggregatePaths:               make(map[string]bool),
+		regexps:                      make(map[string]*regexp.Regexp),
+	}
+	if err := result.defineClauses(repo); err != nil {
+		return nil, err
+	}
+	if options.Schema != nil {
+		if err := result.validateSchema(repo, options.Schema); err != nil {
+			return nil, err
+		}
 	}
 
 	rootScope := &ForEachScope{
@@ -135,11 +211,23 @@ func RuleEngineRepoToCompareCondRepo(repo *RuleEngineRepo) (*CompareCondRepo, er
 		AttrDictRec:  result.ObjectAttributeMapper.RootDictRec}
 
 	for id, f := range repo.Rules {
+		if f == nil {
+			// The rule was unregistered
+			continue
+		}
 		cond := result.ConvertToCategoryCondition(f.definition.Condition, rootScope)
 		if cond.GetKind() == condition.ErrorCondKind {
-			return nil, cond.(*condition.ErrorCondition).Err
+			err := cond.(*condition.ErrorCondition).Err
+			var parseErr *ExprParseError
+			if errors.As(err, &parseErr) {
+				err = result.ctx.Errorf("error compiling %s: %w", describeRule(id, f.definition.Metadata), err)
+			}
+			return nil, err
 		}
 		result.RuleRepo.Register(condition.NewRule(condition.RuleIdType(id), cond))
+		forEachCategory(cond, func(cat types.Category) {
+			result.categoryRules[cat] = append(result.categoryRules[cat], condition.RuleIdType(id))
+		})
 	}
 
 	// Build the string matchers
@@ -150,6 +238,9 @@ func RuleEngineRepoToCompareCondRepo(repo *RuleEngineRepo) (*CompareCondRepo, er
 
 type RuleEngineMetrics struct {
 	NumCatEvals uint64
+	// NumRuleTimeouts counts the rules that did not match an event because the evaluation of their conditions
+	// exceeded the per rule timeout, see WithPerRuleTimeout.
+	NumRuleTimeouts uint64
 }
 
 type RuleEngine struct {
@@ -157,26 +248,257 @@ type RuleEngine struct {
 	catEngine    *cateng.CategoryEngine
 	compCondRepo *CompareCondRepo
 	Metrics      RuleEngineMetrics
+	// rules are the rule records by rule id at the time the engine was built, so that unregistering a rule from
+	// the repo afterwards does not affect the engine.
+	rules []*GeneralRuleRecord
+	// priorities are the priorities of the rules by rule id at the time the engine was built.
+	priorities []int
+	// tenantEngines are the category engines matching the rules of each tenant, see MatchEventForTenant.
+	tenantEngines map[string]*cateng.CategoryEngine
+	// disabled flags the disabled rules by rule id, accessed atomically.  numDisabled counts them.
+	disabled    []int32
+	numDisabled int32
+	// defaultRules flags the default rules by rule id, nil when there are none.
+	defaultRules []bool
+	// metricsMu serializes adding the metrics of the concurrent matches, e.g. of the MatchEvents batches.
+	metricsMu sync.Mutex
 }
 
-func NewRuleEngine(repo *RuleEngineRepo) (*RuleEngine, error) {
-	compCondRepo, err := RuleEngineRepoToCompareCondRepo(repo)
+func NewRuleEngine(repo *RuleEngineRepo, opts ...Option) (*RuleEngine, error) {
+	compCondRepo, err := newCompareCondRepo(repo, newOptions(opts))
 	if err != nil {
 		return nil, err
 	}
-	catEngine := cateng.NewCategoryEngine(&compCondRepo.RuleRepo, &cateng.Options{
+	catEngineOptions := &cateng.Options{
 		// TODO implement option passing
 		OrOptimizationFreqThreshold:  0,
 		AndOptimizationFreqThreshold: 1,
 		Verbose:                      true,
-	})
+	}
+	catEngine := cateng.NewCategoryEngine(&compCondRepo.RuleRepo, catEngineOptions)
 
-	return &RuleEngine{repo: repo, catEngine: catEngine, compCondRepo: compCondRepo}, nil
+	result := &RuleEngine{
+		repo: repo, catEngine: catEngine, compCondRepo: compCondRepo,
+		rules: append([]*GeneralRuleRecord(nil), repo.Rules...), priorities: rulePriorities(repo),
+		tenantEngines: newTenantEngines(repo.Rules, &compCondRepo.RuleRepo, catEngineOptions),
+		disabled:      make([]int32, len(repo.Rules)), defaultRules: defaultRuleFlags(repo.Rules)}
+	for id, rule := range result.rules {
+		if rule != nil && rule.definition.Disabled {
+			result.disabled[id] = 1
+			result.numDisabled++
+		}
+	}
+	return result, nil
 }
 
 func (f *RuleEngine) MatchEvent(v interface{}) []condition.RuleIdType {
-	matchingCompareCondRecords := types.NewHashSet[*EvalCategoryRec]()
-	event := f.compCondRepo.ObjectAttributeMapper.MapObject(v,
+	return f.matchCategories(f.evalEventCategories(v))
+}
+
+// MatchEventAt matches the event as if it happened at the given time, so that the time relative functions,
+// e.g. daysSince(), are evaluated against it instead of the wall clock.  It makes the matching reproducible.
+func (f *RuleEngine) MatchEventAt(v interface{}, at time.Time) []condition.RuleIdType {
+	return f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			event := f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+			event.Now = at
+			return event
+		}))
+}
+
+// MatchEventFunc calls fn for each rule matched by the event, avoiding the allocation of the result slice.
+// The matching stops when fn returns false.  The matching default rules are held back and passed to fn only
+// after no other rule matched.
+func (f *RuleEngine) MatchEventFunc(v interface{}, fn func(condition.RuleIdType) bool) {
+	cats, timedOut := f.evalEventCategories(v)
+	excluded := f.timedOutRules(timedOut)
+	var defaults []condition.RuleIdType
+	matched := false
+	f.catEngine.MatchEventFunc(cats, func(ruleId condition.RuleIdType) bool {
+		if excluded[ruleId] || f.ruleDisabled(ruleId) {
+			return true
+		}
+		if f.ruleDefault(ruleId) {
+			defaults = append(defaults, ruleId)
+			return true
+		}
+		matched = true
+		return fn(ruleId)
+	})
+	if matched {
+		return
+	}
+	for _, ruleId := range defaults {
+		if !fn(ruleId) {
+			return
+		}
+	}
+}
+
+// MatchEventFirst returns a rule matched by the event and false if there is none.  Unlike MatchEvent it stops at
+// the first rule found without collecting all the matches, e.g. for gating on whether any rule matches.
+// The result is deterministic for the same event and rules: it is the rule of the lowest RuleIndex among those
+// completed by the first matching category set, which is not necessarily the lowest id of all the matching rules.
+func (f *RuleEngine) MatchEventFirst(v interface{}) (condition.RuleIdType, bool) {
+	cats, timedOut := f.evalEventCategories(v)
+	excluded := f.timedOutRules(timedOut)
+	// The categories are evaluated in no particular order
+	sortCategories(cats)
+	var result, defaultRule condition.RuleIdType
+	found, foundDefault := false, false
+	f.catEngine.MatchEventFunc(cats, func(ruleId condition.RuleIdType) bool {
+		if excluded[ruleId] || f.ruleDisabled(ruleId) {
+			return true
+		}
+		if f.ruleDefault(ruleId) {
+			// Returned only if no other rule matches
+			if !foundDefault {
+				defaultRule, foundDefault = ruleId, true
+			}
+			return true
+		}
+		result, found = ruleId, true
+		return false
+	})
+	if !found {
+		return defaultRule, foundDefault
+	}
+	return result, found
+}
+
+// MatchProto matches the protobuf message against the rules referencing the message fields by their proto names.
+func (f *RuleEngine) MatchProto(msg proto.Message) []condition.RuleIdType {
+	return f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapProtoMessage(msg.ProtoReflect(), attrCallback)
+		}))
+}
+
+// MatchRecord matches the flat record, e.g. the CSV row, as the event with the top level attributes named by the
+// header columns.  The cells parsing as numbers are compared as numbers, and the empty cells, as well as the columns
+// missing from the row, are undefined.
+func (f *RuleEngine) MatchRecord(header []string, row []string) []condition.RuleIdType {
+	return f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapRecord(header, row, attrCallback)
+		}))
+}
+
+// MatchStruct matches the Go value, typically a pointer to a struct, as if it was the event decoded from its JSON
+// encoding, walking it by reflection instead of marshalling it.  The struct fields are named by their json tags.
+func (f *RuleEngine) MatchStruct(v interface{}) []condition.RuleIdType {
+	return f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapStruct(v, attrCallback)
+		}))
+}
+
+// MatchEventStream matches the JSON event read from r.  Unlike decoding the event first, only the attributes
+// referenced by the rules are materialized, which saves the memory for the large events with many irrelevant fields.
+// The quantified arrays, e.g. of forSome("orders", ...), keep all their elements with only the referenced fields.
+func (f *RuleEngine) MatchEventStream(r io.Reader) ([]condition.RuleIdType, error) {
+	v, err := f.compCondRepo.ObjectAttributeMapper.DecodeJSON(json.NewDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding JSON event: %w", err)
+	}
+	return f.MatchEvent(v), nil
+}
+
+// MatchWindow matches the window of events against the rules.  The window aggregates, e.g.
+// windowCount(status == "failed") > 5, are computed over all the events of the window, while the attributes
+// referenced outside the aggregates are taken from the last event.
+func (f *RuleEngine) MatchWindow(events []map[string]interface{}) []condition.RuleIdType {
+	if len(events) == 0 {
+		return nil
+	}
+	return f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			window := make([]*objectmap.ObjectAttributeMap, len(events))
+			for i, v := range events {
+				window[i] = f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+			}
+			event := window[len(window)-1]
+			event.Window = window
+			return event
+		}))
+}
+
+// DebugMatchedCategories returns the sorted categories that evaluated to true for the event,
+// before the category engine resolves them to the matching rules.
+func (f *RuleEngine) DebugMatchedCategories(v interface{}) []types.Category {
+	cats, _ := f.evalEventCategories(v)
+	result := make([]types.Category, 0, len(cats))
+	seen := make(map[types.Category]bool, len(cats))
+	for _, cat := range cats {
+		if !seen[cat] {
+			seen[cat] = true
+			result = append(result, cat)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// evalEventCategories evaluates the categories of the conditions that reference the attributes present in the event.
+// It also returns the categories whose evaluation exceeded the per rule timeout.
+func (f *RuleEngine) evalEventCategories(v interface{}) ([]types.Category, []types.Category) {
+	return f.evalMappedEventCategories(func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+		return f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+	})
+}
+
+// evalMappedEventCategories evaluates the categories for the event mapped by mapEvent.
+// It also returns the categories whose evaluation exceeded the per rule timeout.
+func (f *RuleEngine) evalMappedEventCategories(
+	mapEvent func(attrCallback func([]int)) *objectmap.ObjectAttributeMap) ([]types.Category, []types.Category) {
+	return f.recordMappedEventCategories(mapEvent, nil)
+}
+
+// recordMappedEventCategories evaluates the categories like evalMappedEventCategories, calling record, when not nil,
+// with the result of each evaluated category.
+func (f *RuleEngine) recordMappedEventCategories(
+	mapEvent func(attrCallback func([]int)) *objectmap.ObjectAttributeMap,
+	record func(cat types.Category, result condition.Operand)) ([]types.Category, []types.Category) {
+	eventCategories, timedOutCategories, _ := f.evalMappedEventStats(mapEvent, record)
+	return eventCategories, timedOutCategories
+}
+
+// evalMappedEventStats evaluates the categories like recordMappedEventCategories and also returns the evaluation
+// stats of the event.
+func (f *RuleEngine) evalMappedEventStats(
+	mapEvent func(attrCallback func([]int)) *objectmap.ObjectAttributeMap,
+	record func(cat types.Category, result condition.Operand)) ([]types.Category, []types.Category, MatchStats) {
+	scratch := newCategoryScratch()
+	eventCategories, timedOutCategories := f.evalCategoriesScratch(scratch, mapEvent, record)
+	f.Metrics.NumCatEvals += scratch.stats.NumCatEvals
+	f.compCondRepo.ObjectAttributeMapper.FreeObjects()
+	return eventCategories, timedOutCategories, scratch.stats
+}
+
+// categoryScratch holds the buffers for evaluating the event categories that can be reused across the events.
+type categoryScratch struct {
+	records    *hashset.Set[*EvalCategoryRec]
+	frameStack [20]interface{}
+	address    []int
+	// The categories are valid until the next evaluation with the scratch.
+	eventCategories, timedOutCategories []types.Category
+	// stats accumulates the evaluation stats of the events evaluated with the scratch.
+	stats MatchStats
+}
+
+func newCategoryScratch() *categoryScratch {
+	return &categoryScratch{records: types.NewHashSet[*EvalCategoryRec](), address: make([]int, 0, 20)}
+}
+
+// evalCategoriesScratch evaluates the categories like recordMappedEventCategories, using the buffers of the scratch.
+// The mapped event objects are not freed.
+func (f *RuleEngine) evalCategoriesScratch(
+	scratch *categoryScratch,
+	mapEvent func(attrCallback func([]int)) *objectmap.ObjectAttributeMap,
+	record func(cat types.Category, result condition.Operand)) ([]types.Category, []types.Category) {
+	matchingCompareCondRecords := scratch.records
+	matchingCompareCondRecords.Clear()
+	event := mapEvent(
 		// Callback for each attribute of interest found in the mapped event
 		func(addr []int) {
 			addrMatchId := objectmap.AddressMatchKey(addr)
@@ -188,11 +510,27 @@ func (f *RuleEngine) MatchEvent(v interface{}) []condition.RuleIdType {
 					})
 			}
 		})
-	var eventCategories []types.Category
-	var FrameStack = [20]interface{}{event.Values}
+	eventCategories, timedOutCategories := scratch.eventCategories[:0], scratch.timedOutCategories[:0]
+	FrameStack := scratch.frameStack[:]
+	FrameStack[0] = event.Values
+	timeout := f.compCondRepo.options.PerRuleTimeout
 	matchingCompareCondRecords.Each(func(catEvaluator *EvalCategoryRec) {
-		f.Metrics.NumCatEvals++
-		result := catEvaluator.Evaluate(event, FrameStack[:])
+		scratch.stats.NumCatEvals++
+		var start time.Time
+		if timeout > 0 {
+			start = time.Now()
+		}
+		if event.MatchedElements != nil {
+			event.EvalCategory = catEvaluator.GetCategory()
+		}
+		result := catEvaluator.Evaluate(event, FrameStack)
+		if timeout > 0 && time.Since(start) > timeout {
+			timedOutCategories = append(timedOutCategories, catEvaluator.GetCategory())
+			return
+		}
+		if record != nil {
+			record(catEvaluator.GetCategory(), result)
+		}
 		switch r := result.(type) {
 		case condition.ErrorOperand:
 			// TODO: find a way to report errors
@@ -215,13 +553,15 @@ func (f *RuleEngine) MatchEvent(v interface{}) []condition.RuleIdType {
 			panic("should not get here")
 		}
 	})
-	f.compCondRepo.ObjectAttributeMapper.FreeObjects()
-	return f.catEngine.MatchEvent(eventCategories)
+	scratch.stats.NumAttributeAccesses += event.NumAttributeAccesses
+	scratch.stats.NumIterations += event.NumIterations
+	scratch.eventCategories, scratch.timedOutCategories = eventCategories, timedOutCategories
+	return eventCategories, timedOutCategories
 }
 
 func (f *RuleEngine) GetRuleDefinition(ruleId uint) *InternalRule {
-	if ruleId >= 0 && int(ruleId) >= 0 && int(ruleId) < len(f.repo.Rules) {
-		return f.repo.Rules[ruleId].definition
+	if ruleId >= 0 && int(ruleId) >= 0 && int(ruleId) < len(f.rules) && f.rules[ruleId] != nil {
+		return f.rules[ruleId].definition
 	} else {
 		return nil
 	}
@@ -10,7 +10,6 @@ import (
 	"github.com/zyedidia/generic/hashmap"
 	"github.com/zyedidia/generic/hashset"
 	"go/ast"
-	"go/parser"
 	"go/printer"
 	"go/token"
 	"math"
@@ -82,11 +81,25 @@ type CompareCondRepo struct {
 	CondToCompareCondRecord      *hashmap.Map[condition.Condition, *EvalCategoryRec]
 	CondToCategoryMap            *hashmap.Map[condition.Condition, *hashmap.Map[condition.Operand, []condition.Operand]]
 	CondToStringMatcher          *hashmap.Map[condition.Condition, *StringMatcher]
+	CondToPrefixMatcher          *hashmap.Map[condition.Condition, *PrefixMatcher]
 	EvalCategoryRecs             []*EvalCategoryRec
 	RuleRepo                     condition.RuleRepo
 	ObjectAttributeMapper        *objectmap.ObjectAttributeMapper
 	CondFactory                  *condition.Factory
 	ctx                          *types.AppContext
+	options                      *Options
+	ruleEngineRepo               *RuleEngineRepo
+	// categoryRules maps the categories to the rules referencing them.
+	categoryRules map[types.Category][]condition.RuleIdType
+	// forSomePaths maps the categories of the forSome() conditions and of the comparisons of count() to their array
+	// paths, or to "" when a comparison counts the elements of several arrays.
+	forSomePaths map[types.Category]string
+	// aggregatePaths are the attribute paths of the rolling aggregates referenced by the rules, e.g. @avg("amount").
+	aggregatePaths map[string]bool
+	// clauses are the named clauses defined by the rules, see ExternalRule.When.
+	clauses map[string]*namedClause
+	// regexps are the compiled patterns of the regexp functions by pattern, shared by the rules using the same one.
+	regexps map[string]*regexp.Regexp
 }
 
 func (repo *CompareCondRepo) NewEvalCategoryRec(eval condition.Operand) *EvalCategoryRec {
@@ -105,6 +118,7 @@ func (repo *CompareCondRepo) DiscardEvalCategoryRec(evalCategoryRec *EvalCategor
 	}
 	// Remove the last one
 	repo.EvalCategoryRecs = repo.EvalCategoryRecs[:len(repo.EvalCategoryRecs)-1]
+	delete(repo.forSomePaths, evalCategoryRec.Cat)
 }
 
 // ConvertToCategoryCondition this has to be called from the root condition or and/or/not boolean operator
@@ -191,6 +205,15 @@ func (repo *CompareCondRepo) genEvalForLogicalOp(
 		}, xEval, yEval)
 }
 
+// evalRemainder computes x % y with the sign of x.  math.Mod is exact, so the remainder of the integers is the same
+// as the integer remainder.  Unlike division, which yields infinity, modulo by zero is an error.
+func evalRemainder(x, y float64) condition.Operand {
+	if y == 0 {
+		return condition.NewErrorOperand(fmt.Errorf("modulo by zero"))
+	}
+	return condition.NewFloatOperand(math.Mod(x, y))
+}
+
 func (repo *CompareCondRepo) genEvalForCompareOperands(
 	compOp condition.CompareOp,
 	xEval condition.Operand,
@@ -198,37 +221,109 @@ func (repo *CompareCondRepo) genEvalForCompareOperands(
 
 	return repo.CondFactory.NewExprOperand(
 		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
-			X := xEval.Evaluate(event, frames)
-			xKind := X.GetKind()
-			Y := yEval.Evaluate(event, frames)
-			yKind := Y.GetKind()
-			if xKind == condition.ErrorOperandKind {
-				return X
+			return compareOperandValues(compOp, xEval.Evaluate(event, frames), yEval.Evaluate(event, frames))
+		}, xEval, yEval, repo.CondFactory.NewIntOperand(int64(compOp)))
+}
+
+// genEvalForArithmeticCompare compares the operands when at least one of them is an arithmetic result.
+// Unlike genEvalForCompareOperands, a null operand fails the comparison for every operator, so that neither
+// amount <= avgAmount * 3 nor amount != avgAmount * 3 matches an event without avgAmount.
+func (repo *CompareCondRepo) genEvalForArithmeticCompare(
+	compOp condition.CompareOp,
+	xEval condition.Operand,
+	yEval condition.Operand) condition.Operand {
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			xVal := xEval.Evaluate(event, frames)
+			if xVal.GetKind() == condition.NullOperandKind {
+				return condition.NewBooleanOperand(false)
 			}
-			if yKind == condition.ErrorOperandKind {
-				return Y
+			yVal := yEval.Evaluate(event, frames)
+			if yVal.GetKind() == condition.NullOperandKind {
+				return condition.NewBooleanOperand(false)
 			}
+			return compareOperandValues(compOp, xVal, yVal)
+		}, xEval, yEval, repo.CondFactory.NewIntOperand(int64(compOp)), condition.StringOperand("arithmetic"))
+}
 
-			// Convert toward the higher kind, e.g. int -> float -> bool -> string
-			X, Y = condition.ReconcileOperands(X, Y)
-
-			switch compOp {
-			case condition.CompareEqualOp:
-				return condition.NewBooleanOperand(X.Equals(Y))
-			case condition.CompareNotEqualOp:
-				return condition.NewBooleanOperand(!X.Equals(Y))
-			case condition.CompareGreaterOp:
-				return condition.NewBooleanOperand(X.Greater(Y))
-			case condition.CompareGreaterOrEqualOp:
-				return condition.NewBooleanOperand(!Y.Greater(X))
-			case condition.CompareLessOp:
-				return condition.NewBooleanOperand(Y.Greater(X))
-			case condition.CompareLessOrEqualOp:
-				return condition.NewBooleanOperand(!X.Greater(Y))
-			default:
-				panic("Not implemented")
-			}
-		}, xEval, yEval, repo.CondFactory.NewIntOperand(int64(compOp)))
+// compareTokenOps maps the comparison tokens to the compare operators, negatedCompareTokenOps to
+// the operators of their negation.
+var compareTokenOps = map[token.Token]condition.CompareOp{
+	token.EQL: condition.CompareEqualOp,
+	token.NEQ: condition.CompareNotEqualOp,
+	token.LSS: condition.CompareLessOp,
+	token.GTR: condition.CompareGreaterOp,
+	token.LEQ: condition.CompareLessOrEqualOp,
+	token.GEQ: condition.CompareGreaterOrEqualOp,
+}
+
+var negatedCompareTokenOps = map[token.Token]condition.CompareOp{
+	token.EQL: condition.CompareNotEqualOp,
+	token.NEQ: condition.CompareEqualOp,
+	token.LSS: condition.CompareGreaterOrEqualOp,
+	token.GTR: condition.CompareLessOrEqualOp,
+	token.LEQ: condition.CompareGreaterOp,
+	token.GEQ: condition.CompareLessOp,
+}
+
+// isArithmeticExpr reports whether the node computes an arithmetic result, e.g. avgAmount * 3.
+func isArithmeticExpr(node ast.Expr) bool {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return isArithmeticExpr(n.X)
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
+			return true
+		}
+	}
+	return false
+}
+
+// arithmeticComparison returns the comparison denoted by the node if one of its sides is an arithmetic result.
+func arithmeticComparison(node ast.Expr) (*ast.BinaryExpr, bool) {
+	if p, ok := node.(*ast.ParenExpr); ok {
+		return arithmeticComparison(p.X)
+	}
+	n, ok := node.(*ast.BinaryExpr)
+	if !ok {
+		return nil, false
+	}
+	if _, ok = compareTokenOps[n.Op]; !ok {
+		return nil, false
+	}
+	return n, isArithmeticExpr(n.X) || isArithmeticExpr(n.Y)
+}
+
+// compareOperandValues compares the evaluated operands.
+func compareOperandValues(compOp condition.CompareOp, X condition.Operand, Y condition.Operand) condition.Operand {
+	if X.GetKind() == condition.ErrorOperandKind {
+		return X
+	}
+	if Y.GetKind() == condition.ErrorOperandKind {
+		return Y
+	}
+
+	// Convert toward the higher kind, e.g. int -> float -> bool -> string
+	X, Y = condition.ReconcileOperands(X, Y)
+
+	switch compOp {
+	case condition.CompareEqualOp:
+		return condition.NewBooleanOperand(X.Equals(Y))
+	case condition.CompareNotEqualOp:
+		return condition.NewBooleanOperand(!X.Equals(Y))
+	case condition.CompareGreaterOp:
+		return condition.NewBooleanOperand(X.Greater(Y))
+	case condition.CompareGreaterOrEqualOp:
+		return condition.NewBooleanOperand(!Y.Greater(X))
+	case condition.CompareLessOp:
+		return condition.NewBooleanOperand(Y.Greater(X))
+	case condition.CompareLessOrEqualOp:
+		return condition.NewBooleanOperand(!X.Greater(Y))
+	default:
+		panic("Not implemented")
+	}
 }
 
 // processCompareEqualToConstCondition: Special case equal compare against a constant that can be done via a hash lookup
@@ -250,6 +345,32 @@ func (repo *CompareCondRepo) processCompareEqualToConstCondition(
 	return repo.processEvalForIsInConstantList(varOperand, []condition.Operand{constOperand}, scope)
 }
 
+// categoryMapKey normalizes the numeric operands to floats, so that the integers, e.g. computed by functions,
+// are found in the categoryMap by the float numeric literals and vice versa.  With the LenientNumericStrings option
+// the numeric strings are normalized to floats too, so that e.g. "12345" is found by 12345.
+func (repo *CompareCondRepo) categoryMapKey(operand condition.Operand) condition.Operand {
+	switch operand.GetKind() {
+	case condition.IntOperandKind:
+		return operand.Convert(condition.FloatOperandKind)
+	case condition.StringOperandKind:
+		if repo.options.LenientNumericStrings {
+			if f, ok := numericString(string(operand.(condition.StringOperand))); ok {
+				return condition.NewFloatOperand(f)
+			}
+		}
+	}
+	return operand
+}
+
+// numericString parses the string that is a finite decimal number, e.g. "12345" or "-1.5e3".
+func numericString(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
 // processEvalForIsInConstantList
 func (repo *CompareCondRepo) processEvalForIsInConstantList(
 	varOperand condition.Operand, consOperandList []condition.Operand, scope *ForEachScope) condition.Operand {
@@ -268,6 +389,7 @@ func (repo *CompareCondRepo) processEvalForIsInConstantList(
 
 	// Create an entry in the categoryMap for each of the consOperandList
 	for _, constOperand := range consOperandList {
+		constOperand = repo.categoryMapKey(constOperand)
 		categoryList, _ := categoryMap.Get(constOperand)
 		categoryMap.Put(
 			constOperand,
@@ -288,7 +410,7 @@ func (repo *CompareCondRepo) processEvalForIsInConstantList(
 			if xKind == condition.ErrorOperandKind {
 				return X
 			}
-			catList, k := categoryMap.Get(X)
+			catList, k := categoryMap.Get(repo.categoryMapKey(X))
 			if k {
 				return condition.NewListOperand(catList)
 			} else {
@@ -299,6 +421,37 @@ func (repo *CompareCondRepo) processEvalForIsInConstantList(
 
 func (repo *CompareCondRepo) processEvalForContains(
 	varOperand condition.Operand, stringsToMatch []string, scope *ForEachScope) condition.Operand {
+	return repo.processEvalForPatterns("containsAny", varOperand, stringsToMatch, scope,
+		func(cond condition.Condition) (patternMatcher, bool) {
+			stringMatcher, seenCond := repo.CondToStringMatcher.Get(cond)
+			if !seenCond {
+				stringMatcher = NewStringMatcher()
+				repo.CondToStringMatcher.Put(cond, stringMatcher)
+			}
+			return stringMatcher, seenCond
+		})
+}
+
+// processEvalForPrefixes is the same as processEvalForContains for the prefixes of startsWith().
+func (repo *CompareCondRepo) processEvalForPrefixes(
+	varOperand condition.Operand, prefixes []string, scope *ForEachScope) condition.Operand {
+	return repo.processEvalForPatterns("startsWith", varOperand, prefixes, scope,
+		func(cond condition.Condition) (patternMatcher, bool) {
+			prefixMatcher, seenCond := repo.CondToPrefixMatcher.Get(cond)
+			if !seenCond {
+				prefixMatcher = NewPrefixMatcher()
+				repo.CondToPrefixMatcher.Put(cond, prefixMatcher)
+			}
+			return prefixMatcher, seenCond
+		})
+}
+
+// processEvalForPatterns registers the patterns matched against the variable operand with the matcher shared by all
+// the conditions matching the same operand, found or created by matcherFor, so that a single evaluation of the
+// matcher produces the categories of all the matching patterns.
+func (repo *CompareCondRepo) processEvalForPatterns(
+	funcName string, varOperand condition.Operand, stringsToMatch []string, scope *ForEachScope,
+	matcherFor func(cond condition.Condition) (patternMatcher, bool)) condition.Operand {
 	varOperand = repo.evalOperandAccess(repo.evalOperandAddress(varOperand, scope), scope)
 	if varOperand.GetKind() == condition.ErrorOperandKind {
 		return varOperand
@@ -306,15 +459,11 @@ func (repo *CompareCondRepo) processEvalForContains(
 
 	// Create a dummy compare operation ignoring the stringsToMatch value and look it up
 	dummyCondition := condition.NewCompareCond(condition.CompareContainsOp, varOperand, condition.NewIntOperand(0))
-	stringMatcher, seenCond := repo.CondToStringMatcher.Get(dummyCondition)
-	if !seenCond {
-		stringMatcher = NewStringMatcher()
-		repo.CondToStringMatcher.Put(dummyCondition, stringMatcher)
-	}
+	matcher, seenCond := matcherFor(dummyCondition)
 
-	// Create an entry in the stringMatcher for each of the stringsToMatch
+	// Create an entry in the matcher for each of the stringsToMatch
 	for _, constOperand := range stringsToMatch {
-		stringMatcher.AddPattern(constOperand, condition.NewIntOperand(int64(scope.Evaluator.GetCategory())))
+		matcher.AddPattern(constOperand, condition.NewIntOperand(int64(scope.Evaluator.GetCategory())))
 	}
 
 	if seenCond {
@@ -331,13 +480,13 @@ func (repo *CompareCondRepo) processEvalForContains(
 			if xKind == condition.ErrorOperandKind {
 				return X
 			}
-			catList := stringMatcher.Match(string(X.(condition.StringOperand)))
+			catList := matcher.Match(string(X.(condition.StringOperand)))
 			if len(catList) > 0 {
 				return condition.NewListOperand(catList)
 			} else {
 				return condition.IntConst0
 			}
-		}, varOperand)
+		}, condition.NewStringOperand(funcName), varOperand) // funcName as hash seed to avoid cache collisions
 }
 
 type timeRange struct {
@@ -425,10 +574,18 @@ func (repo *CompareCondRepo) genEvalForCompareCondition(
 	return repo.genEvalForCompareOperands(compareCond.CompareOp, lOperand, rOperand)
 }
 
+// parseExprCondition returns the AST of the expression, reusing the one parsed at rule registration if any.
+func parseExprCondition(exprCondition *condition.ExprCondition) (ast.Expr, error) {
+	if exprCondition.Node != nil {
+		return exprCondition.Node, nil
+	}
+	return parseExpr(exprCondition.Expr)
+}
+
 func (repo *CompareCondRepo) genEvalForExprCondition(
 	exprCondition *condition.ExprCondition, scope *ForEachScope) condition.Operand {
 	// Convert the expression to an AST node tree
-	node, err := parser.ParseExpr(exprCondition.Expr)
+	node, err := parseExprCondition(exprCondition)
 
 	if err != nil {
 		return condition.NewErrorOperand(repo.ctx.LogError(err))
@@ -523,7 +680,7 @@ func (repo *CompareCondRepo) genEvalForAndCondition(
 				result := eval.Func(event, frames)
 				if result.GetKind() == condition.ErrorOperandKind {
 					return result
-				} else if !result.(condition.BooleanOperand) {
+				} else if !isTrueOperand(result) {
 					return condition.NewBooleanOperand(false)
 				}
 			}
@@ -549,7 +706,7 @@ func (repo *CompareCondRepo) genEvalForOrCondition(
 				result := eval.Func(event, frames)
 				if result.GetKind() == condition.ErrorOperandKind {
 					return result
-				} else if result.(condition.BooleanOperand) {
+				} else if isTrueOperand(result) {
 					return condition.NewBooleanOperand(true)
 				}
 			}
@@ -630,6 +787,9 @@ func (repo *CompareCondRepo) setupEvalForEach(parentScope *ForEachScope, element
 	}
 }
 
+// genEvalForAllCondition generates the evaluator of forAll().  An empty array is false, the same as a missing one,
+// even though the empty arrays are recorded in the event to tell them from the missing ones, e.g. for allDistinct().
+// The entries of an empty object are true for forEachEntry().
 func (repo *CompareCondRepo) genEvalForAllCondition(
 	path string, element string, cond condition.Condition, parentScope *ForEachScope) condition.Operand {
 
@@ -638,6 +798,7 @@ func (repo *CompareCondRepo) genEvalForAllCondition(
 		return condition.NewErrorOperand(err)
 	} else {
 		nestingLevel := newScope.NestingLevel
+		emptyResult := isEntriesPath(path)
 
 		eval := repo.genEvalForCondition(cond, newScope)
 		if eval.GetKind() == condition.ErrorOperandKind {
@@ -656,9 +817,10 @@ func (repo *CompareCondRepo) genEvalForAllCondition(
 				currentAddress := types.GetIntSlice()
 				currentAddress = append(currentAddress, arrayAddress.Address...)
 				currentAddress = append(currentAddress, 0)
-				var result condition.Operand = condition.NewBooleanOperand(true)
+				var result condition.Operand = condition.NewBooleanOperand(numElements > 0 || emptyResult)
 				for i := 0; i < numElements; i++ {
 					currentAddress[currentAddressLen] = i
+					event.NumIterations++
 					newFrame := objectmap.GetNestedAttributeByAddress(parentsFrame, currentAddress)
 					if newFrame == nil {
 						// TODO: record diagnostics somewhere that attribute is not available
@@ -671,7 +833,9 @@ func (repo *CompareCondRepo) genEvalForAllCondition(
 					result = eval.Evaluate(event, frames)
 					if result.GetKind() == condition.ErrorOperandKind {
 						break
-					} else if !result.(condition.BooleanOperand) {
+					} else if !isTrueOperand(result) {
+						// Undefined is false
+						result = condition.NewBooleanOperand(false)
 						break
 					}
 				}
@@ -704,8 +868,10 @@ func (repo *CompareCondRepo) processForAllCondition(
 	return condition.NewCategoryCond(evalCatRec.GetCategory())
 }
 
+// genEvalForSomeCondition generates the evaluator of forSome().  When cat is not nil and the event collects the
+// matched elements, all the elements are evaluated and the indexes of the matching ones are recorded under *cat.
 func (repo *CompareCondRepo) genEvalForSomeCondition(
-	path string, element string, cond condition.Condition, parentScope *ForEachScope) condition.Operand {
+	path string, element string, cond condition.Condition, parentScope *ForEachScope, cat *types.Category) condition.Operand {
 	// ARRAY_ELEMENT issue
 	arrayAddress, newScope, err := repo.setupEvalForEach(parentScope, element, path)
 	if err != nil {
@@ -729,9 +895,12 @@ func (repo *CompareCondRepo) genEvalForSomeCondition(
 				currentAddress := types.GetIntSlice()
 				currentAddress = append(currentAddress, arrayAddress.Address...)
 				currentAddress = append(currentAddress, 0)
+				record := cat != nil && event.MatchedElements != nil
+				matched := false
 				var result condition.Operand = condition.NewBooleanOperand(false)
 				for i := 0; i < numElements; i++ {
 					currentAddress[currentAddressLen] = i
+					event.NumIterations++
 					newFrame := objectmap.GetNestedAttributeByAddress(parentsFrame, currentAddress)
 					if newFrame == nil {
 						// TODO: record diagnostics somewhere that attribute is not available
@@ -743,16 +912,105 @@ func (repo *CompareCondRepo) genEvalForSomeCondition(
 					result = eval.Evaluate(event, frames)
 					if result.GetKind() == condition.ErrorOperandKind {
 						break
-					} else if result.(condition.BooleanOperand) {
-						break
+					} else if isTrueOperand(result) {
+						if !record {
+							break
+						}
+						matched = true
+						event.MatchedElements[*cat] = append(event.MatchedElements[*cat], i)
+					} else {
+						// Undefined is false
+						result = condition.NewBooleanOperand(false)
 					}
 				}
 				// Return true unless at least one is false
 				types.PutIntSlice(currentAddress)
 
+				if matched {
+					return condition.NewBooleanOperand(true)
+				}
 				return result
-			}, eval)
+			}, forSomeHashArgs(eval, cat)...)
+	}
+}
+
+// forSomeHashArgs returns the hash args of the forSome() evaluator, so that the evaluators recording the matched
+// elements are not deduped with those that do not.
+func forSomeHashArgs(eval condition.Operand, cat *types.Category) []condition.Operand {
+	if cat == nil {
+		return []condition.Operand{eval}
+	}
+	return []condition.Operand{eval, condition.NewStringOperand("recordElements")}
+}
+
+// registerElementPath records the array path of the elements reported under the category.  The comparisons counting
+// the elements of several arrays are not reported.
+func (repo *CompareCondRepo) registerElementPath(cat types.Category, path string) {
+	if prev, ok := repo.forSomePaths[cat]; ok && prev != path {
+		path = ""
+	}
+	repo.forSomePaths[cat] = path
+}
+
+// genEvalForCountCondition generates the evaluator of count() returning the number of the array elements for which
+// cond is true.  The result is undefined for a missing array.  At the rule level the indexes of the counted elements
+// are recorded under the category being evaluated when the event collects the matched elements.
+func (repo *CompareCondRepo) genEvalForCountCondition(
+	path string, element string, cond condition.Condition, parentScope *ForEachScope) condition.Operand {
+	arrayAddress, newScope, err := repo.setupEvalForEach(parentScope, element, path)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	nestingLevel := newScope.NestingLevel
+	eval := repo.genEvalForCondition(cond, newScope)
+	if eval.GetKind() == condition.ErrorOperandKind {
+		return eval
+	}
+	// Make sure the enclosing category is evaluated whenever the array is present in the event.
+	repo.registerCatEvaluatorForAddress(arrayAddress.FullAddress, parentScope.Evaluator)
+	record := parentScope.ParentScope == nil && parentScope.Evaluator != nil
+	if record {
+		repo.registerElementPath(parentScope.Evaluator.GetCategory(), path)
 	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			parentsFrame := frames[arrayAddress.ParentParameterIndex]
+			if objectmap.GetNestedAttributeByAddress(parentsFrame, arrayAddress.Address) == nil {
+				return condition.NewNullOperand(nil)
+			}
+			numElements, err := event.GetNumElementsAtAddress(arrayAddress, frames)
+			if err != nil {
+				return condition.NewErrorOperand(err)
+			}
+
+			currentAddressLen := len(arrayAddress.Address)
+			currentAddress := types.GetIntSlice()
+			currentAddress = append(currentAddress, arrayAddress.Address...)
+			currentAddress = append(currentAddress, 0)
+			defer types.PutIntSlice(currentAddress)
+			recordElements := record && event.MatchedElements != nil
+			count := 0
+			for i := 0; i < numElements; i++ {
+				currentAddress[currentAddressLen] = i
+				event.NumIterations++
+				newFrame := objectmap.GetNestedAttributeByAddress(parentsFrame, currentAddress)
+				if newFrame == nil {
+					continue
+				}
+				frames[nestingLevel] = newFrame
+				result := eval.Evaluate(event, frames)
+				if result.GetKind() == condition.ErrorOperandKind {
+					return result
+				} else if isTrueOperand(result) {
+					count++
+					if recordElements {
+						event.MatchedElements[event.EvalCategory] = append(event.MatchedElements[event.EvalCategory], i)
+					}
+				}
+			}
+			return condition.NewIntOperand(int64(count))
+		}, condition.NewStringOperand("count"), condition.NewStringOperand(newScope.Path), eval)
 }
 
 func (repo *CompareCondRepo) processForSomeCondition(
@@ -760,12 +1018,15 @@ func (repo *CompareCondRepo) processForSomeCondition(
 	dummyCond := condition.NewAndCond(condition.NewExprCondition("forSome"), condition.NewExprCondition(path), condition.NewExprCondition(element), cond)
 	evalCatRec, ok := repo.CondToCompareCondRecord.Get(dummyCond)
 	if !ok {
-		eval := repo.genEvalForSomeCondition(path, element, cond, parentScope)
+		cat := new(types.Category)
+		eval := repo.genEvalForSomeCondition(path, element, cond, parentScope, cat)
 		if eval.GetKind() == condition.ErrorOperandKind {
 			return condition.NewErrorCondition(eval.(condition.ErrorOperand))
 		}
 
 		evalCatRec = repo.NewEvalCategoryRec(eval)
+		*cat = evalCatRec.GetCategory()
+		repo.forSomePaths[*cat] = path
 		repo.CondToCompareCondRecord.Put(dummyCond, evalCatRec)
 		// ARRAY_ELEMENT issue
 		if arrayAddress, err := getAttributePathAddress(path+"[]", parentScope); err != nil {
@@ -793,22 +1054,107 @@ func (repo *CompareCondRepo) processCondNode(node ast.Node, negate bool, scope *
 		switch funcName {
 		case "regexpMatch":
 			return negateIfTrue(repo.processBoolFunc(funcRegexpMatch, n, scope), negate)
+		case "regexpMatchAny":
+			return negateIfTrue(repo.processBoolFunc(funcRegexpMatchAny, n, scope), negate)
+		case "allDistinct":
+			return negateIfTrue(repo.processBoolFunc(funcAllDistinct, n, scope), negate)
+		case "majority":
+			return negateIfTrue(repo.processBoolFunc(funcMajority, n, scope), negate)
+		case "timeOfDayBetween":
+			return negateIfTrue(repo.processBoolFunc(funcTimeOfDayBetween, n, scope), negate)
+		case "dayOfMonthIn":
+			return negateIfTrue(repo.processBoolFunc(funcDayOfMonthIn, n, scope), negate)
+		case "withinPercent":
+			return negateIfTrue(repo.processBoolFunc(funcWithinPercent, n, scope), negate)
+		case "afterByAtLeast":
+			return negateIfTrue(repo.processBoolFunc(funcAfterByAtLeast, n, scope), negate)
+		case "inSet":
+			return negateIfTrue(repo.processBoolFunc(funcInSet, n, scope), negate)
+		case "inNumericSet":
+			return negateIfTrue(repo.processBoolFunc(funcInNumericSet, n, scope), negate)
+		case "equalsFold":
+			return negateIfTrue(repo.processBoolFunc(funcEqualsFold, n, scope), negate)
+		case "onlyChars":
+			return negateIfTrue(repo.processBoolFunc(funcOnlyChars, n, scope), negate)
+		case "luhnValid":
+			return negateIfTrue(repo.processBoolFunc(funcLuhnValid, n, scope), negate)
+		case "increasesByAtLeast":
+			return negateIfTrue(repo.processBoolFunc(funcIncreasesByAtLeast, n, scope), negate)
+		case "containsSubsequence":
+			return negateIfTrue(repo.processBoolFunc(funcContainsSubsequence, n, scope), negate)
+		case "versionEq", "versionLt", "versionLte", "versionGt", "versionGte":
+			return negateIfTrue(repo.processBoolFunc(funcVersionCompare(funcName), n, scope), negate)
+		case "between":
+			return negateIfTrue(repo.processBoolFunc(funcBetween, n, scope), negate)
+		case "betweenExclusive":
+			return negateIfTrue(repo.processBoolFunc(funcBetweenExclusive, n, scope), negate)
+		case "inSpec":
+			// The negation is undefined too when the value or a limit is missing
+			return repo.processBoolFunc(funcSpecCheck(!negate), n, scope)
 		case "hasValue":
 			return negateIfTrue(repo.processBoolFunc(funcHasValue, n, scope), negate)
+		case "isNull":
+			return negateIfTrue(repo.processBoolFunc(funcIsNull, n, scope), negate)
+		case "isUndefined":
+			return negateIfTrue(repo.processBoolFunc(funcIsDefined, n, scope), !negate)
+		case "isNotEmpty":
+			return negateIfTrue(repo.processBoolFunc(funcEmptiness(false), n, scope), negate)
+		case "isEmpty":
+			// The negation of isNotEmpty() is true when the attribute is missing
+			return negateIfTrue(repo.processBoolFunc(funcEmptiness(false), n, scope), !negate)
+		case "hasAllValues":
+			expr, err := expandHasAllValues(n)
+			if err != nil {
+				return condition.NewErrorCondition(err)
+			}
+			return repo.processCondNode(expr, negate, scope)
 		case "isEqualToAnyWithDate":
 			return negateIfTrue(repo.processBoolFunc(funcIsEqualToAnyWithDate, n, scope), negate)
 		case "isEqualToAny":
 			return negateIfTrue(repo.processIsEqualToAny(n, scope), negate)
+		case "oneOf", "noneOf":
+			return repo.processCondNode(expandMembershipAlias(n), negate, scope)
+		case "isIn":
+			if !isArrayMembership(n) {
+				// Use the fast path for the constant match list
+				return negateIfTrue(repo.processIsEqualToAny(n, scope), negate)
+			}
+			return negateIfTrue(repo.processBoolFunc(funcIsIn, n, scope), negate)
 		case "containsAny":
 			return negateIfTrue(repo.processContains(n, scope), negate)
+		case "startsWith":
+			if isConstPrefixMatch(n) {
+				return negateIfTrue(repo.processPatternCond("startsWith", n, scope, repo.processEvalForPrefixes), negate)
+			}
+			return negateIfTrue(repo.processBoolFunc(funcStartsWith, n, scope), negate)
+		case "containsAll":
+			expr, err := expandContainsAll(n)
+			if err != nil {
+				return condition.NewErrorCondition(err)
+			}
+			return repo.processCondNode(expr, negate, scope)
 		case "forAll":
 			return negateIfTrue(repo.processForAllFunc(n, scope), negate)
 		case "forSome":
 			return negateIfTrue(repo.processForSomeFunc(n, scope), negate)
+		case "forEachEntry", "forSomeEntry":
+			expr, err := expandEntryFunc(n)
+			if err != nil {
+				return condition.NewErrorCondition(err)
+			}
+			return repo.processCondNode(expr, negate, scope)
+		case "clause":
+			return repo.processClause(n, negate, scope)
 		default:
+			if fn, ok := repo.customFunc(funcName); ok {
+				return negateIfTrue(repo.processBoolFunc(funcCustom(fn), n, scope), negate)
+			}
 			return condition.NewErrorCondition(fmt.Errorf("unsupported function: %s", funcName))
 		}
 	case *ast.BinaryExpr:
+		if chain, ok := expandChainedComparison(n); ok {
+			return repo.processCondNode(chain, negate, scope)
+		}
 		if negate {
 			switch n.Op {
 			case token.LAND:
@@ -852,6 +1198,13 @@ func (repo *CompareCondRepo) processCondNode(node ast.Node, negate bool, scope *
 
 type boolFuncT func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand
 
+// isTrueOperand tells whether the evaluation result is true.  The undefined results, e.g. of between() with
+// a missing bound, are not true.
+func isTrueOperand(result condition.Operand) bool {
+	b, ok := result.(condition.BooleanOperand)
+	return ok && bool(b)
+}
+
 func (repo *CompareCondRepo) processBoolFunc(boolFunc boolFuncT, n *ast.CallExpr, scope *ForEachScope) condition.Condition {
 	evalCatRec := repo.NewEvalCategoryRec(nil)
 	if scope.Evaluator != nil {
@@ -1010,6 +1363,19 @@ func (repo *CompareCondRepo) funcForSome(n *ast.CallExpr, scope *ForEachScope) c
 		return condition.NewErrorOperand(err)
 	}
 	return repo.genEvalForSomeCondition(
+		string(pathOperand.(condition.StringOperand)),
+		string(elementOperand.(condition.StringOperand)),
+		exprCond,
+		scope,
+		nil)
+}
+
+func (repo *CompareCondRepo) funcCount(n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	pathOperand, elementOperand, exprCond, err := repo.setupForEachOperands(n, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	return repo.genEvalForCountCondition(
 		string(pathOperand.(condition.StringOperand)),
 		string(elementOperand.(condition.StringOperand)),
 		exprCond,
@@ -1029,6 +1395,14 @@ func (repo *CompareCondRepo) funcForAll(n *ast.CallExpr, scope *ForEachScope) co
 }
 
 func (repo *CompareCondRepo) processContains(n *ast.CallExpr, scope *ForEachScope) condition.Condition {
+	return repo.processPatternCond("containsAny", n, scope, repo.processEvalForContains)
+}
+
+// processPatternCond compiles the call of the function matching its first operand against the constant string
+// patterns following it, e.g. containsAny(text, p1, p2, ...), to the category produced by processEval.
+func (repo *CompareCondRepo) processPatternCond(
+	funcName string, n *ast.CallExpr, scope *ForEachScope,
+	processEval func(varOperand condition.Operand, patterns []string, scope *ForEachScope) condition.Operand) condition.Condition {
 	evalCatRec := repo.NewEvalCategoryRec(nil)
 	if scope.Evaluator != nil {
 		panic("Should not happen")
@@ -1038,7 +1412,7 @@ func (repo *CompareCondRepo) processContains(n *ast.CallExpr, scope *ForEachScop
 	defer scope.ResetEvaluator()
 
 	if len(n.Args) < 2 {
-		return condition.NewErrorCondition(fmt.Errorf("wrong number of arguments for containsAny() function"))
+		return condition.NewErrorCondition(fmt.Errorf("wrong number of arguments for %s() function", funcName))
 	}
 
 	argOperands := types.MapSlice(n.Args,
@@ -1052,7 +1426,7 @@ func (repo *CompareCondRepo) processContains(n *ast.CallExpr, scope *ForEachScop
 	constOperands := types.FilterSlice(argOperands[1:], func(o condition.Operand) bool { return o.IsConst() })
 	if len(constOperands) != len(argOperands)-1 {
 		// Not all operands in the match list are constants
-		return condition.NewErrorCondition(fmt.Errorf("containsAny() only supports constant string match list"))
+		return condition.NewErrorCondition(fmt.Errorf("%s() only supports constant string match list", funcName))
 	}
 	constStringOperands := types.MapSlice(constOperands,
 		func(o condition.Operand) string {
@@ -1060,10 +1434,10 @@ func (repo *CompareCondRepo) processContains(n *ast.CallExpr, scope *ForEachScop
 		})
 	if len(constStringOperands) != len(constOperands) {
 		// Not all operands in the match list are strings
-		return condition.NewErrorCondition(fmt.Errorf("containsAny() only supports constant string match list"))
+		return condition.NewErrorCondition(fmt.Errorf("%s() only supports constant string match list", funcName))
 	}
 
-	eval := repo.processEvalForContains(argOperands[0], constStringOperands, scope)
+	eval := processEval(argOperands[0], constStringOperands, scope)
 
 	if eval != nil && eval.GetKind() == condition.ErrorOperandKind {
 		return condition.NewErrorCondition(eval.(condition.ErrorOperand))
@@ -1104,6 +1478,8 @@ func (repo *CompareCondRepo) processCompareBinaryExpr(n *ast.BinaryExpr, negate
 		case token.GTR:
 			compareOp = condition.CompareGreaterOp
 		case token.NEQ:
+			// x != const is the negated category of x == const, so that it takes the hash lookup of
+			// processCompareEqualToConstCondition shared by all the comparisons of x with the constants.
 			negate = !negate
 			compareOp = condition.CompareEqualOp
 		case token.LEQ:
@@ -1134,6 +1510,16 @@ func (repo *CompareCondRepo) processCompareBinaryExpr(n *ast.BinaryExpr, negate
 		return condition.NewErrorCondition(yOperand.(condition.ErrorOperand))
 	}
 
+	if isArithmeticExpr(n.X) || isArithmeticExpr(n.Y) {
+		// Fold the negation into the operator instead of negating the category, which would match
+		// whenever the arithmetic result is null.
+		if negate {
+			compareOp = condition.CompareNotEqualOp
+		}
+		return repo.processCompareCondition(condition.NewCompareCond(condition.CompareEqualOp,
+			repo.genEvalForArithmeticCompare(compareOp, xOperand, yOperand), condition.NewBooleanOperand(true)), scope)
+	}
+
 	if negate {
 		return condition.NewNotCond(repo.processCompareCondition(condition.NewCompareCond(compareOp, xOperand, yOperand), scope))
 	} else {
@@ -1147,10 +1533,11 @@ func (repo *CompareCondRepo) processExprCondition(exprCondition *condition.ExprC
 	}
 
 	// Convert the expression to an AST node tree
-	node, err := parser.ParseExpr(exprCondition.Expr)
+	node, err := parseExprCondition(exprCondition)
 
 	if err != nil {
-		return condition.NewErrorCondition(repo.ctx.LogError(err))
+		// Logged by newCompareCondRepo with the rule
+		return condition.NewErrorCondition(err)
 	}
 
 	// Process the AST node tree representation.
@@ -1212,6 +1599,9 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 					x.(*condition.SelOperand).Selector+"[]"), i)
 		case condition.IndexOperandKind:
 			return repo.CondFactory.NewIndexOperand(repo.CondFactory.NewSelOperand(x, "[]"), i)
+		case condition.ExpressionOperandKind, condition.ListOperandKind:
+			// List computed by a function such as split()
+			return repo.newListIndexOperand(x, i, scope)
 		default:
 			panic("should not get here")
 		}
@@ -1221,25 +1611,186 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 		funcName := n.Fun.(*ast.Ident).Name
 		switch funcName {
 		case "date":
-			return repo.convertToType(n, scope, condition.TimeOperandKind)
+			return funcDate(repo, n, scope)
 		case "string":
 			return repo.convertToType(n, scope, condition.StringOperandKind)
 		case "int":
 			return repo.convertToType(n, scope, condition.IntOperandKind)
 		case "float":
 			return repo.convertToType(n, scope, condition.FloatOperandKind)
+		case "lower":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcLower)
+		case "upper":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcUpper)
+		case "collapseSpaces":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcCollapseSpaces)
+		case "trim":
+			return repo.compileValueFuncWithOptionalArg(funcName, n, 1, scope, funcTrim)
+		case "trimLeft":
+			return repo.compileValueFuncWithOptionalArg(funcName, n, 1, scope, funcTrimLeft)
+		case "trimRight":
+			return repo.compileValueFuncWithOptionalArg(funcName, n, 1, scope, funcTrimRight)
 		case "regexpMatch":
 			return funcRegexpMatch(repo, n, scope)
+		case "regexpMatchAny":
+			return funcRegexpMatchAny(repo, n, scope)
+		case "regexpClassify":
+			return funcRegexpClassify(repo, n, scope)
+		case "allDistinct":
+			return funcAllDistinct(repo, n, scope)
+		case "indexOfFirst":
+			return funcIndexOfFirst(repo, n, scope)
+		case "length":
+			return funcLength(repo, n, scope)
+		case "windowCount":
+			return repo.compileWindowFunc(funcName, n, scope, windowCount)
+		case "windowSum":
+			return repo.compileWindowFunc(funcName, n, scope, windowSum)
+		case "arrayMax", "arrayMin", "arraySpread":
+			return funcArrayRange(repo, funcName, n, scope)
+		case "sum":
+			return funcArrayAggregate(repo, funcName, false, n, scope)
+		case "avg":
+			return funcArrayAggregate(repo, funcName, true, n, scope)
+		case "sumWhere":
+			return funcSumWhere(repo, n, scope)
+		case "aggregate":
+			return funcAggregate(repo, n, scope)
+		case "longestRun":
+			return funcLongestRun(repo, n, scope)
+		case "increasesByAtLeast":
+			return funcIncreasesByAtLeast(repo, n, scope)
+		case "containsSubsequence":
+			return funcContainsSubsequence(repo, n, scope)
+		case "inSet":
+			return funcInSet(repo, n, scope)
+		case "inNumericSet":
+			return funcInNumericSet(repo, n, scope)
+		case "setMembershipCount":
+			return funcSetMembershipCount(repo, n, scope)
+		case "equalsFold":
+			return funcEqualsFold(repo, n, scope)
+		case "startsWith":
+			return funcStartsWith(repo, n, scope)
+		case "onlyChars":
+			return funcOnlyChars(repo, n, scope)
+		case "luhnValid":
+			return funcLuhnValid(repo, n, scope)
+		case "versionEq", "versionLt", "versionLte", "versionGt", "versionGte":
+			return funcVersionCompare(funcName)(repo, n, scope)
+		case "between":
+			return funcBetween(repo, n, scope)
+		case "betweenExclusive":
+			return funcBetweenExclusive(repo, n, scope)
+		case "inSpec":
+			return funcSpecCheck(true)(repo, n, scope)
 		case "hasValue":
 			return funcHasValue(repo, n, scope)
+		case "isNull":
+			return funcIsNull(repo, n, scope)
+		case "isUndefined":
+			return funcIsUndefined(repo, n, scope)
+		case "isNotEmpty":
+			return funcEmptiness(false)(repo, n, scope)
+		case "isEmpty":
+			return funcEmptiness(true)(repo, n, scope)
+		case "hasAllValues":
+			expr, err := expandHasAllValues(n)
+			if err != nil {
+				return condition.NewErrorOperand(err)
+			}
+			return repo.preprocessAstExpr(expr, scope)
 		case "isEqualToAnyWithDate":
 			return funcIsEqualToAnyWithDate(repo, n, scope)
 		case "isEqualToAny":
 			return repo.funcIsEqualToAny(n, scope)
+		case "oneOf", "noneOf":
+			return repo.preprocessAstExpr(expandMembershipAlias(n), scope)
+		case "isIn":
+			return funcIsIn(repo, n, scope)
 		case "forAll":
 			return repo.funcForAll(n, scope)
 		case "forSome":
 			return repo.funcForSome(n, scope)
+		case "forEachEntry", "forSomeEntry":
+			expr, err := expandEntryFunc(n)
+			if err != nil {
+				return condition.NewErrorOperand(err)
+			}
+			return repo.preprocessAstExpr(expr, scope)
+		case "clause":
+			return repo.funcClause(n, scope)
+		case "count":
+			return repo.funcCount(n, scope)
+		case "majority":
+			return funcMajority(repo, n, scope)
+		case "timeOfDayBetween":
+			return funcTimeOfDayBetween(repo, n, scope)
+		case "dayOfMonthIn":
+			return funcDayOfMonthIn(repo, n, scope)
+		case "withinPercent":
+			return funcWithinPercent(repo, n, scope)
+		case "haversineKm":
+			return repo.compileValueFunc(funcName, n, 4, scope, funcHaversineKm)
+		case "daysSince":
+			return funcDaysSince(repo, n, scope)
+		case "now":
+			return funcNow(repo, n, scope)
+		case "duration":
+			return funcDuration(repo, n, scope)
+		case "dateDiff":
+			return funcDateDiff(repo, n, scope)
+		case "dateParse":
+			return repo.compileValueFunc(funcName, n, 2, scope, newDateParseFunc(repo.options.DefaultTimeZone))
+		case "dateFormat":
+			return repo.compileValueFunc(funcName, n, 2, scope, funcDateFormat)
+		case "inZone":
+			return funcInZone(repo, n, scope)
+		case "timeBucket":
+			return funcTimeBucket(repo, n, scope)
+		case "year", "month", "day", "hour", "minute", "weekday":
+			return repo.compileValueFunc(funcName, n, 1, scope, datePartFuncs[funcName])
+		case "afterByAtLeast":
+			return funcAfterByAtLeast(repo, n, scope)
+		case "businessDaysBetween":
+			return funcBusinessDaysBetween(repo, n, scope)
+		case "parseLeadingNumber":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcParseLeadingNumber)
+		case "parseTrailingNumber":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcParseTrailingNumber)
+		case "crc32":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcCrc32)
+		case "md5":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcMd5)
+		case "sha256":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcSha256)
+		case "similarity":
+			return repo.compileValueFunc(funcName, n, 2, scope, funcSimilarity)
+		case "shannonEntropy":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcShannonEntropy)
+		case "replace":
+			return repo.compileValueFuncWithOptionalArg(funcName, n, 3, scope, funcReplace)
+		case "coalesce":
+			return funcCoalesce(repo, n, scope)
+		case "concat":
+			if len(n.Args) < 2 {
+				return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for concat() function"))
+			}
+			return repo.compileValueFunc(funcName, n, len(n.Args), scope, funcConcat)
+		case "split":
+			return repo.compileValueFunc(funcName, n, 2, scope, funcSplit)
+		case "indexOf":
+			return repo.compileValueFunc(funcName, n, 2, scope, funcIndexOf)
+		case "log":
+			return repo.compileValueFunc(funcName, n, 1, scope, logFunc(funcName, math.Log))
+		case "log10":
+			return repo.compileValueFunc(funcName, n, 1, scope, logFunc(funcName, math.Log10))
+		case "exp":
+			return repo.compileValueFunc(funcName, n, 1, scope, floatFunc(math.Exp))
+		case "trunc":
+			return repo.compileValueFunc(funcName, n, 1, scope, floatFunc(math.Trunc))
+		case "sign":
+			return repo.compileValueFunc(funcName, n, 1, scope, funcSign)
 		case "sqrt":
 			argOperand := repo.evalAstNode(n.Args[0], scope)
 			if argOperand.GetKind() == condition.ErrorOperandKind {
@@ -1255,9 +1806,18 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 					return condition.NewFloatOperand(math.Sqrt(float64(arg.(condition.FloatOperand))))
 				}, argOperand, condition.StringOperand(funcName)) // funcName as hash seed to avoid cache collisions
 		default:
+			if fn, ok := repo.customFunc(funcName); ok {
+				return funcCustom(fn)(repo, n, scope)
+			}
 			return condition.NewErrorOperand(fmt.Errorf("unsupported function: %s", funcName))
 		}
 	case *ast.BinaryExpr:
+		if chain, ok := expandChainedComparison(n); ok {
+			return repo.preprocessAstExpr(chain, scope)
+		}
+		if (n.Op == token.LAND || n.Op == token.LOR) && repo.options.PredicateCostOrdering {
+			return repo.genEvalForCostOrderedLogicalOp(n, scope)
+		}
 		xOperand := repo.evalAstNode(n.X, scope)
 		if xOperand.GetKind() == condition.ErrorOperandKind {
 			return xOperand
@@ -1268,21 +1828,38 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 		}
 
 		switch n.Op {
-		case token.ADD, token.SUB, token.MUL, token.QUO:
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
 			return repo.CondFactory.NewExprOperand(
 				func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
-					xVal := xOperand.Evaluate(event, frames).Convert(condition.FloatOperandKind)
+					// Missing or null operands make the result null, the same as an undefined attribute,
+					// rather than an error, so that the comparisons using the result follow the null semantics.
+					xVal := xOperand.Evaluate(event, frames)
+					if xVal.GetKind() == condition.NullOperandKind {
+						return xVal
+					}
+					yVal := yOperand.Evaluate(event, frames)
+					if yVal.GetKind() == condition.NullOperandKind {
+						return yVal
+					}
+					if result, ok := evalTimeArithmetic(n.Op, xVal, yVal); ok {
+						return result
+					}
+
+					xVal = xVal.Convert(condition.FloatOperandKind)
 					if xVal.GetKind() == condition.ErrorOperandKind {
 						return xVal
 					}
 					lv := float64(xVal.(condition.FloatOperand))
 
-					yVal := yOperand.Evaluate(event, frames).Convert(condition.FloatOperandKind)
+					yVal = yVal.Convert(condition.FloatOperandKind)
 					if yVal.GetKind() == condition.ErrorOperandKind {
 						return yVal
 					}
 					rv := float64(yVal.(condition.FloatOperand))
 
+					if n.Op == token.REM {
+						return evalRemainder(lv, rv)
+					}
 					switch n.Op {
 					case token.ADD:
 						return condition.NewFloatOperand(lv + rv)
@@ -1296,6 +1873,13 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 						return condition.NewErrorOperand(fmt.Errorf("unsupported operator: %s", n.Op.String()))
 					}
 				}, xOperand, yOperand)
+		case token.EQL, token.LSS, token.GTR, token.NEQ, token.LEQ, token.GEQ:
+			if isArithmeticExpr(n.X) || isArithmeticExpr(n.Y) {
+				return repo.genEvalForArithmeticCompare(compareTokenOps[n.Op], xOperand, yOperand)
+			}
+		}
+
+		switch n.Op {
 		case token.EQL:
 			return repo.genEvalForCompareOperands(condition.CompareEqualOp, xOperand, yOperand)
 		case token.LSS:
@@ -1318,6 +1902,23 @@ func (repo *CompareCondRepo) preprocessAstExpr(node ast.Expr, scope *ForEachScop
 	case *ast.UnaryExpr:
 		switch n.Op {
 		case token.NOT:
+			if cmp, ok := arithmeticComparison(n.X); ok {
+				xOperand := repo.evalAstNode(cmp.X, scope)
+				if xOperand.GetKind() == condition.ErrorOperandKind {
+					return xOperand
+				}
+				yOperand := repo.evalAstNode(cmp.Y, scope)
+				if yOperand.GetKind() == condition.ErrorOperandKind {
+					return yOperand
+				}
+				return repo.genEvalForArithmeticCompare(negatedCompareTokenOps[cmp.Op], xOperand, yOperand)
+			}
+			if call, ok := n.X.(*ast.CallExpr); ok {
+				if ident, ok := call.Fun.(*ast.Ident); ok && ident.Name == "inSpec" {
+					// Undefined like inSpec() when the value or a limit is missing
+					return funcSpecCheck(false)(repo, call, scope)
+				}
+			}
 			xOperand := repo.evalAstNode(n.X, scope)
 			if xOperand.GetKind() == condition.ErrorOperandKind {
 				return xOperand
@@ -1415,31 +2016,68 @@ func funcHasValue(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) c
 		}, argOperand) // operandKind as hash seed to avoid cache collisions
 }
 
-func funcRegexpMatch(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
-	if len(n.Args) != 2 {
-		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for regexpMatch() function"))
+// funcAttributeState returns the implementation of the function testing the value of its attribute argument,
+// including the explicit null and the missing attribute, which are both undefined for the other functions.
+func funcAttributeState(funcName string, test func(value condition.Operand) bool) boolFuncT {
+	return func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+		if len(n.Args) != 1 {
+			return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+		}
+		// The same as evalAstNode, except that the argument must be addressable
+		argOperand := repo.evalOperandAddress(repo.preprocessAstExpr(n.Args[0], scope), scope)
+		if argOperand.GetKind() == condition.ErrorOperandKind {
+			return argOperand
+		}
+		if argOperand.GetKind() != condition.AddressOperandKind {
+			return condition.NewErrorOperand(
+				fmt.Errorf("argument to %s() function must be an addressable expression", funcName))
+		}
+		argOperand = repo.evalOperandAccess(argOperand, scope)
+		return repo.CondFactory.NewExprOperand(
+			func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+				arg := argOperand.Evaluate(event, frames)
+				if arg.GetKind() == condition.ErrorOperandKind {
+					return arg
+				}
+				return condition.NewBooleanOperand(test(arg))
+			}, condition.NewStringOperand(funcName), argOperand)
 	}
-	patternOperand := repo.evalAstNode(n.Args[0], scope)
+}
 
-	if patternOperand.GetKind() == condition.ErrorOperandKind {
-		return patternOperand
-	}
+// funcIsNull implements isNull(x) testing that the attribute is present in the event with the explicit null value.
+func funcIsNull(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return funcAttributeState("isNull", func(value condition.Operand) bool {
+		null, ok := value.(condition.NullOperand)
+		return ok && !null.IsUndefined()
+	})(repo, n, scope)
+}
 
-	if !patternOperand.IsConst() {
-		return condition.NewErrorOperand(
-			fmt.Errorf("the first operand of regexpMatch() must be a constant string pattern"))
-	}
+// funcIsUndefined implements isUndefined(x) testing that the attribute is missing from the event.
+func funcIsUndefined(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return funcAttributeState("isUndefined", isUndefinedValue)(repo, n, scope)
+}
 
-	if patternOperand.GetKind() != condition.StringOperandKind {
-		return condition.NewErrorOperand(
-			fmt.Errorf("the first operand of regexpMatch() must be a constant string pattern"))
-	}
+// funcIsDefined is the negation of isUndefined(x).  The rule conditions compile isUndefined(x) as
+// !funcIsDefined, because the categories are not evaluated for the events missing the attribute.
+func funcIsDefined(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return funcAttributeState("$isDefined", func(value condition.Operand) bool {
+		return !isUndefinedValue(value)
+	})(repo, n, scope)
+}
 
-	patternString := string(patternOperand.(condition.StringOperand))
-	re, err := regexp.Compile(patternString)
+func isUndefinedValue(value condition.Operand) bool {
+	null, ok := value.(condition.NullOperand)
+	return ok && null.IsUndefined()
+}
+
+func funcRegexpMatch(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for regexpMatch() function"))
+	}
+	patternOperand := repo.evalAstNode(n.Args[0], scope)
+	re, err := repo.compileRegexpOperand("regexpMatch", patternOperand)
 	if err != nil {
-		return condition.NewErrorOperand(
-			fmt.Errorf("invalid pattern:\"%s\" passed to regexpMatch()", patternString))
+		return condition.NewErrorOperand(err)
 	}
 
 	argOperand := repo.evalAstNode(n.Args[1], scope)
@@ -1451,13 +2089,71 @@ func funcRegexpMatch(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope
 		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
 			arg := argOperand.Evaluate(event, frames)
 			kind := arg.GetKind()
-			if kind == condition.ErrorOperandKind {
+			if kind == condition.ErrorOperandKind || kind == condition.NullOperandKind {
 				return arg
 			}
 			argString := string(arg.Convert(condition.StringOperandKind).(condition.StringOperand))
 			result := condition.NewBooleanOperand(re.MatchString(argString))
 			return result
-		}, argOperand) // operandKind as hash seed to avoid cache collisions
+		}, condition.NewStringOperand("regexpMatch"), patternOperand, argOperand)
+}
+
+// funcRegexpClassify implements regexpClassify(value, pattern1, pattern2, ...) returning the index of the first
+// constant pattern matching the value, or -1 when none does, e.g. to route on the class of the value.
+func funcRegexpClassify(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) < 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for regexpClassify() function"))
+	}
+	argOperands := make([]condition.Operand, len(n.Args))
+	res := make([]*regexp.Regexp, 0, len(n.Args)-1)
+	for i, arg := range n.Args {
+		argOperands[i] = repo.evalAstNode(arg, scope)
+		if i == 0 {
+			continue
+		}
+		re, err := repo.compileRegexpOperand("regexpClassify", argOperands[i])
+		if err != nil {
+			return condition.NewErrorOperand(err)
+		}
+		res = append(res, re)
+	}
+	if argOperands[0].GetKind() == condition.ErrorOperandKind {
+		return argOperands[0]
+	}
+
+	return repo.newFuncOperand("regexpClassify", argOperands,
+		func(args []condition.Operand) condition.Operand {
+			argString := string(args[0].Convert(condition.StringOperandKind).(condition.StringOperand))
+			for i, re := range res {
+				if re.MatchString(argString) {
+					return condition.NewIntOperand(int64(i))
+				}
+			}
+			return condition.NewIntOperand(-1)
+		})
+}
+
+// compileRegexpOperand compiles the constant string pattern operand of the regexp functions.  The patterns are
+// compiled once and shared across the rules.
+func (repo *CompareCondRepo) compileRegexpOperand(funcName string, patternOperand condition.Operand) (*regexp.Regexp, error) {
+	if patternOperand.GetKind() == condition.ErrorOperandKind {
+		return nil, patternOperand.(condition.ErrorOperand).Err
+	}
+
+	if !patternOperand.IsConst() || patternOperand.GetKind() != condition.StringOperandKind {
+		return nil, fmt.Errorf("the pattern of %s() must be a constant string", funcName)
+	}
+
+	patternString := string(patternOperand.(condition.StringOperand))
+	if re, ok := repo.regexps[patternString]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(patternString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern:\"%s\" passed to %s()", patternString, funcName)
+	}
+	repo.regexps[patternString] = re
+	return re, nil
 }
 
 func (repo *CompareCondRepo) funcIsEqualToAny(n *ast.CallExpr, scope *ForEachScope) condition.Operand {
@@ -1487,17 +2183,15 @@ func (repo *CompareCondRepo) funcIsEqualToAny(n *ast.CallExpr, scope *ForEachSco
 		}
 	}
 
-	// TODO: below is not implemented yet
-	argOperand := repo.evalOperandAccess(argOperands[0], scope)
-	return repo.CondFactory.NewExprOperand(
-		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
-			arg := argOperand.Evaluate(event, frames)
-			kind := arg.GetKind()
-			if kind == condition.ErrorOperandKind {
-				return arg
+	return repo.newFuncOperand("isEqualToAny", argOperands, func(args []condition.Operand) condition.Operand {
+		for _, arg := range args[1:] {
+			if equal := compareOperandValues(condition.CompareEqualOp, args[0], arg); equal.GetKind() ==
+				condition.BooleanOperandKind && bool(equal.(condition.BooleanOperand)) {
+				return equal
 			}
-			return condition.NewBooleanOperand(kind != condition.NullOperandKind)
-		}, argOperand) // operandKind as hash seed to avoid cache collisions
+		}
+		return condition.NewBooleanOperand(false)
+	})
 }
 
 func findElementScope(element string, parentScope *ForEachScope) *ForEachScope {
@@ -1522,6 +2216,10 @@ func expandPath(path string, parentScope *ForEachScope) (*ForEachScope, string)
 	scope := findElementScope(parts[0], parentScope)
 	if scope.NestingLevel == 0 {
 		return scope, path
+	} else if len(parts) == 1 {
+		// Reference to the element itself, e.g. an element of an array of scalars.
+		// "." denotes the element's own value, the same way foo[0] designates the element named "".
+		return scope, "."
 	} else {
 		return scope, parts[1]
 	}
@@ -1566,6 +2264,7 @@ func (repo *CompareCondRepo) evalOperandAccess(operand condition.Operand, scope
 			if address.GetKind() == condition.ErrorOperandKind {
 				return address
 			}
+			event.NumAttributeAccesses++
 			val := objectmap.GetNestedAttributeByAddress(
 				frames[address.(*condition.AddressOperand).ParameterIndex], address.(*condition.AddressOperand).Address)
 			if val == nil {
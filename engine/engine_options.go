@@ -0,0 +1,82 @@
+package engine
+
+import "time"
+
+// Options control how the rule engine compiles the rules.
+type Options struct {
+	// PredicateCostOrdering reorders the operands of the && and || chains evaluated within an expression,
+	// e.g. inside forSome(), so that the cheap comparisons are evaluated before the expensive functions
+	// like regexpMatch(), and stops the evaluation as soon as the result is known.
+	PredicateCostOrdering bool
+	// PerRuleTimeout bounds the time spent evaluating each condition of the rules for an event.  The rules
+	// referencing a condition whose evaluation took longer do not match the event, and are counted in the
+	// NumRuleTimeouts metric of the engine.  Zero disables the timeout.
+	PerRuleTimeout time.Duration
+	// DefaultTimeZone is the time zone of the dates without an explicit offset parsed by date() and dateParse().
+	// Nil means UTC.
+	DefaultTimeZone *time.Location
+	// Schema declares the types of the event attributes.  The rules comparing the declared attributes with
+	// the values of another type, e.g. a string attribute with a number, fail the engine creation.
+	Schema *Schema
+	// LenientNumericStrings compares the strings that parse as numbers, e.g. "12345", numerically with the number
+	// constants, so that zip == 12345 matches the zip "12345".  By default the equality to a constant holds only
+	// between the values of the same kind.
+	LenientNumericStrings bool
+	// StrictEvaluation makes MatchEventStrict report the conditions whose evaluation failed for the event, e.g. on
+	// a date that does not parse, as the errors of the rules referencing them, and exclude these rules from its
+	// matches even when the failure would otherwise make them match, e.g. through a negation.
+	StrictEvaluation bool
+}
+
+// Option sets an option of the rule engine.
+type Option func(*Options)
+
+// WithPredicateCostOrdering enables the reordering of the logical operands by their estimated evaluation cost.
+func WithPredicateCostOrdering(enabled bool) Option {
+	return func(options *Options) {
+		options.PredicateCostOrdering = enabled
+	}
+}
+
+// WithPerRuleTimeout sets the time budget for evaluating each condition of the rules.
+func WithPerRuleTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.PerRuleTimeout = timeout
+	}
+}
+
+// WithDefaultTimeZone sets the time zone of the dates without an explicit offset.
+func WithDefaultTimeZone(loc *time.Location) Option {
+	return func(options *Options) {
+		options.DefaultTimeZone = loc
+	}
+}
+
+// WithSchema validates the rules against the attribute types declared by the schema, see ParseSchema.
+func WithSchema(schema *Schema) Option {
+	return func(options *Options) {
+		options.Schema = schema
+	}
+}
+
+// WithLenientNumericStrings enables comparing the numeric strings with the number constants numerically.
+func WithLenientNumericStrings(enabled bool) Option {
+	return func(options *Options) {
+		options.LenientNumericStrings = enabled
+	}
+}
+
+// WithStrictEvaluation enables reporting the evaluation errors of the rules by MatchEventStrict.
+func WithStrictEvaluation(enabled bool) Option {
+	return func(options *Options) {
+		options.StrictEvaluation = enabled
+	}
+}
+
+func newOptions(opts []Option) *Options {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/cateng"
+)
+
+// EngineStats describe the size of the compiled rule engine, see RuleEngine.Stats.
+type EngineStats struct {
+	// NumRules counts the compiled rules.
+	NumRules int
+	// NumEvalCategories counts the distinct conditions evaluated against the events, each producing a category.
+	NumEvalCategories int
+	// NumCatSetFilters counts the category set filters of the filter tables, including the synthetic ones created by
+	// the optimizer.
+	NumCatSetFilters int
+	// NumNegatedCategories counts the categories negated by the rules, which are matched by default when their
+	// conditions are not evaluated.
+	NumNegatedCategories int
+	// NumRegexps counts the distinct patterns of the regexp functions, each compiled once for all the rules using it.
+	NumRegexps int
+	// BuilderMetrics are the counts of the sets removed, inlined and garbage collected by the optimizer.
+	BuilderMetrics cateng.BuilderMetrics
+}
+
+// Stats returns the size of the compiled rule engine, e.g. to monitor it in production.
+func (f *RuleEngine) Stats() EngineStats {
+	tables := &f.catEngine.FilterTables
+	result := EngineStats{
+		NumRules:             len(f.compCondRepo.RuleRepo.Rules),
+		NumEvalCategories:    len(f.compCondRepo.EvalCategoryRecs),
+		NumNegatedCategories: len(tables.NegCats),
+		NumRegexps:           len(f.compCondRepo.regexps),
+		BuilderMetrics:       tables.BuilderMetrics,
+	}
+	for _, filter := range tables.CatSetFilters {
+		if filter != nil {
+			result.NumCatSetFilters++
+		}
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// entryQuantifiers maps the object entry functions to the array functions iterating over the entries.
+var entryQuantifiers = map[string]string{
+	"forEachEntry": "forAll",
+	"forSomeEntry": "forSome",
+}
+
+// entriesPathSuffix is appended to the object path to denote the array of its entries.
+const entriesPathSuffix = "{}"
+
+// isEntriesPath tests that the array path denotes the entries of an object, see expandEntryFunc.
+func isEntriesPath(path string) bool {
+	return strings.HasSuffix(path, entriesPathSuffix)
+}
+
+// expandEntryFunc rewrites forEachEntry(objectPath, keyVar, valueVar, cond) into forAll() and
+// forSomeEntry(objectPath, keyVar, valueVar, cond) into forSome() over the entries of the object.  The object
+// mapper represents the entries of the object at path as the array path+"{}" of the {key, value} elements, so the
+// key variable is bound to the entry element and the references to the value variable become keyVar.value.
+func expandEntryFunc(n *ast.CallExpr) (ast.Expr, error) {
+	funcName := n.Fun.(*ast.Ident).Name
+	if len(n.Args) != 4 {
+		return nil, fmt.Errorf("wrong number of arguments for %s() function", funcName)
+	}
+	var strs [3]string
+	for i, arg := range n.Args[:3] {
+		lit, ok := arg.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return nil, fmt.Errorf("%s() only supports constant string path, key and value operands", funcName)
+		}
+		strs[i], _ = strconv.Unquote(lit.Value)
+	}
+	path, keyVar, valueVar := strs[0], strs[1], strs[2]
+	if keyVar == "" || keyVar == valueVar {
+		return nil, fmt.Errorf("%s() requires distinct key and value names", funcName)
+	}
+	entriesPath := &ast.BasicLit{ValuePos: n.Args[0].Pos(), Kind: token.STRING, Value: strconv.Quote(path + entriesPathSuffix)}
+	return &ast.CallExpr{
+		Fun:    ast.NewIdent(entryQuantifiers[funcName]),
+		Lparen: n.Lparen,
+		Args:   []ast.Expr{entriesPath, n.Args[1], rewriteEntryVars(n.Args[3], keyVar, valueVar)},
+		Rparen: n.Rparen,
+	}, nil
+}
+
+// rewriteEntryVars returns a copy of expr where the key and value variables refer to the attributes of the entry
+// element, which is named after the key variable.
+func rewriteEntryVars(expr ast.Expr, keyVar, valueVar string) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		switch e.Name {
+		case keyVar:
+			return &ast.SelectorExpr{X: ast.NewIdent(keyVar), Sel: ast.NewIdent("key")}
+		case valueVar:
+			return &ast.SelectorExpr{X: ast.NewIdent(keyVar), Sel: ast.NewIdent("value")}
+		}
+	case *ast.SelectorExpr:
+		return &ast.SelectorExpr{X: rewriteEntryVars(e.X, keyVar, valueVar), Sel: e.Sel}
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{Lparen: e.Lparen, X: rewriteEntryVars(e.X, keyVar, valueVar), Rparen: e.Rparen}
+	case *ast.UnaryExpr:
+		return &ast.UnaryExpr{OpPos: e.OpPos, Op: e.Op, X: rewriteEntryVars(e.X, keyVar, valueVar)}
+	case *ast.BinaryExpr:
+		return &ast.BinaryExpr{
+			X: rewriteEntryVars(e.X, keyVar, valueVar), OpPos: e.OpPos, Op: e.Op,
+			Y: rewriteEntryVars(e.Y, keyVar, valueVar)}
+	case *ast.CallExpr:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = rewriteEntryVars(arg, keyVar, valueVar)
+		}
+		return &ast.CallExpr{Fun: e.Fun, Lparen: e.Lparen, Args: args, Ellipsis: e.Ellipsis, Rparen: e.Rparen}
+	}
+	return expr
+}
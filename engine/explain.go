@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"github.com/atlasgurus/rulestone/types"
+	"sort"
+)
+
+// MatchExplanation explains the outcome of matching an event against a rule.
+type MatchExplanation struct {
+	RuleId condition.RuleIdType
+	// Expression is the source expression of the rule, empty for the rules not defined by an expression.
+	Expression string
+	Matched    bool
+	// TrueCategories are the leaf condition categories of the rule that evaluated to true for the event.
+	TrueCategories []types.Category
+	// FalseCategories are the remaining leaf condition categories of the rule, including those not evaluated
+	// because the event lacks the attributes they reference.
+	FalseCategories []types.Category
+}
+
+// MatchEventExplain matches the event and explains the outcome for each rule ordered by the rule id.  The error
+// reports the conditions whose evaluation failed, which MatchEvent silently treats as not matching.
+// Recording the explanation is slower than MatchEvent and is meant for debugging the rules.
+func (f *RuleEngine) MatchEventExplain(v interface{}) ([]MatchExplanation, error) {
+	var evalErrors []error
+	cats, timedOut := f.recordMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+		},
+		func(cat types.Category, result condition.Operand) {
+			if result.GetKind() == condition.ErrorOperandKind {
+				evalErrors = append(evalErrors,
+					fmt.Errorf("category %d: %w", cat, result.(condition.ErrorOperand).Err))
+			}
+		})
+	trueCats := make(map[types.Category]bool, len(cats))
+	for _, cat := range cats {
+		trueCats[cat] = true
+	}
+	matched := make(map[condition.RuleIdType]bool)
+	for _, ruleId := range f.matchCategories(cats, timedOut) {
+		matched[ruleId] = true
+	}
+
+	result := make([]MatchExplanation, len(f.rules))
+	for i, rule := range f.rules {
+		result[i].RuleId = condition.RuleIdType(i)
+		result[i].Matched = matched[result[i].RuleId]
+		if rule == nil {
+			continue
+		}
+		if expr, ok := rule.definition.Condition.(*condition.ExprCondition); ok {
+			result[i].Expression = expr.Expr
+		}
+	}
+	for cat, ruleIds := range f.compCondRepo.categoryRules {
+		for _, ruleId := range ruleIds {
+			explanation := &result[ruleId]
+			if trueCats[cat] {
+				explanation.TrueCategories = appendCategoryOnce(explanation.TrueCategories, cat)
+			} else {
+				explanation.FalseCategories = appendCategoryOnce(explanation.FalseCategories, cat)
+			}
+		}
+	}
+	for i := range result {
+		sortCategories(result[i].TrueCategories)
+		sortCategories(result[i].FalseCategories)
+	}
+
+	switch len(evalErrors) {
+	case 0:
+		return result, nil
+	case 1:
+		return result, evalErrors[0]
+	}
+	return result, fmt.Errorf("%d condition evaluations failed, first: %w", len(evalErrors), evalErrors[0])
+}
+
+func appendCategoryOnce(cats []types.Category, cat types.Category) []types.Category {
+	for _, c := range cats {
+		if c == cat {
+			return cats
+		}
+	}
+	return append(cats, cat)
+}
+
+func sortCategories(cats []types.Category) {
+	sort.Slice(cats, func(i, j int) bool { return cats[i] < cats[j] })
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+	"go/token"
+)
+
+var tokenToCompareOp = map[token.Token]condition.CompareOp{
+	token.EQL: condition.CompareEqualOp,
+	token.NEQ: condition.CompareNotEqualOp,
+	token.LSS: condition.CompareLessOp,
+	token.LEQ: condition.CompareLessOrEqualOp,
+	token.GTR: condition.CompareGreaterOp,
+	token.GEQ: condition.CompareGreaterOrEqualOp,
+}
+
+// expandChainedComparison rewrites the chained comparison like a < b <= c, which the Go parser reads as
+// (a < b) <= c, into a < b && b <= c.  It returns false if the expression is not a chained comparison.
+// A parenthesized comparison, e.g. (a < b) == c, is not a chain.
+func expandChainedComparison(n *ast.BinaryExpr) (ast.Expr, bool) {
+	if _, ok := tokenToCompareOp[n.Op]; !ok {
+		return nil, false
+	}
+	x, ok := n.X.(*ast.BinaryExpr)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := tokenToCompareOp[x.Op]; !ok {
+		return nil, false
+	}
+	left, ok := expandChainedComparison(x)
+	if !ok {
+		left = x
+	}
+	return &ast.BinaryExpr{
+		X:  left,
+		Op: token.LAND,
+		Y:  &ast.BinaryExpr{X: x.Y, OpPos: n.OpPos, Op: n.Op, Y: n.Y},
+	}, true
+}
+
+// expandMembershipAlias rewrites oneOf(x, ...) into isEqualToAny(x, ...) and noneOf(x, ...) into
+// !isEqualToAny(x, ...), so that the aliases compile to the same optimized categories.
+func expandMembershipAlias(n *ast.CallExpr) ast.Expr {
+	call := &ast.CallExpr{Fun: ast.NewIdent("isEqualToAny"), Lparen: n.Lparen, Args: n.Args, Rparen: n.Rparen}
+	if n.Fun.(*ast.Ident).Name == "noneOf" {
+		return &ast.UnaryExpr{OpPos: n.Pos(), Op: token.NOT, X: call}
+	}
+	return call
+}
+
+// expandHasAllValues rewrites hasAllValues(a, b, ...) into hasValue(a) && hasValue(b) && ..., so that each of the
+// fields gets its own category triggered by the field.
+func expandHasAllValues(n *ast.CallExpr) (ast.Expr, error) {
+	if len(n.Args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for hasAllValues() function")
+	}
+	var result ast.Expr
+	for _, arg := range n.Args {
+		call := &ast.CallExpr{Fun: ast.NewIdent("hasValue"), Lparen: arg.Pos(), Args: []ast.Expr{arg}, Rparen: arg.End()}
+		if result == nil {
+			result = call
+		} else {
+			result = &ast.BinaryExpr{X: result, OpPos: arg.Pos(), Op: token.LAND, Y: call}
+		}
+	}
+	return result, nil
+}
+
+// evalAstNodeKeepUndefined compiles a boolean sub-condition the same way as evalAstNode, except for the
+// comparisons that evaluate to null rather than false when any of the compared values is undefined.
+// This lets the functions like majority() tell the undefined sub-conditions from the false ones.
+func (repo *CompareCondRepo) evalAstNodeKeepUndefined(node ast.Expr, scope *ForEachScope) condition.Operand {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return repo.evalAstNodeKeepUndefined(n.X, scope)
+	case *ast.BinaryExpr:
+		if chain, ok := expandChainedComparison(n); ok {
+			return repo.evalAstNodeKeepUndefined(chain, scope)
+		}
+		compOp, ok := tokenToCompareOp[n.Op]
+		if !ok {
+			break
+		}
+		xOperand := repo.evalAstNode(n.X, scope)
+		if xOperand.GetKind() == condition.ErrorOperandKind {
+			return xOperand
+		}
+		yOperand := repo.evalAstNode(n.Y, scope)
+		if yOperand.GetKind() == condition.ErrorOperandKind {
+			return yOperand
+		}
+		return repo.newFuncOperand("compareKeepUndefined", []condition.Operand{xOperand, yOperand, condition.NewIntOperand(int64(compOp))},
+			func(args []condition.Operand) condition.Operand {
+				return compareOperandValues(compOp, args[0], args[1])
+			})
+	}
+	return repo.evalAstNode(node, scope)
+}
+
+// funcMajority implements majority(cond1, cond2, ...) that is true when strictly more than half of the defined
+// sub-conditions are true.  The sub-conditions that are undefined, e.g. compare a missing attribute, are not
+// counted.  The result is undefined when all the sub-conditions are undefined.
+func funcMajority(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) == 0 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for majority() function"))
+	}
+	argOperands := make([]condition.Operand, len(n.Args))
+	for i, arg := range n.Args {
+		argOperands[i] = repo.evalAstNodeKeepUndefined(arg, scope)
+		if argOperands[i].GetKind() == condition.ErrorOperandKind {
+			return argOperands[i]
+		}
+	}
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			numTrue := 0
+			numDefined := 0
+			for _, arg := range argOperands {
+				v := arg.Evaluate(event, frames)
+				switch v.GetKind() {
+				case condition.ErrorOperandKind:
+					return v
+				case condition.NullOperandKind:
+					continue
+				case condition.BooleanOperandKind:
+					numDefined++
+					if v.(condition.BooleanOperand) {
+						numTrue++
+					}
+				default:
+					return condition.NewErrorOperand(fmt.Errorf("majority() arguments must be boolean conditions"))
+				}
+			}
+			if numDefined == 0 {
+				return condition.NewNullOperand(nil)
+			}
+			return condition.NewBooleanOperand(numTrue*2 > numDefined)
+		}, append([]condition.Operand{condition.NewStringOperand("majority")}, argOperands...)...)
+}
+
+// compileBetween compiles between(x, low, high) with the inclusive bounds, or betweenExclusive(x, low, high)
+// with the exclusive ones.  The range test is a single condition, so it takes one category instead of two for
+// x >= low && x <= high.  Undefined x or bounds evaluate to undefined.
+func (repo *CompareCondRepo) compileBetween(
+	funcName string, exclusive bool, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	var lowOp, highOp condition.CompareOp = condition.CompareGreaterOrEqualOp, condition.CompareLessOrEqualOp
+	if exclusive {
+		lowOp, highOp = condition.CompareGreaterOp, condition.CompareLessOp
+	}
+	return repo.compileValueFunc(funcName, n, 3, scope, func(args []condition.Operand) condition.Operand {
+		if r := compareOperandValues(lowOp, args[0], args[1]); r.GetKind() != condition.BooleanOperandKind ||
+			!bool(r.(condition.BooleanOperand)) {
+			return r
+		}
+		return compareOperandValues(highOp, args[0], args[2])
+	})
+}
+
+func funcBetween(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileBetween("between", false, n, scope)
+}
+
+func funcBetweenExclusive(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileBetween("betweenExclusive", true, n, scope)
+}
+
+// funcSpecCheck returns the implementation of inSpec(value, lowerSpec, upperSpec) testing that the number is within
+// the inclusive spec limits, or of its negation testing that it is out of them.  Unlike between() the result is
+// undefined, so that neither inSpec() nor !inSpec() holds, unless the value and both limits are numbers.
+func funcSpecCheck(inSpec bool) boolFuncT {
+	funcName := "inSpec"
+	if !inSpec {
+		funcName = "$outOfSpec"
+	}
+	return func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+		return repo.compileValueFunc(funcName, n, 3, scope, func(args []condition.Operand) condition.Operand {
+			for _, arg := range args {
+				switch arg.GetKind() {
+				case condition.IntOperandKind, condition.FloatOperandKind:
+				default:
+					return condition.NewNullOperand(nil)
+				}
+			}
+			value := args[0].Convert(condition.FloatOperandKind).(condition.FloatOperand)
+			lower := args[1].Convert(condition.FloatOperandKind).(condition.FloatOperand)
+			upper := args[2].Convert(condition.FloatOperandKind).(condition.FloatOperand)
+			return condition.NewBooleanOperand((lower <= value && value <= upper) == inSpec)
+		})
+	}
+}
@@ -18,6 +18,12 @@ func contains(text string, patterns []string) []string {
 	return matchedPatterns
 }
 
+// patternMatcher finds the categories of the patterns matching a text, see StringMatcher and PrefixMatcher.
+type patternMatcher interface {
+	AddPattern(pattern string, category condition.Operand)
+	Match(text string) []condition.Operand
+}
+
 type StringMatcher struct {
 	machine    *ahocorasick.Matcher
 	patterns   []string
@@ -59,3 +65,40 @@ func (sm *StringMatcher) Match(text string) []condition.Operand {
 
 	return matchedCategories
 }
+
+// PrefixMatcher finds the categories of the prefixes of a text.  It looks up each distinct prefix length rather
+// than testing each prefix.
+type PrefixMatcher struct {
+	categories map[string][]condition.Operand
+	lengths    []int
+}
+
+func NewPrefixMatcher() *PrefixMatcher {
+	return &PrefixMatcher{categories: make(map[string][]condition.Operand)}
+}
+
+func (pm *PrefixMatcher) AddPattern(prefix string, category condition.Operand) {
+	if _, ok := pm.categories[prefix]; !ok {
+		found := false
+		for _, l := range pm.lengths {
+			if l == len(prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pm.lengths = append(pm.lengths, len(prefix))
+		}
+	}
+	pm.categories[prefix] = append(pm.categories[prefix], category)
+}
+
+func (pm *PrefixMatcher) Match(text string) []condition.Operand {
+	matchedCategories := make([]condition.Operand, 0)
+	for _, l := range pm.lengths {
+		if l <= len(text) {
+			matchedCategories = append(matchedCategories, pm.categories[text[:l]]...)
+		}
+	}
+	return matchedCategories
+}
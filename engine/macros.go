@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+)
+
+// RuleFile is the rule file format defining the macros shared by its rules.  The rule files may also be plain lists
+// of rules.
+type RuleFile struct {
+	// Macros are the named expression fragments, e.g. isAdult: "age >= 18", referenced from the rule expressions,
+	// the named clauses and the other macros as @isAdult.  The references are expanded when the rules are read.
+	Macros map[string]string `json:"macros,omitempty" yaml:"macros,omitempty"`
+	Rules  []ExternalRule    `json:"rules" yaml:"rules"`
+}
+
+// decodeRuleFile decodes either the list of rules or the RuleFile object in the JSON or YAML format.
+func (api *RuleApi) decodeRuleFile(r io.Reader, fileType string) (*RuleFile, error) {
+	var result RuleFile
+	switch strings.ToLower(fileType) {
+	case "json":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, api.ctx.Errorf("error reading JSON: %s", err)
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+			err = json.Unmarshal(data, &result)
+		} else {
+			err = json.Unmarshal(data, &result.Rules)
+		}
+		if err != nil {
+			return nil, api.ctx.Errorf("error parsing JSON: %s", err)
+		}
+	case "yaml", "yml":
+		var node yaml.Node
+		if err := yaml.NewDecoder(r).Decode(&node); err != nil {
+			return nil, api.ctx.Errorf("error parsing YAML: %s", err)
+		}
+		var err error
+		if len(node.Content) > 0 && node.Content[0].Kind == yaml.MappingNode {
+			err = node.Decode(&result)
+		} else {
+			err = node.Decode(&result.Rules)
+		}
+		if err != nil {
+			return nil, api.ctx.Errorf("error parsing YAML: %s", err)
+		}
+	default:
+		return nil, api.ctx.Errorf("unsupported file type: %s", fileType)
+	}
+	return &result, nil
+}
+
+// macroExpander expands the @name macro references.
+type macroExpander struct {
+	macros map[string]string
+	// expanded are the macros with their references expanded, in parentheses.
+	expanded map[string]string
+	// expanding are the macros being expanded, to detect the cycles.
+	expanding map[string]bool
+}
+
+func newMacroExpander(macros map[string]string) *macroExpander {
+	return &macroExpander{macros: macros, expanded: make(map[string]string), expanding: make(map[string]bool)}
+}
+
+// expand replaces the macro references outside of the string literals of the expression with the expanded macros.
+// The references followed by the arguments are the rolling aggregates, e.g. @avg("amount"), and are left as is.
+func (e *macroExpander) expand(expr string) (string, error) {
+	if !strings.Contains(expr, "@") {
+		return expr, nil
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(expr) {
+				b.WriteByte(c)
+				i++
+				c = expr[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '@':
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || 'a' <= expr[j] && expr[j] <= 'z' || 'A' <= expr[j] && expr[j] <= 'Z' ||
+				j > i+1 && '0' <= expr[j] && expr[j] <= '9') {
+				j++
+			}
+			if j == i+1 {
+				return "", fmt.Errorf("missing macro name after @ in %q", expr)
+			}
+			if j < len(expr) && expr[j] == '(' {
+				break
+			}
+			macro, err := e.expandMacro(expr[i+1 : j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(macro)
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+func (e *macroExpander) expandMacro(name string) (string, error) {
+	if result, ok := e.expanded[name]; ok {
+		return result, nil
+	}
+	macro, ok := e.macros[name]
+	if !ok {
+		return "", fmt.Errorf("unknown macro: %s", name)
+	}
+	if e.expanding[name] {
+		return "", fmt.Errorf("macro %s references itself", name)
+	}
+	e.expanding[name] = true
+	defer delete(e.expanding, name)
+	result, err := e.expand(macro)
+	if err != nil {
+		return "", err
+	}
+	result = "(" + result + ")"
+	e.expanded[name] = result
+	return result, nil
+}
+
+// expandRuleMacros expands the macro references of the rule expression and of its named clauses.
+func (e *macroExpander) expandRuleMacros(rule *ExternalRule) error {
+	expr, err := e.expand(rule.Expression)
+	if err != nil {
+		return err
+	}
+	rule.Expression = expr
+	for name, clause := range rule.When {
+		if rule.When[name], err = e.expand(clause); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"sort"
+)
+
+// MatchEventGrouped matches the event like MatchEvent and groups the matched rules by the value of the metadata
+// field, e.g. "severity".  The string values are used as is and the other ones formatted with fmt.Sprint, while
+// the rules without the field are grouped under "".  The rules of each group are sorted by id.
+func (f *RuleEngine) MatchEventGrouped(v interface{}, byMetadataKey string) map[string][]condition.RuleIdType {
+	result := make(map[string][]condition.RuleIdType)
+	for _, ruleId := range f.MatchEvent(v) {
+		group := metadataGroup(f.rules[ruleId].definition.Metadata, byMetadataKey)
+		result[group] = append(result[group], ruleId)
+	}
+	for _, ids := range result {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return result
+}
+
+func metadataGroup(metadata map[string]interface{}, key string) string {
+	switch value := metadata[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
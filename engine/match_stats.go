@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+)
+
+// MatchStats are the evaluation counters of a single event, see MatchEventWithStats.
+type MatchStats struct {
+	// NumCatEvals counts the evaluated categories, the same as RuleEngineMetrics.NumCatEvals does across the events.
+	NumCatEvals uint64
+	// NumAttributeAccesses counts the attribute values read by the evaluated conditions.
+	NumAttributeAccesses uint64
+	// NumIterations counts the array elements visited by forAll(), forSome(), count() and the array functions.
+	NumIterations uint64
+}
+
+// MatchEventWithStats matches the event like MatchEvent and also returns the evaluation counters of the event,
+// e.g. to spot the events that are expensive to match.
+func (f *RuleEngine) MatchEventWithStats(v interface{}) ([]condition.RuleIdType, MatchStats) {
+	cats, timedOut, stats := f.evalMappedEventStats(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+		}, nil)
+	return f.matchCategories(cats, timedOut), stats
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"math"
+)
+
+// toFloatArgs converts the operands to floats.  It returns the error operand if any of the conversions fail.
+func toFloatArgs(args []condition.Operand) ([]float64, condition.Operand) {
+	result := make([]float64, len(args))
+	for i, arg := range args {
+		f := arg.Convert(condition.FloatOperandKind)
+		if f.GetKind() != condition.FloatOperandKind {
+			return nil, f
+		}
+		result[i] = float64(f.(condition.FloatOperand))
+	}
+	return result, nil
+}
+
+// funcWithinPercent implements withinPercent(actual, expected, pct) that is true when actual differs from expected
+// by at most pct percent of expected.  When expected is 0 only actual equal to 0 is within the tolerance.
+func funcWithinPercent(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileValueFunc("withinPercent", n, 3, scope, func(args []condition.Operand) condition.Operand {
+		v, errOperand := toFloatArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		actual, expected, pct := v[0], v[1], v[2]
+		if expected == 0 {
+			return condition.NewBooleanOperand(actual == 0)
+		}
+		return condition.NewBooleanOperand(math.Abs(actual-expected) <= math.Abs(expected)*pct/100)
+	})
+}
+
+// earthRadiusKm is the mean Earth radius used by haversineKm().
+const earthRadiusKm = 6371.0088
+
+// funcHaversineKm implements haversineKm(lat1, lon1, lat2, lon2) returning the great-circle distance in kilometers
+// between the points given by their latitudes and longitudes in degrees.
+func funcHaversineKm(args []condition.Operand) condition.Operand {
+	v, errOperand := toFloatArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+	lat1, lon1, lat2, lon2 := toRadians(v[0]), toRadians(v[1]), toRadians(v[2]), toRadians(v[3])
+	sinLat, sinLon := math.Sin((lat2-lat1)/2), math.Sin((lon2-lon1)/2)
+	h := sinLat*sinLat + math.Cos(lat1)*math.Cos(lat2)*sinLon*sinLon
+	// Guard against the rounding pushing h out of [0, 1] for the antipodal points
+	h = math.Min(1, math.Max(0, h))
+	return condition.NewFloatOperand(2 * earthRadiusKm * math.Asin(math.Sqrt(h)))
+}
+
+// floatFunc adapts the single argument math function like math.Exp to the value function.
+func floatFunc(f func(float64) float64) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		v, errOperand := toFloatArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewFloatOperand(f(v[0]))
+	}
+}
+
+// logFunc adapts the logarithm function reporting the error rather than returning NaN or -Inf for the
+// arguments that are not positive.
+func logFunc(funcName string, f func(float64) float64) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		v, errOperand := toFloatArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		if v[0] <= 0 {
+			return condition.NewErrorOperand(fmt.Errorf("%s() of non-positive value %v", funcName, v[0]))
+		}
+		return condition.NewFloatOperand(f(v[0]))
+	}
+}
+
+// funcSign implements sign(x) returning -1, 0 or 1 depending on the sign of x.
+func funcSign(args []condition.Operand) condition.Operand {
+	v, errOperand := toFloatArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	switch {
+	case v[0] > 0:
+		return condition.NewIntOperand(1)
+	case v[0] < 0:
+		return condition.NewIntOperand(-1)
+	default:
+		return condition.NewIntOperand(0)
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// namedClause is a boolean clause defined once in the "when" section of a rule and referenced by the rules with
+// clause("name").  The rule level references share the single category of the clause.
+type namedClause struct {
+	name string
+	node ast.Expr
+	// cond is the category condition of the clause once compiled at the rule level.
+	cond condition.Condition
+	// compiling detects the clauses referencing themselves.
+	compiling bool
+}
+
+// defineClauses collects the named clauses of the rules.  The same clause may be defined by several rules as long
+// as the definitions are the same.
+func (repo *CompareCondRepo) defineClauses(ruleRepo *RuleEngineRepo) error {
+	repo.clauses = make(map[string]*namedClause)
+	definitions := make(map[string]string)
+	for id, rule := range ruleRepo.Rules {
+		if rule == nil {
+			continue
+		}
+		for name, expr := range rule.definition.When {
+			if prev, ok := definitions[name]; ok {
+				if prev != expr {
+					return repo.ctx.Errorf("clause %s of rule %d conflicts with its previous definition", name, id)
+				}
+				continue
+			}
+			node, err := parseExpr(expr)
+			if err != nil {
+				return repo.ctx.Errorf("error parsing clause %s of rule %d: %s", name, id, err)
+			}
+			definitions[name] = expr
+			repo.clauses[name] = &namedClause{name: name, node: node}
+		}
+	}
+	return nil
+}
+
+// lookupClause returns the clause referenced by the call clause("name").
+func (repo *CompareCondRepo) lookupClause(n *ast.CallExpr) (*namedClause, error) {
+	if len(n.Args) != 1 {
+		return nil, fmt.Errorf("wrong number of arguments for clause() function")
+	}
+	lit, ok := n.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil, fmt.Errorf("clause() only supports constant string name")
+	}
+	name, _ := strconv.Unquote(lit.Value)
+	clause, ok := repo.clauses[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown clause: %s", name)
+	}
+	if clause.compiling {
+		return nil, fmt.Errorf("clause %s references itself", name)
+	}
+	return clause, nil
+}
+
+// processClause compiles the reference to the named clause.  All the references outside of forAll() and forSome()
+// share the category of the clause compiled on the first one.
+func (repo *CompareCondRepo) processClause(n *ast.CallExpr, negate bool, scope *ForEachScope) condition.Condition {
+	clause, err := repo.lookupClause(n)
+	if err != nil {
+		return condition.NewErrorCondition(err)
+	}
+	if clause.cond != nil && scope.NestingLevel == 0 {
+		return negateIfTrue(clause.cond, negate)
+	}
+	clause.compiling = true
+	cond := repo.processBoolFunc(
+		func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+			return repo.evalAstNode(clause.node, scope)
+		}, n, scope)
+	clause.compiling = false
+	if scope.NestingLevel == 0 && cond.GetKind() != condition.ErrorCondKind {
+		clause.cond = cond
+	}
+	return negateIfTrue(cond, negate)
+}
+
+// funcClause compiles the reference to the named clause within an expression, e.g. inside a function call.
+func (repo *CompareCondRepo) funcClause(n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	clause, err := repo.lookupClause(n)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	clause.compiling = true
+	defer func() { clause.compiling = false }()
+	return repo.evalAstNode(clause.node, scope)
+}
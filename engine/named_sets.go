@@ -0,0 +1,271 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// namedSet is a set of values tested by the rules with inSet(value, "name").  The values can be replaced with
+// UpdateSet while the events are being matched.
+type namedSet struct {
+	mu     sync.RWMutex
+	values map[string]bool
+	// numbers are the values parsing as numbers, for inNumericSet().
+	numbers map[float64]bool
+}
+
+func (s *namedSet) contains(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+func (s *namedSet) containsNumber(f float64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.numbers[f]
+}
+
+// forEach calls fn for each value of the set.
+func (s *namedSet) forEach(fn func(string)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for v := range s.values {
+		fn(v)
+	}
+}
+
+func (s *namedSet) update(values []string) {
+	m := make(map[string]bool, len(values))
+	numbers := make(map[float64]bool)
+	for _, v := range values {
+		m[v] = true
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			numbers[f] = true
+		}
+	}
+	s.mu.Lock()
+	s.values = m
+	s.numbers = numbers
+	s.mu.Unlock()
+}
+
+// setKey returns the key of the value in a named set.  Numbers are formatted without exponent so that the numeric
+// attributes match the numbers listed in the set files, e.g. 1234567.
+func setKey(operand condition.Operand) condition.Operand {
+	switch operand.GetKind() {
+	case condition.IntOperandKind, condition.FloatOperandKind:
+		f := operand.Convert(condition.FloatOperandKind)
+		return condition.NewStringOperand(strconv.FormatFloat(float64(f.(condition.FloatOperand)), 'f', -1, 64))
+	}
+	return operand.Convert(condition.StringOperandKind)
+}
+
+// RegisterSet registers the named set of values, replacing the values of the set if it is already registered.
+// The set must be registered before the rule engine referencing it with inSet() is created.
+func (repo *RuleEngineRepo) RegisterSet(name string, values []string) {
+	repo.setsMu.Lock()
+	defer repo.setsMu.Unlock()
+	if repo.sets == nil {
+		repo.sets = make(map[string]*namedSet)
+	}
+	set, ok := repo.sets[name]
+	if !ok {
+		set = &namedSet{}
+		repo.sets[name] = set
+	}
+	set.update(values)
+}
+
+// UpdateSet replaces the values of the registered named set.  The rule engines created from the repo see the new
+// values immediately.
+func (repo *RuleEngineRepo) UpdateSet(name string, values []string) error {
+	set := repo.getSet(name)
+	if set == nil {
+		return repo.ctx.Errorf("unknown set: %s", name)
+	}
+	set.update(values)
+	return nil
+}
+
+// LoadSetFromFile registers or updates the named set with the values read from the file.  The format is either
+// "json" for a JSON array of strings and numbers, or "text" for one value per line, ignoring the empty lines.
+// When the format is empty it is derived from the file extension, with ".json" for JSON and anything else as text.
+func (repo *RuleEngineRepo) LoadSetFromFile(name string, path string, format string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "" {
+		format = "text"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = "json"
+		}
+	}
+	values, err := readSetValues(f, format)
+	if err != nil {
+		return repo.ctx.Errorf("error loading set %s from %s: %s", name, path, err)
+	}
+	if repo.getSet(name) != nil {
+		return repo.UpdateSet(name, values)
+	}
+	repo.RegisterSet(name, values)
+	return nil
+}
+
+func readSetValues(r io.Reader, format string) ([]string, error) {
+	var values []string
+	switch strings.ToLower(format) {
+	case "json":
+		var list []interface{}
+		if err := json.NewDecoder(r).Decode(&list); err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			switch e := v.(type) {
+			case string:
+				values = append(values, e)
+			case float64:
+				values = append(values, strconv.FormatFloat(e, 'f', -1, 64))
+			default:
+				return nil, fmt.Errorf("unsupported set value: %v", v)
+			}
+		}
+	case "text", "txt":
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				values = append(values, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported set format: %s", format)
+	}
+	return values, nil
+}
+
+func (repo *RuleEngineRepo) getSet(name string) *namedSet {
+	repo.setsMu.Lock()
+	defer repo.setsMu.Unlock()
+	return repo.sets[name]
+}
+
+// funcInSet implements inSet(value, "name") testing that the value is a member of the named set registered
+// with the repo.
+func funcInSet(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileSetFunc("inSet", n, scope, func(set *namedSet, value condition.Operand) condition.Operand {
+		key := setKey(value)
+		if key.GetKind() != condition.StringOperandKind {
+			return key
+		}
+		return condition.NewBooleanOperand(set.contains(string(key.(condition.StringOperand))))
+	})
+}
+
+// funcInNumericSet implements inNumericSet(value, "name") testing that the numeric value is equal to one of the
+// numbers of the named set, e.g. 200 is a member of the set listing "200" or "200.0".  The values that are not
+// numbers are not members.
+func funcInNumericSet(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileSetFunc("inNumericSet", n, scope, func(set *namedSet, value condition.Operand) condition.Operand {
+		switch value.GetKind() {
+		case condition.IntOperandKind, condition.FloatOperandKind:
+			f := value.Convert(condition.FloatOperandKind).(condition.FloatOperand)
+			return condition.NewBooleanOperand(set.containsNumber(float64(f)))
+		}
+		return condition.NewBooleanOperand(false)
+	})
+}
+
+// compileSetFunc compiles the function taking the value and the constant name of the named set, evaluated with
+// member on the value for each event.
+func (repo *CompareCondRepo) compileSetFunc(
+	funcName string, n *ast.CallExpr, scope *ForEachScope,
+	member func(set *namedSet, value condition.Operand) condition.Operand) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+	}
+	set, nameOperand := repo.evalSetArg(funcName, n.Args[1], scope)
+	if set == nil {
+		return nameOperand
+	}
+
+	valueOperand := repo.evalAstNode(n.Args[0], scope)
+	if valueOperand.GetKind() == condition.ErrorOperandKind {
+		return valueOperand
+	}
+	return repo.newFuncOperand(funcName, []condition.Operand{valueOperand, nameOperand},
+		func(args []condition.Operand) condition.Operand {
+			return member(set, args[0])
+		})
+}
+
+// evalSetArg returns the named set and its name operand given by the constant argument, or nil and the error operand.
+func (repo *CompareCondRepo) evalSetArg(
+	funcName string, arg ast.Expr, scope *ForEachScope) (*namedSet, condition.Operand) {
+	nameOperand := repo.evalAstNode(arg, scope)
+	if nameOperand.GetKind() == condition.ErrorOperandKind {
+		return nil, nameOperand
+	}
+	if !nameOperand.IsConst() || nameOperand.GetKind() != condition.StringOperandKind {
+		return nil, condition.NewErrorOperand(
+			fmt.Errorf("the set name of %s() must be a constant", funcName))
+	}
+	name := string(nameOperand.(condition.StringOperand))
+	set := repo.ruleEngineRepo.getSet(name)
+	if set == nil {
+		return nil, condition.NewErrorOperand(fmt.Errorf("unknown set: %s", name))
+	}
+	return set, nameOperand
+}
+
+// funcSetMembershipCount implements setMembershipCount(value, "name1", "name2", ...) returning the number of the
+// named sets containing the value, e.g. the number of the threat feeds listing the IP address.
+func funcSetMembershipCount(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) < 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for setMembershipCount() function"))
+	}
+	var sets []*namedSet
+	args := []condition.Operand{nil}
+	for _, arg := range n.Args[1:] {
+		set, nameOperand := repo.evalSetArg("setMembershipCount", arg, scope)
+		if set == nil {
+			return nameOperand
+		}
+		sets = append(sets, set)
+		args = append(args, nameOperand)
+	}
+
+	valueOperand := repo.evalAstNode(n.Args[0], scope)
+	if valueOperand.GetKind() == condition.ErrorOperandKind {
+		return valueOperand
+	}
+	args[0] = valueOperand
+	return repo.newFuncOperand("setMembershipCount", args,
+		func(args []condition.Operand) condition.Operand {
+			key := setKey(args[0])
+			if key.GetKind() != condition.StringOperandKind {
+				return key
+			}
+			var count int64
+			for _, set := range sets {
+				if set.contains(string(key.(condition.StringOperand))) {
+					count++
+				}
+			}
+			return condition.NewIntOperand(count)
+		})
+}
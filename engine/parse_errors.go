@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go/scanner"
+)
+
+// RuleIdField is the rule metadata field naming the rule, e.g. "BUSINESS_RULE_1", in the errors reported for it.
+const RuleIdField = "rule_id"
+
+// ExprParseError is the syntax error of a rule expression.  The position is the one of the first error reported
+// by the parser: Line and Column count from 1 and Offset is the byte offset from the start of the expression.
+type ExprParseError struct {
+	Expr   string
+	Line   int
+	Column int
+	Offset int
+	Msg    string
+}
+
+func (e *ExprParseError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d (offset %d) of %q: %s",
+		e.Line, e.Column, e.Offset, e.Expr, e.Msg)
+}
+
+// newExprParseError converts the error of parsing the expression to the ExprParseError.  The errors without a
+// position are returned as is.
+func newExprParseError(expr string, err error) error {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return err
+	}
+	pos := list[0].Pos
+	return &ExprParseError{Expr: expr, Line: pos.Line, Column: pos.Column, Offset: pos.Offset, Msg: list[0].Msg}
+}
+
+// describeRule names the rule by its index and by its RuleIdField metadata if any for the error messages.
+func describeRule(index int, metadata map[string]interface{}) string {
+	if id, ok := metadata[RuleIdField]; ok && id != nil {
+		return fmt.Sprintf("rule %d (%s %v)", index, RuleIdField, id)
+	}
+	return fmt.Sprintf("rule %d", index)
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CompilePredicate compiles a single rule into a match function, e.g. for filtering the events in code without
+// managing a repo and an engine.  The rule is given in the format of RegisterRuleFromString, "json" or "yaml", or,
+// for the "expr" format, as the bare expression, e.g. `amount > 100 && country == "US"`.  The returned function is
+// safe for concurrent use.
+func CompilePredicate(expr string, format string) (func(map[string]interface{}) bool, error) {
+	if format == "expr" {
+		rule, err := json.Marshal([]ExternalRule{{Expression: expr}})
+		if err != nil {
+			return nil, err
+		}
+		expr, format = string(rule), "json"
+	}
+	repo := NewRuleEngineRepo()
+	if _, err := repo.RegisterRuleFromString(expr, format); err != nil {
+		return nil, err
+	}
+	genFilter, err := NewRuleEngine(repo)
+	if err != nil {
+		return nil, err
+	}
+	if repo.ctx.NumErrors() > 0 {
+		return nil, fmt.Errorf("failed to compile the predicate with %d errors", repo.ctx.NumErrors())
+	}
+	return func(event map[string]interface{}) bool {
+		// MatchEvents uses the buffers of the call rather than those shared by MatchEvent
+		return len(genFilter.MatchEvents([]map[string]interface{}{event})[0]) > 0
+	}, nil
+}
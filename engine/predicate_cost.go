@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// funcEvalCost is the estimated evaluation cost of the functions that are much more expensive than a comparison.
+// The cost of the other expression nodes is 1.
+var funcEvalCost = map[string]int{
+	"regexpMatch":        50,
+	"regexpMatchAny":     100,
+	"similarity":         100,
+	"allDistinct":        100,
+	"forAll":             100,
+	"forSome":            100,
+	"forEachEntry":       100,
+	"forSomeEntry":       100,
+	"count":              100,
+	"sumWhere":           100,
+	"longestRun":         100,
+	"increasesByAtLeast": 100,
+	"majority":           10,
+	"date":               20,
+	"crc32":              20,
+	"md5":                20,
+	"sha256":             20,
+	"shannonEntropy":     20,
+}
+
+// predicateCost estimates the cost of evaluating the expression.
+func predicateCost(node ast.Expr) int {
+	cost := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		cost++
+		if call, ok := n.(*ast.CallExpr); ok {
+			if ident, ok := call.Fun.(*ast.Ident); ok {
+				cost += funcEvalCost[ident.Name]
+			}
+		}
+		return true
+	})
+	return cost
+}
+
+// flattenLogicalOp collects the operands of a chain of the same logical operator, e.g. a && (b && c).
+func flattenLogicalOp(op token.Token, node ast.Expr, result []ast.Expr) []ast.Expr {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return flattenLogicalOp(op, n.X, result)
+	case *ast.BinaryExpr:
+		if n.Op == op {
+			return flattenLogicalOp(op, n.Y, flattenLogicalOp(op, n.X, result))
+		}
+	}
+	return append(result, node)
+}
+
+// genEvalForCostOrderedLogicalOp generates the short-circuit evaluation of a chain of && or || operators
+// with the operands ordered by the estimated evaluation cost.  The expressions have no side effects,
+// so the order does not change the result, except for the errors of the operands that are no longer evaluated.
+func (repo *CompareCondRepo) genEvalForCostOrderedLogicalOp(n *ast.BinaryExpr, scope *ForEachScope) condition.Operand {
+	op := n.Op
+	nodes := flattenLogicalOp(op, n, nil)
+	sort.SliceStable(nodes, func(i, j int) bool { return predicateCost(nodes[i]) < predicateCost(nodes[j]) })
+
+	evals := make([]condition.Operand, len(nodes))
+	for i, node := range nodes {
+		evals[i] = repo.evalAstNode(node, scope)
+		if evals[i].GetKind() == condition.ErrorOperandKind {
+			return evals[i]
+		}
+	}
+
+	// For && the evaluation continues while the operands are true and the result is true if all of them are,
+	// and vice versa for ||.
+	isAnd := op == token.LAND
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			for _, eval := range evals {
+				v := eval.Evaluate(event, frames).Convert(condition.BooleanOperandKind)
+				if v.GetKind() == condition.ErrorOperandKind {
+					return v
+				}
+				if bool(v.(condition.BooleanOperand)) != isAnd {
+					return v
+				}
+			}
+			return condition.NewBooleanOperand(isAnd)
+		}, append([]condition.Operand{condition.NewStringOperand(op.String())}, evals...)...)
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+)
+
+// RuleDefaultField is the rule metadata field making the rule the default one, matched only when no other rule is.
+const RuleDefaultField = "default"
+
+// metadataDefault returns the boolean default flag of the rule metadata, false when there is none.
+func metadataDefault(metadata map[string]interface{}) (bool, error) {
+	v, ok := metadata[RuleDefaultField]
+	if !ok || v == nil {
+		return false, nil
+	}
+	isDefault, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("rule %s must be a boolean: %v", RuleDefaultField, v)
+	}
+	return isDefault, nil
+}
+
+// defaultRuleFlags flags the default rules by rule id, nil when there are none.
+func defaultRuleFlags(rules []*GeneralRuleRecord) []bool {
+	var result []bool
+	for id, rule := range rules {
+		if rule != nil && rule.definition.Default {
+			if result == nil {
+				result = make([]bool, len(rules))
+			}
+			result[id] = true
+		}
+	}
+	return result
+}
+
+func (f *RuleEngine) ruleDefault(ruleId condition.RuleIdType) bool {
+	return f.defaultRules != nil && f.defaultRules[ruleId]
+}
+
+// applyDefaultRules is the post-pass over the matches of the event removing the default rules when any other rule
+// matches.  The default rules are compiled and matched by the category engine like the other rules, so that only
+// those whose conditions hold are kept when no other rule matches.
+func (f *RuleEngine) applyDefaultRules(matches []condition.RuleIdType) []condition.RuleIdType {
+	if f.defaultRules == nil {
+		return matches
+	}
+	onlyDefault := true
+	for _, ruleId := range matches {
+		if !f.defaultRules[ruleId] {
+			onlyDefault = false
+			break
+		}
+	}
+	if onlyDefault {
+		return matches
+	}
+	result := matches[:0]
+	for _, ruleId := range matches {
+		if !f.defaultRules[ruleId] {
+			result = append(result, ruleId)
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"bytes"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/printer"
+	"go/token"
+	"hash/fnv"
+)
+
+// RuleDiff lists the ids of the rules that differ between two repos.
+type RuleDiff struct {
+	// Added are the rules of the new repo missing in the old one.
+	Added []uint
+	// Removed are the rules of the old repo missing in the new one.
+	Removed []uint
+	// Modified are the rules present in both repos whose fingerprints differ.
+	Modified []uint
+}
+
+// RuleFingerprint returns the fingerprint of the rule condition.  The expressions differing only in formatting,
+// e.g. `a==1` and `a == 1`, have the same fingerprint, while the metadata does not affect it.  The fingerprint of an
+// unregistered rule is 0.
+func (repo *RuleEngineRepo) RuleFingerprint(ruleId uint) uint64 {
+	if !repo.hasRule(ruleId) {
+		return 0
+	}
+	cond := repo.Rules[ruleId].definition.Condition
+	exprCond, ok := cond.(*condition.ExprCondition)
+	if !ok {
+		return cond.GetHash()
+	}
+	expr := []byte(exprCond.Expr)
+	if node, err := parseExprCondition(exprCond); err == nil {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, token.NewFileSet(), node); err == nil {
+			expr = buf.Bytes()
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(expr)
+	return h.Sum64()
+}
+
+// DiffRepos reports the rules that may change their match outcome when the old repo is replaced by the new one.
+// The rules are identified by their ids and compared by their fingerprints, so the diff is a static approximation:
+// a modified rule may still match the same events.
+func DiffRepos(oldRepo, newRepo *RuleEngineRepo) RuleDiff {
+	var result RuleDiff
+	numRules := len(oldRepo.Rules)
+	if len(newRepo.Rules) > numRules {
+		numRules = len(newRepo.Rules)
+	}
+	for id := 0; id < numRules; id++ {
+		ruleId := uint(id)
+		inOld, inNew := oldRepo.hasRule(ruleId), newRepo.hasRule(ruleId)
+		switch {
+		case inOld && !inNew:
+			result.Removed = append(result.Removed, ruleId)
+		case !inOld && inNew:
+			result.Added = append(result.Added, ruleId)
+		case inOld && oldRepo.RuleFingerprint(ruleId) != newRepo.RuleFingerprint(ruleId):
+			result.Modified = append(result.Modified, ruleId)
+		}
+	}
+	return result
+}
+
+// hasRule tells whether the rule is registered and not unregistered.
+func (repo *RuleEngineRepo) hasRule(ruleId uint) bool {
+	return ruleId < uint(len(repo.Rules)) && repo.Rules[ruleId] != nil
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"sync/atomic"
+)
+
+// RuleEnabledField is the rule metadata field enabling or disabling the rule.
+const RuleEnabledField = "enabled"
+
+// metadataEnabled returns the boolean enabled flag of the rule metadata, true when there is none.
+func metadataEnabled(metadata map[string]interface{}) (bool, error) {
+	v, ok := metadata[RuleEnabledField]
+	if !ok || v == nil {
+		return true, nil
+	}
+	enabled, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("rule %s must be a boolean: %v", RuleEnabledField, v)
+	}
+	return enabled, nil
+}
+
+// SetRuleEnabled enables or disables the rule at runtime.  The disabled rules are still evaluated, but are omitted
+// from the matches.  It is safe to call concurrently with matching the events.
+func (f *RuleEngine) SetRuleEnabled(ruleId condition.RuleIdType, enabled bool) error {
+	if int(ruleId) >= len(f.disabled) {
+		return f.repo.ctx.Errorf("unknown rule: %d", ruleId)
+	}
+	var flag int32
+	if !enabled {
+		flag = 1
+	}
+	if old := atomic.SwapInt32(&f.disabled[ruleId], flag); old != flag {
+		atomic.AddInt32(&f.numDisabled, flag-old)
+	}
+	return nil
+}
+
+// RuleEnabled tells whether the rule is enabled.
+func (f *RuleEngine) RuleEnabled(ruleId condition.RuleIdType) bool {
+	return int(ruleId) < len(f.disabled) && atomic.LoadInt32(&f.disabled[ruleId]) == 0
+}
+
+func (f *RuleEngine) anyRuleDisabled() bool {
+	return atomic.LoadInt32(&f.numDisabled) != 0
+}
+
+func (f *RuleEngine) ruleDisabled(ruleId condition.RuleIdType) bool {
+	return f.anyRuleDisabled() && atomic.LoadInt32(&f.disabled[ruleId]) != 0
+}
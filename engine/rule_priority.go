@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"math"
+	"sort"
+)
+
+// RulePriorityField is the rule metadata field holding the priority of the rule.
+const RulePriorityField = "priority"
+
+// metadataPriority returns the integer priority of the rule metadata, 0 when there is none.
+func metadataPriority(metadata map[string]interface{}) (int, error) {
+	v, ok := metadata[RulePriorityField]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	switch p := v.(type) {
+	case int:
+		return p, nil
+	case int64:
+		return int(p), nil
+	case uint64:
+		return int(p), nil
+	case float64:
+		// JSON numbers are decoded as floats
+		if p == math.Trunc(p) {
+			return int(p), nil
+		}
+	}
+	return 0, fmt.Errorf("rule %s must be an integer: %v", RulePriorityField, v)
+}
+
+func rulePriorities(repo *RuleEngineRepo) []int {
+	result := make([]int, len(repo.Rules))
+	for id, rule := range repo.Rules {
+		if rule != nil {
+			result[id] = rule.definition.Priority
+		}
+	}
+	return result
+}
+
+// MatchEventSorted matches the event like MatchEvent and returns the matched rules sorted by descending priority,
+// see InternalRule.Priority, and then by the registration order.
+func (f *RuleEngine) MatchEventSorted(v interface{}) []condition.RuleIdType {
+	result := f.MatchEvent(v)
+	sort.Slice(result, func(i, j int) bool {
+		pi, pj := f.priorities[result[i]], f.priorities[result[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/cateng"
+	"github.com/atlasgurus/rulestone/condition"
+)
+
+// RuleTenantField is the rule metadata field holding the tenant owning the rule.
+const RuleTenantField = "tenant"
+
+// metadataTenant returns the string tenant of the rule metadata, empty when there is none.
+func metadataTenant(metadata map[string]interface{}) (string, error) {
+	v, ok := metadata[RuleTenantField]
+	if !ok || v == nil {
+		return "", nil
+	}
+	tenant, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("rule %s must be a string: %v", RuleTenantField, v)
+	}
+	return tenant, nil
+}
+
+// newTenantEngines builds a category engine for the rules of each tenant, nil when no rule has a tenant.
+func newTenantEngines(
+	rules []*GeneralRuleRecord, ruleRepo *condition.RuleRepo, options *cateng.Options) map[string]*cateng.CategoryEngine {
+	var tenantRules map[string][]*condition.Rule
+	for _, rule := range ruleRepo.Rules {
+		tenant := rules[rule.RuleId].definition.Tenant
+		if tenant == "" {
+			continue
+		}
+		if tenantRules == nil {
+			tenantRules = make(map[string][]*condition.Rule)
+		}
+		tenantRules[tenant] = append(tenantRules[tenant], rule)
+	}
+	if tenantRules == nil {
+		return nil
+	}
+	result := make(map[string]*cateng.CategoryEngine, len(tenantRules))
+	for tenant, rules := range tenantRules {
+		result[tenant] = cateng.NewCategoryEngine(condition.NewRuleRepo(rules), options)
+	}
+	return result
+}
+
+// MatchEventForTenant matches the event like MatchEvent, but only against the rules of the tenant, see
+// RuleTenantField, so that the events of one tenant never match the rules of another.  Each tenant has its own
+// filter tables, so that resolving the matches does not go through the rules of the other tenants, while the
+// conditions of the rules of all the tenants are compiled into the same categories.  The rules without a tenant
+// are only matched by MatchEvent.
+func (f *RuleEngine) MatchEventForTenant(v interface{}, tenant string) []condition.RuleIdType {
+	catEngine, ok := f.tenantEngines[tenant]
+	if !ok {
+		return nil
+	}
+	cats, timedOut := f.evalEventCategories(v)
+	return f.excludeRules(catEngine.MatchEvent(cats), timedOut)
+}
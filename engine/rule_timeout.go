@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/types"
+)
+
+// forEachCategory calls fn for each category referenced by the category condition.
+func forEachCategory(cond condition.Condition, fn func(cat types.Category)) {
+	switch cond.GetKind() {
+	case condition.AndCondKind, condition.OrCondKind, condition.NotCondKind:
+		for _, operand := range cond.GetOperands() {
+			forEachCategory(operand, fn)
+		}
+	case condition.CategoryCondKind:
+		fn(cond.(*condition.CategoryCond).Cat)
+	}
+}
+
+// timedOutRules returns the rules referencing the categories whose evaluation exceeded the per rule timeout,
+// counting them in the NumRuleTimeouts metric.
+func (f *RuleEngine) timedOutRules(timedOut []types.Category) map[condition.RuleIdType]bool {
+	if len(timedOut) == 0 {
+		return nil
+	}
+	result := make(map[condition.RuleIdType]bool)
+	for _, cat := range timedOut {
+		for _, ruleId := range f.compCondRepo.categoryRules[cat] {
+			result[ruleId] = true
+		}
+	}
+	f.metricsMu.Lock()
+	f.Metrics.NumRuleTimeouts += uint64(len(result))
+	f.metricsMu.Unlock()
+	return result
+}
+
+// matchCategories resolves the event categories to the matching rules, excluding the disabled and the timed out rules.
+func (f *RuleEngine) matchCategories(cats []types.Category, timedOut []types.Category) []condition.RuleIdType {
+	return f.excludeRules(f.catEngine.MatchEvent(cats), timedOut)
+}
+
+// excludeRules removes the disabled rules and the rules referencing the timed out categories from the matches,
+// and the default rules unless no other rule matches.
+func (f *RuleEngine) excludeRules(
+	matches []condition.RuleIdType, timedOut []types.Category) []condition.RuleIdType {
+	return f.excludeFailedRules(matches, timedOut, nil)
+}
+
+// excludeFailedRules removes the matches like excludeRules, and also the failed rules.
+func (f *RuleEngine) excludeFailedRules(
+	matches []condition.RuleIdType, timedOut []types.Category,
+	failed map[condition.RuleIdType]bool) []condition.RuleIdType {
+	excluded := f.timedOutRules(timedOut)
+	if excluded == nil && failed == nil && !f.anyRuleDisabled() {
+		return f.applyDefaultRules(matches)
+	}
+	result := matches[:0]
+	for _, ruleId := range matches {
+		if !excluded[ruleId] && !failed[ruleId] && !f.ruleDisabled(ruleId) {
+			result = append(result, ruleId)
+		}
+	}
+	return f.applyDefaultRules(result)
+}
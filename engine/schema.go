@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Schema is the subset of a JSON Schema describing the types of the event attributes, with the "type",
+// "properties" and "items" keywords.  The other keywords are ignored.
+type Schema struct {
+	Type       schemaTypes        `json:"type,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+}
+
+// schemaTypes are the JSON Schema types of an attribute, given as a single type or a list of types.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings: %s", data)
+	}
+	*t = list
+	return nil
+}
+
+// ParseSchema parses the JSON Schema describing the events.
+func ParseSchema(data []byte) (*Schema, error) {
+	var result Schema
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// allows tells whether the attribute may have the JSON type, where "number" includes "integer".  An attribute without
+// declared types allows any.
+func (s *Schema) allows(jsonType string) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		if t == jsonType || t == "integer" && jsonType == "number" {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the schema of the nested attribute at the dotted path, nil if it is not declared.
+func (s *Schema) lookup(path []string) *Schema {
+	for _, name := range path {
+		if s = s.Properties[name]; s == nil {
+			return nil
+		}
+	}
+	return s
+}
+
+// schemaValidator checks the comparisons and the arithmetic of the rules against the attribute types declared by
+// the schema.
+type schemaValidator struct {
+	schema *Schema
+	// elements are the schemas of the elements of the arrays iterated by forAll(), forSome() etc. by element name.
+	elements map[string]*Schema
+	errors   []error
+}
+
+// validateRule validates the expressions of the rule condition.
+func (v *schemaValidator) validateRule(cond condition.Condition) {
+	switch cond.GetKind() {
+	case condition.AndCondKind, condition.OrCondKind, condition.NotCondKind:
+		for _, operand := range cond.GetOperands() {
+			v.validateRule(operand)
+		}
+	case condition.ExprCondKind:
+		node, err := parseExprCondition(cond.(*condition.ExprCondition))
+		if err != nil {
+			// The parse errors are reported by the compilation
+			return
+		}
+		v.validateNode(node)
+	}
+}
+
+func (v *schemaValidator) validateNode(node ast.Expr) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		v.validateNode(n.X)
+	case *ast.UnaryExpr:
+		v.validateNode(n.X)
+	case *ast.BinaryExpr:
+		v.validateOperands(n)
+		v.validateNode(n.X)
+		v.validateNode(n.Y)
+	case *ast.CallExpr:
+		v.validateCall(n)
+	}
+}
+
+// validateOperands reports the attributes compared with the literals or the arithmetic results of another type,
+// e.g. a string attribute compared with a number, and the non-numeric attributes in the arithmetic.
+func (v *schemaValidator) validateOperands(n *ast.BinaryExpr) {
+	xPath, xSchema := v.attribute(n.X)
+	yPath, ySchema := v.attribute(n.Y)
+	switch n.Op {
+	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
+		if xSchema != nil {
+			v.checkType(xPath, xSchema, "number", "used in arithmetic")
+		}
+		if ySchema != nil {
+			v.checkType(yPath, ySchema, "number", "used in arithmetic")
+		}
+	case token.EQL, token.NEQ, token.LSS, token.GTR, token.LEQ, token.GEQ:
+		if xType := operandType(n.X); ySchema != nil && xType != "" {
+			v.checkType(yPath, ySchema, xType, "compared with a "+xType)
+		}
+		if yType := operandType(n.Y); xSchema != nil && yType != "" {
+			v.checkType(xPath, xSchema, yType, "compared with a "+yType)
+		}
+	}
+}
+
+func (v *schemaValidator) checkType(path string, schema *Schema, jsonType string, usage string) {
+	if !schema.allows(jsonType) {
+		v.errors = append(v.errors, fmt.Errorf("%s of type %s is %s", path, strings.Join(schema.Type, "|"), usage))
+	}
+}
+
+// validateCall validates the arguments of the call.  The array functions taking the path and the element name,
+// e.g. forSome("orders", "o", o.total > 100), declare the element with the schema of the array items.
+func (v *schemaValidator) validateCall(n *ast.CallExpr) {
+	if len(n.Args) >= 3 {
+		path, pathOk := stringLiteral(n.Args[0])
+		elem, elemOk := stringLiteral(n.Args[1])
+		if pathOk && elemOk {
+			_, arraySchema := v.attributeAt(strings.Split(path, "."))
+			if arraySchema != nil && arraySchema.Items != nil {
+				prev, shadowed := v.elements[elem]
+				v.elements[elem] = arraySchema.Items
+				defer func() {
+					if shadowed {
+						v.elements[elem] = prev
+					} else {
+						delete(v.elements, elem)
+					}
+				}()
+			}
+		}
+	}
+	for _, arg := range n.Args {
+		v.validateNode(arg)
+	}
+}
+
+// attribute returns the path and the declared schema of the attribute referenced by the node, nil if the node
+// is not an attribute declared by the schema.
+func (v *schemaValidator) attribute(node ast.Expr) (string, *Schema) {
+	path, ok := astToAttributePath(node)
+	if !ok {
+		return "", nil
+	}
+	return v.attributeAt(strings.Split(path, "."))
+}
+
+func (v *schemaValidator) attributeAt(segments []string) (string, *Schema) {
+	path := strings.Join(segments, ".")
+	if elemSchema, ok := v.elements[segments[0]]; ok {
+		return path, elemSchema.lookup(segments[1:])
+	}
+	return path, v.schema.lookup(segments)
+}
+
+// operandType returns the JSON type of the literal or of the arithmetic result, empty for the other nodes.
+func operandType(node ast.Expr) string {
+	if isArithmeticExpr(node) {
+		return "number"
+	}
+	lit, ok := node.(*ast.BasicLit)
+	if !ok {
+		return ""
+	}
+	switch lit.Kind {
+	case token.INT, token.FLOAT:
+		return "number"
+	case token.STRING:
+		return "string"
+	}
+	return ""
+}
+
+func stringLiteral(node ast.Expr) (string, bool) {
+	lit, ok := node.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// validateSchema reports the rules using the attributes inconsistently with their types declared by the schema.
+func (repo *CompareCondRepo) validateSchema(ruleRepo *RuleEngineRepo, schema *Schema) error {
+	var result error
+	for id, rule := range ruleRepo.Rules {
+		if rule == nil {
+			continue
+		}
+		v := &schemaValidator{schema: schema, elements: make(map[string]*Schema)}
+		v.validateRule(rule.definition.Condition)
+		for _, clause := range rule.definition.When {
+			v.validateRule(condition.NewExprCondition(clause))
+		}
+		for _, err := range v.errors {
+			err = repo.ctx.Errorf("rule %d does not match the schema: %s", id, err)
+			if result == nil {
+				result = err
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+	"go/parser"
+	"math"
+	"strings"
+)
+
+// aggregateKinds are the rolling aggregates maintained by StatefulMatcher.
+var aggregateKinds = map[string]bool{"avg": true, "sum": true, "min": true, "max": true, "count": true}
+
+// parseExpr parses the rule expression, rewriting the references to the rolling aggregates, e.g. @avg("amount"),
+// into the aggregate("avg", "amount") calls.  The syntax errors are ExprParseError positioned in the rewritten
+// expression.
+func parseExpr(expr string) (ast.Expr, error) {
+	expanded := expandAggregateRefs(expr)
+	node, err := parser.ParseExpr(expanded)
+	if err != nil {
+		return nil, newExprParseError(expanded, err)
+	}
+	return node, nil
+}
+
+// expandAggregateRefs rewrites @name(args) outside the string literals into aggregate("name", args).
+func expandAggregateRefs(expr string) string {
+	if !strings.Contains(expr, "@") {
+		return expr
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(expr) {
+				b.WriteByte(c)
+				i++
+				c = expr[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '@':
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || 'a' <= expr[j] && expr[j] <= 'z' || 'A' <= expr[j] && expr[j] <= 'Z') {
+				j++
+			}
+			if j > i+1 && j < len(expr) && expr[j] == '(' {
+				// The trailing comma is allowed when there are no arguments, e.g. aggregate("count", )
+				fmt.Fprintf(&b, "aggregate(%q, ", expr[i+1:j])
+				i = j
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// funcAggregate implements aggregate(kind, path), written as @kind(path), e.g. @avg("amount"), returning the rolling
+// aggregate of the attribute over the previous events fed to StatefulMatcher.  The result is undefined when there
+// are no previous values and outside StatefulMatcher.
+func funcAggregate(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for aggregate reference"))
+	}
+	kind, err := repo.evalConstStringArg("aggregate", n.Args[0], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	if !aggregateKinds[kind] {
+		return condition.NewErrorOperand(fmt.Errorf("unknown aggregate: @%s", kind))
+	}
+	path, err := repo.evalConstStringArg("aggregate", n.Args[1], scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	if scope.NestingLevel != 0 {
+		return condition.NewErrorOperand(fmt.Errorf("@%s() can not be used inside forAll() or forSome()", kind))
+	}
+	attrExpr, err := parser.ParseExpr(path)
+	if err != nil {
+		return condition.NewErrorOperand(fmt.Errorf("invalid aggregate path %q: %s", path, err))
+	}
+	// Evaluate the condition whenever the aggregated attribute is present in the event.
+	if attr := repo.evalAstNode(attrExpr, scope); attr.GetKind() == condition.ErrorOperandKind {
+		return attr
+	}
+	repo.aggregatePaths[path] = true
+
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			if event.Aggregate == nil {
+				return condition.NewNullOperand(nil)
+			}
+			v, ok := event.Aggregate(kind, path)
+			if !ok {
+				return condition.NewNullOperand(nil)
+			}
+			if kind == "count" {
+				return condition.NewIntOperand(int64(v))
+			}
+			return condition.NewFloatOperand(v)
+		}, condition.NewStringOperand("aggregate"), condition.NewStringOperand(kind), condition.NewStringOperand(path))
+}
+
+// ringBuffer holds the last values of an attribute.
+type ringBuffer struct {
+	values []float64
+	next   int
+	len    int
+}
+
+func (r *ringBuffer) push(v float64) {
+	r.values[r.next] = v
+	r.next = (r.next + 1) % len(r.values)
+	if r.len < len(r.values) {
+		r.len++
+	}
+}
+
+func (r *ringBuffer) aggregate(kind string) (float64, bool) {
+	if kind == "count" {
+		return float64(r.len), true
+	}
+	if r.len == 0 {
+		return 0, false
+	}
+	values := r.values[:r.len]
+	switch kind {
+	case "min", "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			if kind == "min" {
+				result = math.Min(result, v)
+			} else {
+				result = math.Max(result, v)
+			}
+		}
+		return result, true
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	if kind == "avg" {
+		return sum / float64(r.len), true
+	}
+	return sum, true
+}
+
+// StatefulMatcher matches a stream of events against the rules referencing the rolling aggregates of the previous
+// events, e.g. amount > 3 * @avg("amount").  The aggregates @avg, @sum, @min, @max and @count are maintained for each
+// referenced attribute over its numeric values in the last size events, undefined values are not recorded.
+//
+// The matcher is not safe for concurrent use: the events must be fed by a single goroutine.  The engine itself may
+// still be used concurrently by other goroutines.
+type StatefulMatcher struct {
+	engine *RuleEngine
+	series map[string]*ringBuffer
+}
+
+// NewStatefulMatcher returns a matcher maintaining the aggregates referenced by the rules of the engine over the
+// last size events.
+func NewStatefulMatcher(engine *RuleEngine, size int) (*StatefulMatcher, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid aggregate window size: %d", size)
+	}
+	result := &StatefulMatcher{engine: engine, series: make(map[string]*ringBuffer)}
+	for path := range engine.compCondRepo.aggregatePaths {
+		result.series[path] = &ringBuffer{values: make([]float64, size)}
+	}
+	return result, nil
+}
+
+// MatchEvent matches the event against the rules, with the aggregates computed over the previous events, and then
+// updates the aggregates with the event.
+func (m *StatefulMatcher) MatchEvent(v map[string]interface{}) []condition.RuleIdType {
+	f := m.engine
+	matches := f.matchCategories(f.evalMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			event := f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+			event.Aggregate = m.aggregate
+			return event
+		}))
+	m.Update(v)
+	return matches
+}
+
+// Update adds the event to the aggregates without matching it, e.g. to warm up the aggregates.
+func (m *StatefulMatcher) Update(v map[string]interface{}) {
+	for path, series := range m.series {
+		value, ok := lookupEventPath(v, path)
+		if !ok {
+			continue
+		}
+		operand := m.engine.repo.MapScalar(value).(condition.Operand).Convert(condition.FloatOperandKind)
+		if operand.GetKind() == condition.FloatOperandKind {
+			series.push(float64(operand.(condition.FloatOperand)))
+		}
+	}
+}
+
+func (m *StatefulMatcher) aggregate(kind string, path string) (float64, bool) {
+	series, ok := m.series[path]
+	if !ok {
+		return 0, false
+	}
+	return series.aggregate(kind)
+}
+
+// lookupEventPath returns the value of the event at the dotted path.
+func lookupEventPath(v map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = v
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"github.com/atlasgurus/rulestone/types"
+	"sort"
+	"strings"
+)
+
+// EvalError is the failed evaluation of a condition of the rule for an event, reported by MatchEventStrict.
+type EvalError struct {
+	RuleId condition.RuleIdType
+	// Attribute is the path of the attribute referenced by the failed condition, with the arrays suffixed by "[]",
+	// e.g. "orders[]" for forSome("orders", ...).  The paths are comma separated when the condition references
+	// several attributes.
+	Attribute string
+	Err       error
+}
+
+func (e EvalError) Error() string {
+	return fmt.Sprintf("rule %d failed on %s: %s", e.RuleId, e.Attribute, e.Err)
+}
+
+// MatchEventStrict matches the event like MatchEvent and, when the engine is created WithStrictEvaluation, also
+// returns the errors of the rules whose conditions failed to evaluate, ordered by the rule id, excluding these rules
+// from the matches.  Without the option the errors are nil.
+func (f *RuleEngine) MatchEventStrict(v interface{}) ([]condition.RuleIdType, []EvalError) {
+	if !f.compCondRepo.options.StrictEvaluation {
+		return f.MatchEvent(v), nil
+	}
+	var evalErrors []EvalError
+	var failed map[condition.RuleIdType]bool
+	cats, timedOut := f.recordMappedEventCategories(
+		func(attrCallback func([]int)) *objectmap.ObjectAttributeMap {
+			return f.compCondRepo.ObjectAttributeMapper.MapObject(v, attrCallback)
+		},
+		func(cat types.Category, result condition.Operand) {
+			if result.GetKind() != condition.ErrorOperandKind {
+				return
+			}
+			if failed == nil {
+				failed = make(map[condition.RuleIdType]bool)
+			}
+			attribute := f.categoryAttribute(cat)
+			for _, ruleId := range f.compCondRepo.categoryRules[cat] {
+				if f.ruleDisabled(ruleId) {
+					continue
+				}
+				failed[ruleId] = true
+				evalErrors = append(evalErrors,
+					EvalError{RuleId: ruleId, Attribute: attribute, Err: result.(condition.ErrorOperand).Err})
+			}
+		})
+	// The categories are evaluated in no particular order
+	sort.SliceStable(evalErrors, func(i, j int) bool {
+		if evalErrors[i].RuleId != evalErrors[j].RuleId {
+			return evalErrors[i].RuleId < evalErrors[j].RuleId
+		}
+		return evalErrors[i].Attribute < evalErrors[j].Attribute
+	})
+	return f.excludeFailedRules(f.catEngine.MatchEvent(cats), timedOut, failed), evalErrors
+}
+
+// categoryAttribute returns the paths of the attributes whose presence triggers the evaluation of the category.
+func (f *RuleEngine) categoryAttribute(cat types.Category) string {
+	repo := f.compCondRepo
+	rec := repo.EvalCategoryRecs[cat-1]
+	paths := make([]string, 0, len(rec.AttrKeys))
+	for _, key := range rec.AttrKeys {
+		paths = append(paths, repo.ObjectAttributeMapper.RootDictRec.MatchKeyPath(key))
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"go/token"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSimilarityInputLength bounds the length in characters of the similarity() inputs as the edit distance
+// computation is quadratic in the input length.
+const maxSimilarityInputLength = 1000
+
+// funcSimilarity implements similarity(a, b) returning 1 - normalized Levenshtein distance between the strings,
+// which is 1 for identical strings and 0 for the strings having nothing in common.
+func funcSimilarity(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	a, b := []rune(strs[0]), []rune(strs[1])
+	if len(a) > maxSimilarityInputLength || len(b) > maxSimilarityInputLength {
+		return condition.NewErrorOperand(
+			fmt.Errorf("similarity() input exceeds the maximum length of %d", maxSimilarityInputLength))
+	}
+	maxLen := len(a)
+	if len(b) > maxLen {
+		maxLen = len(b)
+	}
+	if maxLen == 0 {
+		return condition.NewFloatOperand(1)
+	}
+	return condition.NewFloatOperand(1 - float64(levenshteinDistance(a, b))/float64(maxLen))
+}
+
+// levenshteinDistance computes the edit distance between the strings using a single row of the DP table.
+func levenshteinDistance(a, b []rune) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diag := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := diag + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			diag, row[j] = row[j], next
+		}
+	}
+	return row[len(b)]
+}
+
+// funcShannonEntropy implements shannonEntropy(value) returning the Shannon entropy in bits per character of the
+// value converted to string, e.g. 0 for "aaaa" and 2 for "abcd".  The random tokens and secrets have high entropy.
+func funcShannonEntropy(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range strs[0] {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return condition.NewFloatOperand(entropy)
+}
+
+// funcParseLeadingNumber implements parseLeadingNumber(value) returning the integer formed by the leading digits
+// of the string, or undefined if the string does not start with a digit.
+func funcParseLeadingNumber(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	s := strs[0]
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return parseDigits(s[:end])
+}
+
+// funcParseTrailingNumber implements parseTrailingNumber(value) returning the integer formed by the trailing digits
+// of the string, or undefined if the string does not end with a digit.  For example "ORD-10045" yields 10045.
+func funcParseTrailingNumber(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	s := strs[0]
+	start := len(s)
+	for start > 0 && s[start-1] >= '0' && s[start-1] <= '9' {
+		start--
+	}
+	return parseDigits(s[start:])
+}
+
+func parseDigits(digits string) condition.Operand {
+	if digits == "" {
+		return condition.NewNullOperand(nil)
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	// Numbers are represented as floats the same as numeric literals and JSON numbers
+	return condition.NewFloatOperand(float64(v))
+}
+
+// newDigestFunc creates the function returning the hex digest of its string argument computed by sum.
+func newDigestFunc(sum func(data []byte) []byte) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewStringOperand(hex.EncodeToString(sum([]byte(strs[0]))))
+	}
+}
+
+var (
+	funcCrc32 = newDigestFunc(func(data []byte) []byte {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(data))
+		return sum[:]
+	})
+	funcMd5 = newDigestFunc(func(data []byte) []byte {
+		sum := md5.Sum(data)
+		return sum[:]
+	})
+	funcSha256 = newDigestFunc(func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	})
+)
+
+// newStringMapFunc creates the function applying mapping to its argument converted to string.
+func newStringMapFunc(mapping func(string) string) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewStringOperand(mapping(strs[0]))
+	}
+}
+
+var (
+	funcLower = newStringMapFunc(strings.ToLower)
+	funcUpper = newStringMapFunc(strings.ToUpper)
+	// funcCollapseSpaces implements collapseSpaces(value) replacing the runs of whitespace with a single space and
+	// trimming the leading and trailing whitespace.
+	funcCollapseSpaces = newStringMapFunc(func(s string) string { return strings.Join(strings.Fields(s), " ") })
+)
+
+// newTrimFunc creates the function trimming its first argument converted to string.  The whitespace is trimmed
+// with trimSpace, unless the second argument specifies the cutset of the characters to trim with trimCutset.
+func newTrimFunc(trimSpace func(string) string, trimCutset func(string, string) string) valueFuncT {
+	return func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		if len(strs) == 2 {
+			return condition.NewStringOperand(trimCutset(strs[0], strs[1]))
+		}
+		return condition.NewStringOperand(trimSpace(strs[0]))
+	}
+}
+
+var (
+	funcTrim      = newTrimFunc(strings.TrimSpace, strings.Trim)
+	funcTrimLeft  = newTrimFunc(func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) }, strings.TrimLeft)
+	funcTrimRight = newTrimFunc(func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) }, strings.TrimRight)
+)
+
+// funcSplit implements split(value, sep) returning the list of the substrings of the value separated by sep.
+// The list can be indexed, e.g. split(path, "/")[2], and measured with length().
+func funcSplit(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	parts := strings.Split(strs[0], strs[1])
+	list := make([]condition.Operand, len(parts))
+	for i, part := range parts {
+		list[i] = condition.NewStringOperand(part)
+	}
+	return condition.NewListOperand(list)
+}
+
+// funcIndexOf implements indexOf(value, substr) returning the character index of the first occurrence of substr
+// in the value, or -1 if the value does not contain it.
+func funcIndexOf(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	i := strings.Index(strs[0], strs[1])
+	if i > 0 {
+		i = utf8.RuneCountInString(strs[0][:i])
+	}
+	return condition.NewIntOperand(int64(i))
+}
+
+// funcReplace implements replace(value, old, new) returning the value with all the occurrences of old replaced by
+// new, and replace(value, old, new, n) replacing at most the first n occurrences, all of them if n < 0.
+func funcReplace(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args[:3])
+	if errOperand != nil {
+		return errOperand
+	}
+	count := -1
+	if len(args) == 4 {
+		n := args[3].Convert(condition.IntOperandKind)
+		if n.GetKind() != condition.IntOperandKind {
+			return n
+		}
+		count = int(n.(condition.IntOperand))
+	}
+	return condition.NewStringOperand(strings.Replace(strs[0], strs[1], strs[2], count))
+}
+
+// funcConcat implements concat(a, b, ...) joining its arguments converted to strings.
+func funcConcat(args []condition.Operand) condition.Operand {
+	strs, errOperand := toStringArgs(args)
+	if errOperand != nil {
+		return errOperand
+	}
+	return condition.NewStringOperand(strings.Join(strs, ""))
+}
+
+// funcEqualsFold implements equalsFold(a, b) testing that the values converted to strings are equal ignoring case.
+func funcEqualsFold(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileValueFunc("equalsFold", n, 2, scope, func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewBooleanOperand(strings.EqualFold(strs[0], strs[1]))
+	})
+}
+
+// funcStartsWith implements startsWith(text, prefix) testing that the text starts with the prefix, both converted to
+// strings, e.g. startsWith(path, basePath) with the prefix given by another attribute.
+func funcStartsWith(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileValueFunc("startsWith", n, 2, scope, func(args []condition.Operand) condition.Operand {
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		return condition.NewBooleanOperand(strings.HasPrefix(strs[0], strs[1]))
+	})
+}
+
+// isConstPrefixMatch tells whether the call is startsWith(text, "p1", "p2", ...) with the string literal prefixes,
+// which share the prefix matcher of the text across the rules rather than being tested one by one.
+func isConstPrefixMatch(n *ast.CallExpr) bool {
+	if len(n.Args) < 2 {
+		return false
+	}
+	for _, arg := range n.Args[1:] {
+		if _, ok := stringLiteral(arg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// funcOnlyChars implements onlyChars(value, charset) testing that every character of the value converted to string
+// is one of the characters of the constant charset.  The empty string conforms to any charset.
+func funcOnlyChars(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) != 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for onlyChars() function"))
+	}
+	charsetOperand := repo.evalAstNode(n.Args[1], scope)
+	if charsetOperand.GetKind() == condition.ErrorOperandKind {
+		return charsetOperand
+	}
+	if !charsetOperand.IsConst() || charsetOperand.GetKind() != condition.StringOperandKind {
+		return condition.NewErrorOperand(fmt.Errorf("the second operand of onlyChars() must be a constant string"))
+	}
+	charset := make(map[rune]bool)
+	for _, r := range string(charsetOperand.(condition.StringOperand)) {
+		charset[r] = true
+	}
+
+	valueOperand := repo.evalAstNode(n.Args[0], scope)
+	if valueOperand.GetKind() == condition.ErrorOperandKind {
+		return valueOperand
+	}
+	return repo.newFuncOperand("onlyChars", []condition.Operand{valueOperand, charsetOperand},
+		func(args []condition.Operand) condition.Operand {
+			strs, errOperand := toStringArgs(args[:1])
+			if errOperand != nil {
+				return errOperand
+			}
+			for _, r := range strs[0] {
+				if !charset[r] {
+					return condition.NewBooleanOperand(false)
+				}
+			}
+			return condition.NewBooleanOperand(true)
+		})
+}
+
+// funcLuhnValid implements luhnValid(value) testing that the digits of the value pass the Luhn checksum used by
+// the card numbers.  Values with anything but digits, including separators, do not pass.
+func funcLuhnValid(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	return repo.compileValueFunc("luhnValid", n, 1, scope, luhnValid)
+}
+
+func luhnValid(args []condition.Operand) condition.Operand {
+	var digits string
+	switch args[0].GetKind() {
+	case condition.IntOperandKind, condition.FloatOperandKind:
+		// Format the number without exponent
+		digits = string(setKey(args[0]).(condition.StringOperand))
+	default:
+		strs, errOperand := toStringArgs(args)
+		if errOperand != nil {
+			return errOperand
+		}
+		digits = strs[0]
+	}
+	if digits == "" {
+		return condition.NewBooleanOperand(false)
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		c := digits[len(digits)-1-i]
+		if c < '0' || c > '9' {
+			return condition.NewBooleanOperand(false)
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return condition.NewBooleanOperand(sum%10 == 0)
+}
+
+// expandContainsAll rewrites containsAll(text, p1, p2, ...) into containsAny(text, p1) && containsAny(text, p2) && ...,
+// so that each of the patterns gets its own category.  The patterns share the string matcher of the text, which
+// finds all of them in one pass, and the category engine checks that every one was found.
+func expandContainsAll(n *ast.CallExpr) (ast.Expr, error) {
+	if len(n.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments for containsAll() function")
+	}
+	var result ast.Expr
+	for _, arg := range n.Args[1:] {
+		call := &ast.CallExpr{
+			Fun: ast.NewIdent("containsAny"), Lparen: n.Lparen, Args: []ast.Expr{n.Args[0], arg}, Rparen: n.Rparen}
+		if result == nil {
+			result = call
+		} else {
+			result = &ast.BinaryExpr{X: result, OpPos: arg.Pos(), Op: token.LAND, Y: call}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+)
+
+// valueFuncT computes the result of a function from its evaluated arguments.
+// The arguments are never error or null operands.
+type valueFuncT func(args []condition.Operand) condition.Operand
+
+// evalFuncArgs compiles the arguments of a function call that expects exactly numArgs arguments.
+func (repo *CompareCondRepo) evalFuncArgs(
+	funcName string, n *ast.CallExpr, numArgs int, scope *ForEachScope) ([]condition.Operand, error) {
+	if len(n.Args) != numArgs {
+		return nil, fmt.Errorf("wrong number of arguments for %s() function", funcName)
+	}
+	args := make([]condition.Operand, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = repo.evalAstNode(arg, scope)
+		if args[i].GetKind() == condition.ErrorOperandKind {
+			return nil, args[i].(condition.ErrorOperand).Err
+		}
+	}
+	return args, nil
+}
+
+// newFuncOperand creates the operand computing f from the argument operands.
+// An error or null argument is returned as the result without calling f.
+// The function is evaluated at compile time when all the arguments are constant.
+func (repo *CompareCondRepo) newFuncOperand(
+	funcName string, argOperands []condition.Operand, f valueFuncT) condition.Operand {
+	allConst := true
+	for _, arg := range argOperands {
+		if !arg.IsConst() {
+			allConst = false
+			break
+		}
+	}
+	if allConst {
+		return callValueFunc(f, argOperands)
+	}
+
+	// funcName as hash seed to avoid cache collisions
+	hashArgs := append([]condition.Operand{condition.NewStringOperand(funcName)}, argOperands...)
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			args := make([]condition.Operand, len(argOperands))
+			for i, arg := range argOperands {
+				args[i] = arg.Evaluate(event, frames)
+			}
+			return callValueFunc(f, args)
+		}, hashArgs...)
+}
+
+func callValueFunc(f valueFuncT, args []condition.Operand) condition.Operand {
+	for _, arg := range args {
+		switch arg.GetKind() {
+		case condition.ErrorOperandKind, condition.NullOperandKind:
+			return arg
+		}
+	}
+	return f(args)
+}
+
+// compileValueFunc compiles a call to the function of numArgs arguments computing its result with f.
+func (repo *CompareCondRepo) compileValueFunc(
+	funcName string, n *ast.CallExpr, numArgs int, scope *ForEachScope, f valueFuncT) condition.Operand {
+	args, err := repo.evalFuncArgs(funcName, n, numArgs, scope)
+	if err != nil {
+		return condition.NewErrorOperand(err)
+	}
+	return repo.newFuncOperand(funcName, args, f)
+}
+
+// compileValueFuncWithOptionalArg compiles a call to the function of numArgs arguments followed by an optional one,
+// e.g. trim(value) and trim(value, cutset).
+func (repo *CompareCondRepo) compileValueFuncWithOptionalArg(
+	funcName string, n *ast.CallExpr, numArgs int, scope *ForEachScope, f valueFuncT) condition.Operand {
+	if len(n.Args) == numArgs+1 {
+		numArgs++
+	}
+	return repo.compileValueFunc(funcName, n, numArgs, scope, f)
+}
+
+// toStringArgs converts the operands to strings.  It returns the error operand if any of the conversions fail.
+func toStringArgs(args []condition.Operand) ([]string, condition.Operand) {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		s := arg.Convert(condition.StringOperandKind)
+		if s.GetKind() != condition.StringOperandKind {
+			return nil, s
+		}
+		result[i] = string(s.(condition.StringOperand))
+	}
+	return result, nil
+}
+
+// newListIndexOperand creates the operand indexing the list computed by listOperand, e.g. split(path, "/")[2].
+// The index out of the list bounds evaluates to undefined.
+func (repo *CompareCondRepo) newListIndexOperand(
+	listOperand condition.Operand, indexOperand condition.Operand, scope *ForEachScope) condition.Operand {
+	indexOperand = repo.evalOperandAccess(repo.evalOperandAddress(indexOperand, scope), scope)
+	if indexOperand.GetKind() == condition.ErrorOperandKind {
+		return indexOperand
+	}
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			list := listOperand.Evaluate(event, frames)
+			switch list.GetKind() {
+			case condition.ErrorOperandKind, condition.NullOperandKind:
+				return list
+			case condition.ListOperandKind:
+			default:
+				return condition.NewErrorOperand(fmt.Errorf("indexed value is not a list"))
+			}
+			index := indexOperand.Evaluate(event, frames)
+			switch index.GetKind() {
+			case condition.ErrorOperandKind, condition.NullOperandKind:
+				return index
+			}
+			index = index.Convert(condition.IntOperandKind)
+			if index.GetKind() != condition.IntOperandKind {
+				return index
+			}
+			i := int(index.(condition.IntOperand))
+			elements := list.(*condition.ListOperand).List
+			if i < 0 || i >= len(elements) {
+				return condition.NewNullOperand(nil)
+			}
+			return elements[i]
+		}, condition.NewStringOperand("[]"), listOperand, indexOperand)
+}
+
+// funcCoalesce implements coalesce(a, b, ...) returning the first argument that is defined, skipping the missing
+// attributes and the nulls.  The result is undefined when all the arguments are.
+func funcCoalesce(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+	if len(n.Args) < 2 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for coalesce() function"))
+	}
+	var argOperands []condition.Operand
+	for _, arg := range n.Args {
+		argOperand := repo.evalAstNode(arg, scope)
+		switch {
+		case argOperand.GetKind() == condition.ErrorOperandKind:
+			return argOperand
+		case argOperand.GetKind() == condition.NullOperandKind:
+			continue
+		}
+		argOperands = append(argOperands, argOperand)
+		if argOperand.IsConst() {
+			// The arguments following a constant are never reached
+			break
+		}
+	}
+	if len(argOperands) == 0 {
+		return condition.NewNullOperand(nil)
+	}
+	if len(argOperands) == 1 && argOperands[0].IsConst() {
+		return argOperands[0]
+	}
+
+	hashArgs := append([]condition.Operand{condition.NewStringOperand("coalesce")}, argOperands...)
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			for _, arg := range argOperands {
+				if v := arg.Evaluate(event, frames); v.GetKind() != condition.NullOperandKind {
+					return v
+				}
+			}
+			return condition.NewNullOperand(nil)
+		}, hashArgs...)
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// version is the parsed semantic version, e.g. v1.2.3-rc.1+build.5.
+type version struct {
+	segments   [3]uint64
+	preRelease []string
+}
+
+// parseVersion parses the semantic version.  The leading "v" and the minor and patch segments are optional, the
+// missing segments are 0.  The build metadata following "+" is ignored.
+func parseVersion(s string) (version, bool) {
+	var result version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		result.preRelease = strings.Split(s[i+1:], ".")
+		for _, id := range result.preRelease {
+			if id == "" {
+				return result, false
+			}
+		}
+		s = s[:i]
+	}
+	segments := strings.Split(s, ".")
+	if len(segments) > len(result.segments) {
+		return result, false
+	}
+	for i, segment := range segments {
+		v, err := strconv.ParseUint(segment, 10, 64)
+		if err != nil {
+			return result, false
+		}
+		result.segments[i] = v
+	}
+	return result, true
+}
+
+// compareVersions returns -1, 0 or 1 following the semver precedence: the numeric segments are compared numerically,
+// a pre-release version precedes the release, and the pre-release identifiers are compared one by one, numerically
+// when both are numbers, with the numbers preceding the other identifiers.
+func compareVersions(a, b version) int {
+	for i := range a.segments {
+		if a.segments[i] != b.segments[i] {
+			if a.segments[i] < b.segments[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	if len(a.preRelease) == 0 || len(b.preRelease) == 0 {
+		return len(b.preRelease) - len(a.preRelease)
+	}
+	for i := 0; i < len(a.preRelease) && i < len(b.preRelease); i++ {
+		if c := comparePreReleaseIds(a.preRelease[i], b.preRelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.preRelease) < len(b.preRelease):
+		return -1
+	case len(a.preRelease) > len(b.preRelease):
+		return 1
+	}
+	return 0
+}
+
+func comparePreReleaseIds(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an < bn {
+			return -1
+		} else if an > bn {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// toVersionArg converts the operand to version.  Numbers are formatted without exponent, so that e.g. 2 is 2.0.0.
+func toVersionArg(arg condition.Operand) (version, bool) {
+	s := setKey(arg)
+	if s.GetKind() != condition.StringOperandKind {
+		return version{}, false
+	}
+	return parseVersion(string(s.(condition.StringOperand)))
+}
+
+// versionTests maps the version comparison functions to the tests of the comparison result.
+var versionTests = map[string]func(c int) bool{
+	"versionEq":  func(c int) bool { return c == 0 },
+	"versionLt":  func(c int) bool { return c < 0 },
+	"versionLte": func(c int) bool { return c <= 0 },
+	"versionGt":  func(c int) bool { return c > 0 },
+	"versionGte": func(c int) bool { return c >= 0 },
+}
+
+// funcVersionCompare returns the implementation of the version comparison function, e.g. versionLt(a, b), listed in
+// versionTests.  The invalid versions make the result undefined.
+func funcVersionCompare(funcName string) boolFuncT {
+	test := versionTests[funcName]
+	return func(repo *CompareCondRepo, n *ast.CallExpr, scope *ForEachScope) condition.Operand {
+		return repo.compileValueFunc(funcName, n, 2, scope, func(args []condition.Operand) condition.Operand {
+			a, ok := toVersionArg(args[0])
+			if !ok {
+				return condition.NewNullOperand(nil)
+			}
+			b, ok := toVersionArg(args[1])
+			if !ok {
+				return condition.NewNullOperand(nil)
+			}
+			return condition.NewBooleanOperand(test(compareVersions(a, b)))
+		})
+	}
+}
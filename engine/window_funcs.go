@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/objectmap"
+	"go/ast"
+)
+
+// windowEvents returns the events of the window the event is matched in.  Outside MatchWindow the window
+// consists of the matched event alone.
+func windowEvents(event *objectmap.ObjectAttributeMap) []*objectmap.ObjectAttributeMap {
+	if event.Window == nil {
+		return []*objectmap.ObjectAttributeMap{event}
+	}
+	return event.Window
+}
+
+// compileWindowFunc compiles the window aggregate funcName(expr) computing its result with aggregate from the values
+// of expr evaluated against each event of the window.
+func (repo *CompareCondRepo) compileWindowFunc(
+	funcName string, n *ast.CallExpr, scope *ForEachScope,
+	aggregate func(values []condition.Operand) condition.Operand) condition.Operand {
+	if len(n.Args) != 1 {
+		return condition.NewErrorOperand(fmt.Errorf("wrong number of arguments for %s() function", funcName))
+	}
+	if scope.NestingLevel != 0 {
+		return condition.NewErrorOperand(fmt.Errorf("%s() can not be used inside forAll() or forSome()", funcName))
+	}
+	exprOperand := repo.evalAstNode(n.Args[0], scope)
+	if exprOperand.GetKind() == condition.ErrorOperandKind {
+		return exprOperand
+	}
+	return repo.CondFactory.NewExprOperand(
+		func(event *objectmap.ObjectAttributeMap, frames []interface{}) condition.Operand {
+			window := windowEvents(event)
+			values := make([]condition.Operand, len(window))
+			root := frames[0]
+			for i, e := range window {
+				frames[0] = e.Values
+				values[i] = exprOperand.Evaluate(e, frames)
+			}
+			frames[0] = root
+			return aggregate(values)
+		}, condition.NewStringOperand(funcName), exprOperand)
+}
+
+// windowCount counts the events of the window for which the condition is true.
+func windowCount(values []condition.Operand) condition.Operand {
+	count := 0
+	for _, v := range values {
+		if v.GetKind() == condition.BooleanOperandKind && bool(v.(condition.BooleanOperand)) {
+			count++
+		}
+	}
+	return condition.NewIntOperand(int64(count))
+}
+
+// windowSum sums the numeric values over the events of the window skipping the undefined ones.
+func windowSum(values []condition.Operand) condition.Operand {
+	sum := 0.0
+	for _, v := range values {
+		switch v.GetKind() {
+		case condition.ErrorOperandKind:
+			return v
+		case condition.NullOperandKind:
+			continue
+		}
+		f := v.Convert(condition.FloatOperandKind)
+		if f.GetKind() != condition.FloatOperandKind {
+			return f
+		}
+		sum += float64(f.(condition.FloatOperand))
+	}
+	return condition.NewFloatOperand(sum)
+}
@@ -0,0 +1,163 @@
+package objectmap
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DecodeJSON decodes the JSON value read by the decoder into the generic representation accepted by MapObject.
+// Only the object fields referenced by the rules are materialized, the other ones are skipped while streaming.
+// The elements of the referenced arrays are all decoded, each with only its referenced fields, while the arrays
+// that are not referenced decode as empty.  The objects iterated by their entries are decoded in full.
+func (mapper *ObjectAttributeMapper) DecodeJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return mapper.decodeJSONValue(dec, tok, "", mapper.RootDictRec)
+}
+
+func (mapper *ObjectAttributeMapper) decodeJSONValue(
+	dec *json.Decoder, tok json.Token, path string, dictRec *AttrDictionaryRec) (interface{}, error) {
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		if _, ok := dictRec.dict[path+"{}[]"]; ok {
+			// The entries iterate over all the keys of the object
+			return decodeJSONTokens(dec, tok)
+		}
+		_, referenced := dictRec.dict[path]
+		prefix := path
+		if prefix != "" {
+			prefix += "."
+		}
+		result := make(map[string]interface{})
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := prefix + keyTok.(string)
+			valueTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if !referenced || !dictRec.isReferencedPath(key) {
+				if err := skipJSONValue(dec, valueTok); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value, err := mapper.decodeJSONValue(dec, valueTok, key, dictRec)
+			if err != nil {
+				return nil, err
+			}
+			result[keyTok.(string)] = value
+		}
+		_, err := dec.Token()
+		return result, err
+	case '[':
+		result := []interface{}{}
+		elemDictRec, ok := dictRec.dict[path+"[]"]
+		for dec.More() {
+			elemTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				if err := skipJSONValue(dec, elemTok); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			elem, err := mapper.decodeJSONValue(dec, elemTok, "", elemDictRec)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, elem)
+		}
+		_, err := dec.Token()
+		return result, err
+	default:
+		return nil, fmt.Errorf("unexpected JSON delimiter %s", delim)
+	}
+}
+
+// isReferencedPath tells whether the rules reference the attribute at path, or the attributes nested in it.
+func (dictRec *AttrDictionaryRec) isReferencedPath(path string) bool {
+	if _, ok := dictRec.dict[path]; ok {
+		return true
+	}
+	if _, ok := dictRec.dict[path+"[]"]; ok {
+		return true
+	}
+	_, ok := dictRec.dict[path+"{}[]"]
+	return ok
+}
+
+// decodeJSONTokens decodes the whole value starting with the token.
+func decodeJSONTokens(dec *json.Decoder, tok json.Token) (interface{}, error) {
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	var result interface{}
+	var list []interface{}
+	var object map[string]interface{}
+	if delim == '{' {
+		object = make(map[string]interface{})
+		result = object
+	} else {
+		list = []interface{}{}
+	}
+	for dec.More() {
+		next, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if object != nil {
+			key := next.(string)
+			if next, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			if object[key], err = decodeJSONTokens(dec, next); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := decodeJSONTokens(dec, next)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	if object == nil {
+		result = list
+	}
+	_, err := dec.Token()
+	return result, err
+}
+
+// skipJSONValue skips the rest of the value starting with the token.
+func skipJSONValue(dec *json.Decoder, tok json.Token) error {
+	if _, ok := tok.(json.Delim); !ok {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+	return nil
+}
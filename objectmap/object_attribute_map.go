@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"github.com/atlasgurus/rulestone/types"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type AttrDictionaryRec struct {
@@ -56,6 +58,24 @@ func NewObjectAttributeMapper(config MapperConfig) *ObjectAttributeMapper {
 type ObjectAttributeMap struct {
 	DictRec *AttrDictionaryRec
 	Values  []interface{}
+	// Window holds the mapped events of the window when the event is matched as part of one, nil otherwise.
+	Window []*ObjectAttributeMap
+	// MatchedElements collects the indexes of the array elements satisfying the forSome() and count() conditions by
+	// the condition category when requested by the caller, nil otherwise.
+	MatchedElements map[types.Category][]int
+	// EvalCategory is the category being evaluated while MatchedElements is collected.
+	EvalCategory types.Category
+	// Now is the current time for the time relative functions, e.g. daysSince(), when the event is matched at
+	// a fixed time, zero for the wall clock.
+	Now time.Time
+	// Aggregate returns the rolling aggregate, e.g. "avg", of the attribute at path when the event is matched by
+	// a stateful matcher, nil otherwise.
+	Aggregate func(kind string, path string) (float64, bool)
+	// NumAttributeAccesses counts the attribute values read while evaluating the conditions of the event.
+	NumAttributeAccesses uint64
+	// NumIterations counts the array elements visited by forAll(), forSome(), count() and the array functions
+	// while evaluating the conditions of the event.
+	NumIterations uint64
 }
 
 type PathSegment struct {
@@ -212,7 +232,10 @@ func (dictRec *AttrDictionaryRec) AttributePathToAddress(attrPath string) ([]int
 
 			// Register empty root attribute to simplify matching logic
 			// mapIndex = -1 signifies a non leaf attribute that doesn't have a value stored for it.
-			curDictRec.dict[""] = &AttrDictionaryRec{mapIndex: -1}
+			// Do not override the "" attribute if it is registered as the value of a scalar array element.
+			if _, ok := curDictRec.dict[""]; !ok {
+				curDictRec.dict[""] = &AttrDictionaryRec{mapIndex: -1}
+			}
 			parts := strings.Split(attr, ".")
 			for i := range parts[:len(parts)-1] {
 				if strings.HasSuffix(parts[i], "[]") {
@@ -310,6 +333,24 @@ func (dictRec *AttrDictionaryRec) AddressToFullPath(address []int) string {
 	return result
 }
 
+// MatchKeyPath returns the attribute path of the address match key built by AddressMatchKey, with the arrays
+// suffixed by "[]", e.g. "orders[].total".
+func (dictRec *AttrDictionaryRec) MatchKeyPath(key string) string {
+	dr := dictRec
+	var segments []string
+	for _, s := range strings.Split(key, ".") {
+		i, err := strconv.Atoi(s)
+		if err != nil || dr == nil || i < 0 || i >= len(dr.dictIndex) {
+			return key
+		}
+		dr = dr.dictIndex[i]
+		if dr.attribute != "" {
+			segments = append(segments, dr.attribute)
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
 func (dictRec *AttrDictionaryRec) AddressToFullAddress(address []int) []int {
 	if len(dictRec.Address) > 0 {
 		result := make([]int, 0, len(address)+len(dictRec.Address)+1)
@@ -378,7 +419,37 @@ func (attrMap *ObjectAttributeMap) GetAttributeByAddress(attrAddress []int, fram
 
 func (mapper *ObjectAttributeMapper) NewObjectAttributeMap() *ObjectAttributeMap {
 	obj := mapper.objectPool.Get().(*ObjectAttributeMap)
+	mapper.resetObjectAttributeMap(obj)
+
+	// Add the newly created object to the global list
+	//mapper.mu.Lock()
+	mapper.objectList = append(mapper.objectList, obj)
+	//mapper.mu.Unlock()
+
+	return obj
+}
+
+// AcquireObjectAttributeMap returns an attribute map from the pool that is not tracked for FreeObjects.  It can be
+// reused for mapping a sequence of objects with MapObjectInto and must be returned with ReleaseObjectAttributeMap.
+// Unlike the maps allocated by MapObject it may be used concurrently with the other goroutines.
+func (mapper *ObjectAttributeMapper) AcquireObjectAttributeMap() *ObjectAttributeMap {
+	return mapper.objectPool.Get().(*ObjectAttributeMap)
+}
+
+// ReleaseObjectAttributeMap returns the attribute map obtained with AcquireObjectAttributeMap to the pool.
+func (mapper *ObjectAttributeMapper) ReleaseObjectAttributeMap(obj *ObjectAttributeMap) {
+	mapper.objectPool.Put(obj)
+}
+
+func (mapper *ObjectAttributeMapper) resetObjectAttributeMap(obj *ObjectAttributeMap) {
 	obj.DictRec = mapper.RootDictRec
+	obj.Window = nil
+	obj.MatchedElements = nil
+	obj.EvalCategory = 0
+	obj.Now = time.Time{}
+	obj.Aggregate = nil
+	obj.NumAttributeAccesses = 0
+	obj.NumIterations = 0
 	if cap(obj.Values) < mapper.RootDictRec.numAttributes {
 		obj.Values = make([]interface{}, mapper.RootDictRec.numAttributes)
 	} else {
@@ -387,13 +458,6 @@ func (mapper *ObjectAttributeMapper) NewObjectAttributeMap() *ObjectAttributeMap
 			obj.Values = append(obj.Values, nil)
 		}
 	}
-
-	// Add the newly created object to the global list
-	//mapper.mu.Lock()
-	mapper.objectList = append(mapper.objectList, obj)
-	//mapper.mu.Unlock()
-
-	return obj
 }
 
 func (mapper *ObjectAttributeMapper) FreeObjects() {
@@ -412,6 +476,9 @@ func (mapper *ObjectAttributeMapper) buildObjectMap(
 	kind := reflect.ValueOf(v).Kind()
 	switch kind {
 	case reflect.Map:
+		if entriesDictRec, ok := dictRec.dict[path+"{}[]"]; ok {
+			mapper.buildEntriesMap(v.(map[string]interface{}), values, entriesDictRec, attrCallback, address)
+		}
 		_, ok := dictRec.dict[path]
 		if ok {
 			if strings.HasSuffix(path, "[]") {
@@ -430,6 +497,10 @@ func (mapper *ObjectAttributeMapper) buildObjectMap(
 		attrDictRec, ok := dictRec.dict[path+"[]"]
 		if ok {
 			newAddress := append(address, attrDictRec.mapIndex, 0)
+			if len(v.([]interface{})) == 0 && values[attrDictRec.mapIndex] == nil {
+				// Record empty array to distinguish it from the missing one
+				values[attrDictRec.mapIndex] = []interface{}{}
+			}
 			for i, elem := range v.([]interface{}) {
 				newAddress[len(newAddress)-1] = i
 				newValues := make([]interface{}, attrDictRec.numAttributes)
@@ -455,6 +526,29 @@ func (mapper *ObjectAttributeMapper) buildObjectMap(
 	}
 }
 
+// buildEntriesMap maps the entries of the object as the array of {"key": key, "value": value} elements ordered by
+// the key, so that the rules can iterate over the entries of the objects with dynamic keys.  The entries are
+// registered under the object path followed by "{}[]".
+func (mapper *ObjectAttributeMapper) buildEntriesMap(
+	v map[string]interface{}, values []interface{}, dictRec *AttrDictionaryRec, attrCallback func([]int), address []int) {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys))
+	newAddress := append(address, dictRec.mapIndex, 0)
+	for i, key := range keys {
+		newAddress[len(newAddress)-1] = i
+		newValues := make([]interface{}, dictRec.numAttributes)
+		entries = append(entries, newValues)
+		mapper.buildObjectMap("", map[string]interface{}{"key": key, "value": v[key]},
+			newValues, dictRec, attrCallback, newAddress)
+	}
+	values[dictRec.mapIndex] = entries
+	attrCallback(newAddress)
+}
+
 func (mapper *ObjectAttributeMapper) MapObject(v interface{}, attrCallback func([]int)) *ObjectAttributeMap {
 	address := make([]int, 0, 20)
 	result := mapper.NewObjectAttributeMap()
@@ -462,6 +556,15 @@ func (mapper *ObjectAttributeMapper) MapObject(v interface{}, attrCallback func(
 	return result
 }
 
+// MapObjectInto maps the object like MapObject, reusing the attribute map obtained with AcquireObjectAttributeMap
+// and the address buffer instead of allocating new ones.
+func (mapper *ObjectAttributeMapper) MapObjectInto(
+	obj *ObjectAttributeMap, v interface{}, attrCallback func([]int), address []int) *ObjectAttributeMap {
+	mapper.resetObjectAttributeMap(obj)
+	mapper.buildObjectMap("", v, obj.Values, obj.DictRec, attrCallback, address[:0])
+	return obj
+}
+
 func (attrMap *ObjectAttributeMap) GetNumElementsAtAddress(address *AttributeAddress, frames []interface{}) (int, error) {
 	values, err := attrMap.GetAttributeByAddress(address.Address, frames[address.ParentParameterIndex])
 	if err != nil {
@@ -0,0 +1,112 @@
+package objectmap
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MapProtoMessage maps the protobuf message the same way MapObject maps the decoded JSON object.
+// The fields are referenced by their proto names, message fields are mapped as nested objects, repeated fields
+// as arrays and map fields as nested objects keyed by the map keys.  Numbers are mapped to floats the same as
+// JSON numbers, enums to their value names and bytes to strings.  The fields that are not set are undefined,
+// except for the proto3 scalar fields without presence that always have a value.
+func (mapper *ObjectAttributeMapper) MapProtoMessage(
+	msg protoreflect.Message, attrCallback func([]int)) *ObjectAttributeMap {
+	address := make([]int, 0, 20)
+	result := mapper.NewObjectAttributeMap()
+	mapper.buildProtoObjectMap("", msg, result.Values, result.DictRec, attrCallback, address)
+	return result
+}
+
+func (mapper *ObjectAttributeMapper) buildProtoObjectMap(
+	path string, msg protoreflect.Message, values []interface{}, dictRec *AttrDictionaryRec,
+	attrCallback func([]int), address []int) {
+	if _, ok := dictRec.dict[path]; !ok {
+		return
+	}
+	// Add separator only if Path is not empty
+	if path != "" {
+		path += "."
+	}
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasPresence() && !msg.Has(fd) {
+			continue
+		}
+		mapper.buildProtoFieldMap(path+string(fd.Name()), fd, msg.Get(fd), values, dictRec, attrCallback, address)
+	}
+}
+
+func (mapper *ObjectAttributeMapper) buildProtoFieldMap(
+	path string, fd protoreflect.FieldDescriptor, v protoreflect.Value, values []interface{},
+	dictRec *AttrDictionaryRec, attrCallback func([]int), address []int) {
+	switch {
+	case fd.IsList():
+		attrDictRec, ok := dictRec.dict[path+"[]"]
+		if ok {
+			list := v.List()
+			elements := make([]interface{}, list.Len())
+			values[attrDictRec.mapIndex] = elements
+			newAddress := append(address, attrDictRec.mapIndex, 0)
+			for i := range elements {
+				newAddress[len(newAddress)-1] = i
+				newValues := make([]interface{}, attrDictRec.numAttributes)
+				elements[i] = newValues
+				mapper.buildProtoValueMap("", fd, list.Get(i), newValues, attrDictRec, attrCallback, newAddress)
+			}
+			attrCallback(newAddress)
+		}
+	case fd.IsMap():
+		if _, ok := dictRec.dict[path]; ok {
+			v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				mapper.buildProtoValueMap(
+					path+"."+key.String(), fd.MapValue(), value, values, dictRec, attrCallback, address)
+				return true
+			})
+		}
+	default:
+		mapper.buildProtoValueMap(path, fd, v, values, dictRec, attrCallback, address)
+	}
+}
+
+func (mapper *ObjectAttributeMapper) buildProtoValueMap(
+	path string, fd protoreflect.FieldDescriptor, v protoreflect.Value, values []interface{},
+	dictRec *AttrDictionaryRec, attrCallback func([]int), address []int) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		mapper.buildProtoObjectMap(path, v.Message(), values, dictRec, attrCallback, address)
+	default:
+		attrDictRec, ok := dictRec.dict[path]
+		if ok && attrDictRec.mapIndex != -1 {
+			newAddress := append(address, attrDictRec.mapIndex)
+			values[attrDictRec.mapIndex] = mapper.Config.MapScalar(protoScalar(fd, v))
+			attrCallback(newAddress)
+		}
+	}
+}
+
+// protoScalar converts the scalar proto value to the same Go types as the ones produced by decoding JSON.
+func protoScalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return float64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	default:
+		return nil
+	}
+}
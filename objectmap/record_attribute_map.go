@@ -0,0 +1,37 @@
+package objectmap
+
+import (
+	"math"
+	"strconv"
+)
+
+// MapRecord maps the flat record, e.g. the CSV row, with each column as the top level attribute named by the
+// header.  The cells parsing as finite numbers are mapped as numbers, the other ones as strings.  The empty cells
+// and the columns missing from the row are undefined.
+func (mapper *ObjectAttributeMapper) MapRecord(header []string, row []string, attrCallback func([]int)) *ObjectAttributeMap {
+	address := make([]int, 0, 1)
+	result := mapper.NewObjectAttributeMap()
+	dictRec := result.DictRec
+	for i, column := range header {
+		if i >= len(row) {
+			break
+		}
+		if row[i] == "" {
+			continue
+		}
+		attrDictRec, ok := dictRec.dict[column]
+		if ok && attrDictRec.mapIndex != -1 {
+			result.Values[attrDictRec.mapIndex] = mapper.Config.MapScalar(recordScalar(row[i]))
+			attrCallback(append(address, attrDictRec.mapIndex))
+		}
+	}
+	return result
+}
+
+// recordScalar converts the cell to the number when it parses as one.
+func recordScalar(cell string) interface{} {
+	if f, err := strconv.ParseFloat(cell, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return cell
+}
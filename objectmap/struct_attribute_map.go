@@ -0,0 +1,230 @@
+package objectmap
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// structField is the exported field of a struct type with the attribute name given by its json tag.
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields caches the attribute fields by struct type.
+var structFields sync.Map
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// MapStruct maps the Go value, typically a pointer to a struct, the same way MapObject maps the object decoded from
+// its JSON encoding, without the JSON round-trip.  The struct fields are named by their json tags the same as by
+// encoding/json, including the fields promoted from the embedded structs, and the fields tagged "-" are skipped.
+// Nested structs and maps are mapped as nested objects, slices and arrays as arrays, numbers as floats the same
+// as JSON numbers, time.Time as the RFC 3339 string and []byte as the string.  Nil pointers, interfaces, maps and
+// slices are mapped as JSON null.
+func (mapper *ObjectAttributeMapper) MapStruct(v interface{}, attrCallback func([]int)) *ObjectAttributeMap {
+	address := make([]int, 0, 20)
+	result := mapper.NewObjectAttributeMap()
+	mapper.buildStructMap("", reflect.ValueOf(v), result.Values, result.DictRec, attrCallback, address)
+	return result
+}
+
+func (mapper *ObjectAttributeMapper) buildStructMap(
+	path string, v reflect.Value, values []interface{}, dictRec *AttrDictionaryRec,
+	attrCallback func([]int), address []int) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			mapper.buildStructScalarMap(path, nil, values, dictRec, attrCallback, address)
+			return
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type() == timeType:
+		mapper.buildStructScalarMap(
+			path, v.Interface().(time.Time).Format(time.RFC3339Nano), values, dictRec, attrCallback, address)
+	case v.Kind() == reflect.Struct:
+		if _, ok := dictRec.dict[path]; !ok {
+			return
+		}
+		prefix := path
+		if prefix != "" {
+			prefix += "."
+		}
+		for _, field := range typeStructFields(v.Type()) {
+			fieldValue, ok := fieldByIndex(v, field.index)
+			if ok {
+				mapper.buildStructMap(prefix+field.name, fieldValue, values, dictRec, attrCallback, address)
+			}
+		}
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			mapper.buildStructScalarMap(path, nil, values, dictRec, attrCallback, address)
+			return
+		}
+		if entriesDictRec, ok := dictRec.dict[path+"{}[]"]; ok {
+			mapper.buildStructEntriesMap(v, values, entriesDictRec, attrCallback, address)
+		}
+		if _, ok := dictRec.dict[path]; ok {
+			prefix := path
+			if prefix != "" {
+				prefix += "."
+			}
+			iter := v.MapRange()
+			for iter.Next() {
+				mapper.buildStructMap(prefix+mapKey(iter.Key()), iter.Value(), values, dictRec, attrCallback, address)
+			}
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		mapper.buildStructScalarMap(path, string(v.Bytes()), values, dictRec, attrCallback, address)
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			mapper.buildStructScalarMap(path, nil, values, dictRec, attrCallback, address)
+			return
+		}
+		attrDictRec, ok := dictRec.dict[path+"[]"]
+		if ok {
+			elements := make([]interface{}, v.Len())
+			values[attrDictRec.mapIndex] = elements
+			newAddress := append(address, attrDictRec.mapIndex, 0)
+			for i := range elements {
+				newAddress[len(newAddress)-1] = i
+				newValues := make([]interface{}, attrDictRec.numAttributes)
+				elements[i] = newValues
+				mapper.buildStructMap("", v.Index(i), newValues, attrDictRec, attrCallback, newAddress)
+			}
+			attrCallback(newAddress)
+		}
+	default:
+		mapper.buildStructScalarMap(path, structScalar(v), values, dictRec, attrCallback, address)
+	}
+}
+
+func (mapper *ObjectAttributeMapper) buildStructScalarMap(
+	path string, v interface{}, values []interface{}, dictRec *AttrDictionaryRec,
+	attrCallback func([]int), address []int) {
+	attrDictRec, ok := dictRec.dict[path]
+	if ok && attrDictRec.mapIndex != -1 {
+		newAddress := append(address, attrDictRec.mapIndex)
+		values[attrDictRec.mapIndex] = mapper.Config.MapScalar(v)
+		attrCallback(newAddress)
+	}
+}
+
+// buildStructEntriesMap maps the entries of the map value the same way buildEntriesMap maps the entries of the
+// object.
+func (mapper *ObjectAttributeMapper) buildStructEntriesMap(
+	v reflect.Value, values []interface{}, dictRec *AttrDictionaryRec, attrCallback func([]int), address []int) {
+	keys := make([]string, 0, v.Len())
+	keyValues := make(map[string]reflect.Value, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := mapKey(iter.Key())
+		keys = append(keys, key)
+		keyValues[key] = iter.Value()
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys))
+	newAddress := append(address, dictRec.mapIndex, 0)
+	_, referenced := dictRec.dict[""]
+	for i, key := range keys {
+		newAddress[len(newAddress)-1] = i
+		newValues := make([]interface{}, dictRec.numAttributes)
+		entries = append(entries, newValues)
+		if referenced {
+			mapper.buildStructScalarMap("key", key, newValues, dictRec, attrCallback, newAddress)
+			mapper.buildStructMap("value", keyValues[key], newValues, dictRec, attrCallback, newAddress)
+		}
+	}
+	values[dictRec.mapIndex] = entries
+	attrCallback(newAddress)
+}
+
+// typeStructFields returns the attribute fields of the struct type following the encoding/json naming rules.
+func typeStructFields(t reflect.Type) []structField {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]structField)
+	}
+	var result []structField
+	names := make(map[string]bool)
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			fieldIndex := append(append([]int(nil), index...), i)
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					collect(ft, fieldIndex)
+					continue
+				}
+			}
+			if f.PkgPath != "" {
+				// Unexported
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			// The shallower fields hide the promoted ones of the same name
+			if !names[name] {
+				names[name] = true
+				result = append(result, structField{name: name, index: fieldIndex})
+			}
+		}
+	}
+	collect(t, nil)
+	structFields.Store(t, result)
+	return result
+}
+
+// fieldByIndex returns the nested field, false if it is promoted through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, fieldIndex := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIndex)
+	}
+	return v, true
+}
+
+func mapKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	return fmt.Sprint(key.Interface())
+}
+
+// structScalar converts the scalar Go value to the same Go types as the ones produced by decoding JSON.
+func structScalar(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	default:
+		return nil
+	}
+}
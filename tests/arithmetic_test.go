@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/atlasgurus/rulestone/engine"
+)
+
+func TestArithmeticWithMissingField(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`amount > avgAmount * 3`,
+		`!(amount > avgAmount * 3)`,
+		`amount > avgAmount * 3 || amount > 100`,
+		`amount + avgAmount * 3 > 0`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"amount": 10, "avgAmount": 2}`, 0, 2, 3)
+	expectMatches(t, genFilter, `{"amount": 5, "avgAmount": 2}`, 1, 3)
+	expectMatches(t, genFilter, `{"amount": 200, "avgAmount": 100}`, 1, 2, 3)
+
+	// Missing avgAmount must not be treated as 0, nor match the negated comparison
+	expectMatches(t, genFilter, `{"amount": 10}`)
+	expectMatches(t, genFilter, `{"amount": 200}`, 2)
+	expectMatches(t, genFilter, `{"amount": 10, "avgAmount": null}`)
+	expectMatches(t, genFilter, `{"avgAmount": 2}`)
+}
+
+func TestArithmeticNullResultFailsEveryOperator(t *testing.T) {
+	tests := []struct {
+		expr           string
+		matchesMissing bool
+		matchesDefined bool
+	}{
+		{`amount > avgAmount * 3`, false, false},
+		{`amount < avgAmount * 3`, false, true},
+		{`amount <= avgAmount * 3`, false, true},
+		{`amount >= avgAmount * 3`, false, false},
+		{`amount == avgAmount * 3`, false, false},
+		{`amount != avgAmount * 3`, false, true},
+		{`avgAmount * 3 != amount`, false, true},
+		{`!(amount > avgAmount * 3)`, false, true},
+		{`!(amount == avgAmount * 3)`, false, true},
+		{`!(amount != avgAmount * 3)`, false, false},
+		{`forSome("items", "item", item.amount <= avgAmount * 3)`, false, true},
+		{`forSome("items", "item", !(item.amount > avgAmount * 3))`, false, true},
+		// A plain comparison against the missing field keeps its negation semantics
+		{`amount != avgAmount`, true, true},
+		{`!(amount > avgAmount)`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			repo, genFilter := newRuleEngineFromExpressions(t, tt.expr)
+			expectNoErrors(t, repo)
+			expectMatchesIf(t, genFilter, `{"amount": 10, "items": [{"amount": 10}]}`, tt.matchesMissing)
+			expectMatchesIf(t, genFilter, `{"amount": 10, "avgAmount": 4, "items": [{"amount": 10}]}`, tt.matchesDefined)
+		})
+	}
+}
+
+func expectMatchesIf(t *testing.T, genFilter *engine.RuleEngine, event string, match bool) {
+	t.Helper()
+	if match {
+		expectMatches(t, genFilter, event, 0)
+	} else {
+		expectMatches(t, genFilter, event)
+	}
+}
+
+func TestModulo(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`id % 2 == 0`,
+		`id % 2 == 1`,
+		`x % 3 + 1 == 0`,
+		`x % 0.5 == 0.25`,
+		`x % y > 0`,
+		`!(x % y > 0)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"id": 10}`, 0)
+	expectMatches(t, genFilter, `{"id": 7}`, 1)
+	expectMatches(t, genFilter, `{"id": 7.5}`)
+
+	// The remainder has the sign of the dividend
+	expectMatches(t, genFilter, `{"x": -7}`, 2)
+	expectMatches(t, genFilter, `{"x": 7.75}`, 3)
+	expectMatches(t, genFilter, `{"x": 7, "y": -3}`, 4)
+	expectMatches(t, genFilter, `{"x": -7, "y": 3}`, 2, 5)
+
+	// Modulo by zero does not match either way
+	expectMatches(t, genFilter, `{"x": 7, "y": 0}`)
+	expectMatches(t, genFilter, `{"id": null}`)
+}
+
+func TestFractionalConstantsDoNotCollide(t *testing.T) {
+	// The expressions differing only in the fractional part of a constant are distinct conditions
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a * 0.3 > 1`,
+		`a * 0.8 > 1`,
+		`a == 2.25`,
+		`a == 2.75`,
+		`b * 1.2 > 2`,
+		`b * 1.5 > 2`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"a": 2}`, 1)
+	expectMatches(t, genFilter, `{"a": 2.75}`, 1, 3)
+	expectMatches(t, genFilter, `{"b": 1.5}`, 5)
+}
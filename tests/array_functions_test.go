@@ -0,0 +1,517 @@
+package tests
+
+import (
+	"testing"
+)
+
+func TestRegexpMatchAny(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`regexpMatchAny("^urgent", "tags", "tag")`,
+		`regexpMatchAny("^\\d+$", "items", "item")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"tags": ["low", "urgent-fix"]}`, 0)
+	expectMatches(t, genFilter, `{"tags": ["low", "not-urgent"]}`)
+	expectMatches(t, genFilter, `{"tags": []}`)
+	expectMatches(t, genFilter, `{"other": 1}`)
+	expectMatches(t, genFilter, `{"tags": [null, "urgent"], "items": ["a", 42]}`, 0, 1)
+}
+
+func TestRegexpMatchAnyNested(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`forSome("orders", "order", regexpMatchAny("^gift", "order.labels", "label"))`,
+		`!regexpMatchAny("^urgent", "tags", "tag")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"orders": [{"labels": ["a"]}, {"labels": ["b", "gift-wrap"]}], "tags": ["x"]}`, 0, 1)
+	expectMatches(t, genFilter, `{"orders": [{"labels": ["a"]}], "tags": ["urgent"]}`)
+}
+
+func TestRegexpMatchAnyErrors(t *testing.T) {
+	expectRuleEngineError(t, `regexpMatchAny("[", "tags", "tag")`)
+	expectRuleEngineError(t, `regexpMatchAny(pattern, "tags", "tag")`)
+	expectRuleEngineError(t, `regexpMatchAny("^a", "tags")`)
+}
+
+func TestAllDistinct(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`allDistinct("orders", "order", order.id)`,
+		`!allDistinct("orders", "order", order.id)`,
+		`allDistinct("orders", "order", order.id + 0)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"orders": [{"id": 1}, {"id": 2}, {"id": 3}]}`, 0, 2)
+	expectMatches(t, genFilter, `{"orders": [{"id": 1}, {"id": 2}, {"id": 1}]}`, 1)
+	expectMatches(t, genFilter, `{"orders": [{"id": 0.3}, {"id": 0.8}]}`, 0, 2)
+	expectMatches(t, genFilter, `{"orders": [{"id": "a"}, {"id": "b"}, {"id": "a"}]}`, 1)
+	expectMatches(t, genFilter, `{"orders": []}`, 0, 2)
+
+	// Elements with undefined keys are skipped
+	expectMatches(t, genFilter, `{"orders": [{"id": 1}, {"name": "x"}, {"name": "y"}, {"id": null}, {"id": null}]}`, 0, 2)
+	expectMatches(t, genFilter, `{"orders": [{"id": 1}, {"name": "x"}, {"id": 1}]}`, 1)
+}
+
+func TestForAllForSomeEmptyArray(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`forAll("children", "child", child.age > 10)`,
+		`forSome("children", "child", child.age > 10)`,
+		`!forAll("children", "child", child.age > 10)`,
+		`allDistinct("children", "child", child.age)`)
+	expectNoErrors(t, repo)
+
+	// An empty array is recorded for allDistinct(), but forAll() stays false as for a missing array
+	expectMatches(t, genFilter, `{"children": []}`, 2, 3)
+	expectMatches(t, genFilter, `{"name": "x"}`, 2)
+	expectMatches(t, genFilter, `{"children": [{"age": 11}]}`, 0, 1, 3)
+	expectMatches(t, genFilter, `{"children": [{"age": 11}, {"age": 9}]}`, 1, 2, 3)
+}
+
+func TestArrayMaxMin(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`value > arrayMax("history", "h", h)`,
+		`value < arrayMin("history", "h", h)`,
+		`arrayMax("orders", "o", o.amount) >= 100`,
+		`!(value > arrayMax("history", "h", h))`)
+	expectNoErrors(t, repo)
+
+	// New maximum
+	expectMatches(t, genFilter, `{"value": 10, "history": [3, 9.5, 7]}`, 0)
+	// Not a new maximum
+	expectMatches(t, genFilter, `{"value": 9, "history": [3, 9.5, 7]}`, 3)
+	expectMatches(t, genFilter, `{"value": 9.5, "history": [3, 9.5, 7]}`, 3)
+	// New minimum
+	expectMatches(t, genFilter, `{"value": 1, "history": [3, null, 7]}`, 1, 3)
+
+	// Empty or missing history has no maximum, which only matches the negated comparison the same as comparing
+	// with any other undefined value
+	expectMatches(t, genFilter, `{"value": 10, "history": []}`, 3)
+	expectMatches(t, genFilter, `{"value": 10}`, 3)
+
+	// Aggregate over array of objects
+	expectMatches(t, genFilter, `{"orders": [{"amount": 20}, {"amount": 120}, {"id": 1}]}`, 2)
+	expectMatches(t, genFilter, `{"orders": [{"amount": 20}]}`)
+}
+
+func TestArraySpread(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`arraySpread("items", "i", i.price) > 100`,
+		`arraySpread("items", "i", i.price) == 0`,
+		`arraySpread("items", "i", i.price) < 100`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"items": [{"price": 20}, {"price": 150.5}, {"price": 80}]}`, 0)
+	expectMatches(t, genFilter, `{"items": [{"price": -20}, {"price": 30}]}`, 2)
+	// Undefined prices are skipped
+	expectMatches(t, genFilter, `{"items": [{"price": 20}, {"sku": "x"}, {"price": null}, {"price": 200}]}`, 0)
+	// A single element has no spread
+	expectMatches(t, genFilter, `{"items": [{"price": 42}]}`, 1, 2)
+	expectMatches(t, genFilter, `{"items": [{"price": 42}, {"sku": "x"}]}`, 1, 2)
+	// Empty, missing or all undefined arrays have undefined spread
+	expectMatches(t, genFilter, `{"items": []}`)
+	expectMatches(t, genFilter, `{"items": [{"sku": "x"}]}`)
+	expectMatches(t, genFilter, `{"other": 1}`)
+
+	expectRuleEngineError(t, `arraySpread("items", "i") > 1`)
+}
+
+func TestSumAvg(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`avg("order.items[].price") > 50`,
+		`sum("order.items[].price") > 100`,
+		`sum("scores") == 6`,
+		`avg("scores") == 2`,
+		`!(avg("order.items[].price") > 50)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"order": {"items": [{"price": 40}, {"price": 80}]}}`, 0, 1)
+	expectMatches(t, genFilter, `{"order": {"items": [{"price": 10}, {"price": 20}, {"price": 80}]}}`, 1, 4)
+	// Undefined elements are skipped
+	expectMatches(t, genFilter, `{"order": {"items": [{"price": 60}, {"sku": "a"}, {"price": null}]}}`, 0)
+	expectMatches(t, genFilter, `{"scores": [1, 2, null, 3]}`, 2, 3)
+
+	// Empty or missing array is undefined, which only matches the negated comparison
+	expectMatches(t, genFilter, `{"order": {"items": []}}`, 4)
+	expectMatches(t, genFilter, `{"order": {"items": [{"sku": "a"}]}}`, 4)
+}
+
+func TestSumChecksum(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`checksum == sum("items", "i", i.amount)`,
+		`!(checksum == sum("items", "i", i.amount))`,
+		`total == sum("items[].amount")`,
+		`total == sum("items", "i", i.amount * i.qty)`,
+		`avg("items", "i", i.amount) == 0.2`)
+	expectNoErrors(t, repo)
+
+	// The compensated summation does not accumulate the rounding errors of the binary floats
+	expectMatches(t, genFilter,
+		`{"checksum": 0.6, "items": [{"amount": 0.1}, {"amount": 0.2}, {"amount": 0.3}]}`, 0)
+	expectMatches(t, genFilter, `{"items": [{"amount": 0.1}, {"amount": 0.3}]}`, 1, 4)
+	expectMatches(t, genFilter,
+		`{"checksum": 1045.93, "total": 1045.93, "items": [{"amount": 999.99, "qty": 1}, {"amount": 45.94}]}`, 0, 2)
+	expectMatches(t, genFilter,
+		`{"total": 30.75, "items": [{"amount": 10.25, "qty": 2}, {"amount": 10.25, "qty": 1}]}`, 1, 3)
+	// Off by a cent
+	expectMatches(t, genFilter,
+		`{"checksum": 0.61, "items": [{"amount": 0.1}, {"amount": 0.2}, {"amount": 0.3}]}`, 1)
+	expectMatches(t, genFilter,
+		`{"checksum": 1045.92, "total": 1045.94, "items": [{"amount": 999.99}, {"amount": 45.94}]}`, 1)
+	// Either attribute missing leaves the comparison undefined
+	expectMatches(t, genFilter, `{"items": [{"amount": 0.1}]}`, 1)
+	expectMatches(t, genFilter, `{"checksum": 0.1}`, 1)
+}
+
+func TestSumAvgPrecision(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`x == sum("items[].x")`,
+		`x == avg("items[].x")`,
+		`x == sumWhere("items", "i", i.x, i.x > 0)`,
+		`x == sum("items", "i", i.x)`)
+	expectNoErrors(t, repo)
+
+	// The results keep all the significant digits of the floats
+	expectMatches(t, genFilter, `{"x": 1700000000000123, "items": [{"x": 1700000000000123}]}`, 0, 1, 2, 3)
+	expectMatches(t, genFilter, `{"x": 0.1234567890123456, "items": [{"x": 0.1234567890123456}]}`, 0, 1, 2, 3)
+	expectMatches(t, genFilter, `{"x": 0.12345678901234567, "items": [{"x": 0.12345678901234567}]}`, 0, 1, 2, 3)
+	expectMatches(t, genFilter,
+		`{"x": 1700000000000123, "items": [{"x": 1700000000000123}, {"x": 1700000000000123}]}`, 1)
+}
+
+func TestSumAvgErrors(t *testing.T) {
+	expectRuleEngineError(t, `sum() > 1`)
+	expectRuleEngineError(t, `sum("a[].b", "c") > 1`)
+	expectRuleEngineError(t, `sum(a, "c", c.d) > 1`)
+	expectRuleEngineError(t, `avg(path) > 1`)
+	expectRuleEngineError(t, `avg("a[]b") > 1`)
+	expectRuleEngineError(t, `avg("a[].b[].c") > 1`)
+}
+
+func TestSumWhere(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`sumWhere("orders", "o", o.amount, o.status == "failed") > 500`,
+		`sumWhere("orders", "o", o.amount, o.status == "failed") == 0`,
+		`sumWhere("orders", "o", o.amount * o.qty, o.qty > 1) == 60`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter,
+		`{"orders": [{"status": "failed", "amount": 300}, {"status": "ok", "amount": 900}, {"status": "failed", "amount": 250}]}`, 0)
+	expectMatches(t, genFilter,
+		`{"orders": [{"status": "failed", "amount": 300}, {"status": "ok", "amount": 900}]}`)
+	// Undefined amounts are skipped
+	expectMatches(t, genFilter,
+		`{"orders": [{"status": "failed", "amount": 600}, {"status": "failed"}, {"status": "failed", "amount": null}]}`, 0)
+	expectMatches(t, genFilter,
+		`{"orders": [{"amount": 10, "qty": 3}, {"amount": 15, "qty": 2}, {"amount": 99, "qty": 1}]}`, 1, 2)
+	// No matching or empty array sums to 0, while the missing array is undefined
+	expectMatches(t, genFilter, `{"orders": [{"status": "ok", "amount": 900}]}`, 1)
+	expectMatches(t, genFilter, `{"orders": []}`, 1)
+	expectMatches(t, genFilter, `{"orders": null}`)
+
+	expectRuleEngineError(t, `sumWhere("orders", "o", o.amount) > 1`)
+}
+
+func TestCount(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`count("orders", "o", o.total > 100) >= 3`,
+		`count("orders", "o", o.status == "void") == 0`,
+		`count("orders", "o", o.total > 100) < 3`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"orders": [{"total": 150}, {"total": 120}, {"total": 101}]}`, 0, 1)
+	expectMatches(t, genFilter, `{"orders": [{"total": 150}, {"total": 50}]}`, 1, 2)
+	expectMatches(t, genFilter, `{"orders": [{"total": 150, "status": "void"}, {"total": 200}]}`, 2)
+	// Empty array has no matching elements, while the missing array is undefined
+	expectMatches(t, genFilter, `{"orders": []}`, 1, 2)
+	expectMatches(t, genFilter, `{"orders": null}`)
+}
+
+func TestCountNested(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`count("orders", "o", o.total > 100 && forSome("o.items", "i", i.qty > 5)) == 1`,
+		`count("orders", "o", count("o.items", "i", i.qty > 5) >= 2) > 0`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter,
+		`{"orders": [{"total": 150, "items": [{"qty": 1}, {"qty": 6}]}, {"total": 50, "items": [{"qty": 6}]}]}`, 0)
+	expectMatches(t, genFilter,
+		`{"orders": [{"total": 150, "items": [{"qty": 7}, {"qty": 6}]}, {"total": 250, "items": [{"qty": 9}]}]}`, 1)
+	expectMatches(t, genFilter, `{"orders": [{"total": 150, "items": []}]}`)
+}
+
+func TestCountTimesRate(t *testing.T) {
+	// The count composes with the arithmetic over the event attributes
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`count("orders", "o", o.failed) * penaltyRate > 10`,
+		`count("orders", "o", o.failed) * penaltyRate == 7.5`,
+		`penaltyRate * count("orders", "o", o.failed) + fee <= 5`)
+	expectNoErrors(t, repo)
+
+	failedOrders := `[{"failed": true}, {"failed": false}, {"failed": true}, {"failed": true}]`
+	expectMatches(t, genFilter, `{"orders": `+failedOrders+`, "penaltyRate": 4}`, 0)
+	expectMatches(t, genFilter, `{"orders": `+failedOrders+`, "penaltyRate": 4, "fee": -10}`, 0, 2)
+	expectMatches(t, genFilter, `{"orders": `+failedOrders+`, "penaltyRate": 2.5, "fee": 1}`, 1)
+	expectMatches(t, genFilter, `{"orders": [], "penaltyRate": 4, "fee": 5}`, 2)
+	// The count is not multiplied by a missing rate
+	expectMatches(t, genFilter, `{"orders": `+failedOrders+`, "fee": 1}`)
+}
+
+func TestCountErrors(t *testing.T) {
+	expectRuleEngineError(t, `count("orders", "o") > 1`)
+	expectRuleEngineError(t, `count(orders, "o", o.total > 1) > 1`)
+}
+
+func TestLength(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`length(name) > 3`,
+		`length(items) > 1`,
+		`length(name) == 3`,
+		`forSome("orders", "o", length(o.items) == 1)`,
+		`length(trim(code)) == 2`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"name": "Frank"}`, 0)
+	expectMatches(t, genFilter, `{"name": "Tom"}`, 2)
+	expectMatches(t, genFilter, `{"name": "Zoë"}`, 2)
+	expectMatches(t, genFilter, `{"items": [1, 2]}`, 1)
+	expectMatches(t, genFilter, `{"items": [{"a": 1}]}`)
+	expectMatches(t, genFilter, `{"items": []}`)
+	expectMatches(t, genFilter, `{"name": ["a", "b", "c"]}`, 2)
+	expectMatches(t, genFilter, `{"orders": [{"items": [1, 2]}, {"items": ["x"]}]}`, 3)
+	expectMatches(t, genFilter, `{"code": " ab "}`, 4)
+
+	// Undefined propagates
+	expectMatches(t, genFilter, `{"name": null, "items": null}`)
+	expectMatches(t, genFilter, `{"other": 1}`)
+}
+
+func TestLengthErrors(t *testing.T) {
+	expectRuleEngineError(t, `length() > 1`)
+	expectRuleEngineError(t, `length(a, b) > 1`)
+}
+
+func TestIsEmpty(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`isEmpty(name)`,
+		`isNotEmpty(name)`,
+		`isEmpty(tags)`,
+		`isNotEmpty(tags)`,
+		`forSome("orders", "o", isEmpty(o.note))`,
+		`!isEmpty(name)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"name": "Frank", "tags": ["a"]}`, 1, 3, 5)
+	// Empty string
+	expectMatches(t, genFilter, `{"name": "", "tags": [""]}`, 0, 3)
+	// Explicit null
+	expectMatches(t, genFilter, `{"name": null, "tags": null}`, 0, 2)
+	// Empty array
+	expectMatches(t, genFilter, `{"name": [], "tags": []}`, 0, 2)
+	// Missing field
+	expectMatches(t, genFilter, `{"other": 1}`, 0, 2)
+	expectMatches(t, genFilter, `{"name": 0}`, 1, 2, 5)
+
+	// Within an element scope the missing attribute is empty too
+	expectMatches(t, genFilter, `{"name": "a", "orders": [{"note": "x"}, {}]}`, 1, 2, 4, 5)
+	expectMatches(t, genFilter, `{"name": "a", "orders": [{"note": "x"}, {"note": null}]}`, 1, 2, 4, 5)
+	expectMatches(t, genFilter, `{"name": "a", "orders": [{"note": "x"}]}`, 1, 2, 5)
+
+	expectRuleEngineError(t, `isEmpty()`)
+	expectRuleEngineError(t, `isNotEmpty(a, b)`)
+}
+
+func TestIsNullIsUndefined(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`isNull(email)`,
+		`isUndefined(email)`,
+		`!isNull(email)`,
+		`!isUndefined(email)`,
+		`forSome("orders", "o", isNull(o.note))`,
+		`forSome("orders", "o", isUndefined(o.note))`,
+		`isUndefined(user.name)`,
+		`hasValue(email)`)
+	expectNoErrors(t, repo)
+
+	// Present with a value
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": {"name": "a"}}`, 2, 3, 7)
+	expectMatches(t, genFilter, `{"email": "", "user": {"name": "a"}}`, 2, 3, 7)
+	// Present but null
+	expectMatches(t, genFilter, `{"email": null, "user": {"name": "a"}}`, 0, 3)
+	// Missing
+	expectMatches(t, genFilter, `{"user": {"name": "a"}}`, 1, 2)
+	// The nested attribute of a missing or null object is missing
+	expectMatches(t, genFilter, `{"email": "a@b.c"}`, 2, 3, 6, 7)
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": null}`, 2, 3, 6, 7)
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": {"name": null}}`, 2, 3, 7)
+
+	// The array elements
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": {}, "orders": [{"note": null}]}`, 2, 3, 4, 6, 7)
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": {}, "orders": [{"note": "x"}, {}]}`, 2, 3, 5, 6, 7)
+	expectMatches(t, genFilter, `{"email": "a@b.c", "user": {}, "orders": [{"note": "x"}]}`, 2, 3, 6, 7)
+
+	expectRuleEngineError(t, `isNull()`)
+	expectRuleEngineError(t, `isNull(lower(email))`)
+	expectRuleEngineError(t, `isUndefined(a, b)`)
+}
+
+func TestIndexOfFirst(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`indexOfFirst("steps", "step", step.status == "error") == 0`,
+		`indexOfFirst("steps", "step", step.status == "error") == 2`,
+		`indexOfFirst("steps", "step", step.status == "error") < 0`,
+		`indexOfFirst("codes", "c", c > 400) == 1`)
+	expectNoErrors(t, repo)
+
+	// At the start
+	expectMatches(t, genFilter, `{"steps": [{"status": "error"}, {"status": "ok"}, {"status": "error"}]}`, 0)
+	// In the middle
+	expectMatches(t, genFilter, `{"codes": [200, 404, 500, 200]}`, 3)
+	// At the end
+	expectMatches(t, genFilter, `{"steps": [{"status": "ok"}, {}, {"status": "error"}]}`, 1)
+	// No match
+	expectMatches(t, genFilter, `{"steps": [{"status": "ok"}, {"status": "ok"}]}`, 2)
+	expectMatches(t, genFilter, `{"steps": []}`, 2)
+
+	expectMatches(t, genFilter, `{"other": []}`)
+}
+
+func TestIndexOfFirstErrors(t *testing.T) {
+	expectRuleEngineError(t, `indexOfFirst("steps", "step") == 0`)
+	expectRuleEngineError(t, `indexOfFirst(steps, "step", step.ok) == 0`)
+}
+
+func TestLongestRun(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`longestRun("attempts", "a", a.status == "failed") >= 3`,
+		`longestRun("attempts", "a", a.status == "failed") == 1`,
+		`longestRun("attempts", "a", a.status == "failed") == 0`,
+		`longestRun("codes", "c", c >= 500) == 2`)
+	expectNoErrors(t, repo)
+
+	// A long run
+	expectMatches(t, genFilter, `{"attempts": [{"status": "ok"}, {"status": "failed"}, {"status": "failed"},
+		{"status": "failed"}, {"status": "failed"}, {"status": "ok"}]}`, 0)
+	// Scattered matches, including the elements with undefined condition breaking the run
+	expectMatches(t, genFilter, `{"attempts": [{"status": "failed"}, {"status": "ok"}, {"status": "failed"}, {},
+		{"status": "failed"}]}`, 1)
+	expectMatches(t, genFilter, `{"codes": [500, 200, 503, 502, 200, 500]}`, 3)
+	// No matches
+	expectMatches(t, genFilter, `{"attempts": [{"status": "ok"}, {"status": "ok"}]}`, 2)
+	expectMatches(t, genFilter, `{"attempts": []}`, 2)
+
+	expectMatches(t, genFilter, `{"other": []}`)
+}
+
+func TestLongestRunErrors(t *testing.T) {
+	expectRuleEngineError(t, `longestRun("attempts", "a") > 1`)
+	expectRuleEngineError(t, `longestRun(attempts, "a", a.failed) > 1`)
+}
+
+func TestIncreasesByAtLeast(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`increasesByAtLeast("readings", "r", r.value, 1)`,
+		`!increasesByAtLeast("readings", "r", r.value, 1)`,
+		`increasesByAtLeast("readings", "r", r.value, 0)`,
+		`increasesByAtLeast("levels", "l", l, 0.5) && n == 1`)
+	expectNoErrors(t, repo)
+
+	// Satisfying
+	expectMatches(t, genFilter, `{"readings": [{"value": 1}, {"value": 2}, {"value": 5}]}`, 0, 2)
+	// The negated condition is true when the array is missing
+	expectMatches(t, genFilter, `{"levels": [1, 1.5, 2.25], "n": 1}`, 1, 3)
+	// Violating
+	expectMatches(t, genFilter, `{"readings": [{"value": 1}, {"value": 2.5}, {"value": 3}]}`, 1, 2)
+	expectMatches(t, genFilter, `{"readings": [{"value": 3}, {"value": 2}]}`, 1)
+	expectMatches(t, genFilter, `{"levels": [1, 1.4], "n": 1}`, 1)
+	// Flat
+	expectMatches(t, genFilter, `{"readings": [{"value": 2}, {"value": 2}, {"value": 2}]}`, 1, 2)
+	// Empty and single element
+	expectMatches(t, genFilter, `{"readings": []}`, 0, 2)
+	expectMatches(t, genFilter, `{"readings": [{"value": 7}]}`, 0, 2)
+	// Undefined
+	expectMatches(t, genFilter, `{"readings": [{"value": 1}, {}, {"value": 3}]}`, 1)
+	expectMatches(t, genFilter, `{"other": []}`, 1)
+}
+
+func TestIncreasesByAtLeastErrors(t *testing.T) {
+	expectRuleEngineError(t, `increasesByAtLeast("readings", "r", r.value)`)
+	expectRuleEngineError(t, `increasesByAtLeast("readings", "r", r.value, step)`)
+	expectRuleEngineError(t, `increasesByAtLeast("readings", "r", r.value, "one")`)
+}
+
+func TestContainsSubsequence(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`containsSubsequence("events", "e", e.name, "login", "purchase", "logout")`,
+		`!containsSubsequence("events", "e", e.name, "login", "purchase", "logout")`,
+		`containsSubsequence("codes", "c", c, 1, 2)`)
+	expectNoErrors(t, repo)
+
+	// Contiguous
+	expectMatches(t, genFilter, `{"events": [{"name": "view"}, {"name": "login"}, {"name": "purchase"},
+		{"name": "logout"}, {"name": "view"}]}`, 0)
+	expectMatches(t, genFilter, `{"events": [{"name": "login"}, {"name": "login"}, {"name": "purchase"},
+		{"name": "logout"}]}`, 0)
+	expectMatches(t, genFilter, `{"codes": [3, 1, 1, 2]}`, 1, 2)
+	// Present but not contiguous
+	expectMatches(t, genFilter, `{"events": [{"name": "login"}, {"name": "view"}, {"name": "purchase"},
+		{"name": "logout"}]}`, 1)
+	// The elements with undefined expr break the run
+	expectMatches(t, genFilter, `{"events": [{"name": "login"}, {}, {"name": "purchase"}, {"name": "logout"}]}`, 1)
+	expectMatches(t, genFilter, `{"codes": [1, "x", 2]}`, 1)
+	// Absent
+	expectMatches(t, genFilter, `{"events": [{"name": "logout"}, {"name": "purchase"}, {"name": "login"}]}`, 1)
+	expectMatches(t, genFilter, `{"events": [{"name": "login"}, {"name": "purchase"}]}`, 1)
+	expectMatches(t, genFilter, `{"events": []}`, 1)
+	// The negated condition is true when the array is missing
+	expectMatches(t, genFilter, `{"other": []}`, 1)
+}
+
+func TestContainsSubsequenceErrors(t *testing.T) {
+	expectRuleEngineError(t, `containsSubsequence("events", "e", e.name)`)
+	expectRuleEngineError(t, `containsSubsequence("events", "e", e.name, first)`)
+	expectRuleEngineError(t, `containsSubsequence(events, "e", e.name, "login")`)
+}
+
+func TestIsIn(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`isIn(userRole, allowedRoles)`,
+		`!isIn(userRole, allowedRoles)`,
+		`isIn(code, policy.codes)`,
+		`isIn(userRole, "admin", "ops")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"userRole": "ops", "allowedRoles": ["admin", "ops"]}`, 0, 3)
+	expectMatches(t, genFilter, `{"userRole": "dev", "allowedRoles": ["admin", "ops"]}`, 1)
+	expectMatches(t, genFilter, `{"userRole": "dev", "allowedRoles": []}`, 1)
+	expectMatches(t, genFilter, `{"code": 42, "policy": {"codes": [7, 42]}}`, 1, 2)
+	expectMatches(t, genFilter, `{"code": 43, "policy": {"codes": [7, 42]}}`, 1)
+
+	// Undefined value or array
+	expectMatches(t, genFilter, `{"userRole": "admin"}`, 1, 3)
+	expectMatches(t, genFilter, `{"allowedRoles": ["admin"]}`, 1)
+}
+
+func TestForEachEntry(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`forEachEntry("flags", "k", "v", isEqualToAny(string(v), "true", "false"))`,
+		`forSomeEntry("config", "k", "v", v > 100)`,
+		`forSomeEntry("config", "name", "limit", name == "max" && limit >= 10)`,
+		`forEachEntry("config", "k", "v", v.count > 1 || k == "skip")`,
+		`a == 1 && !forSomeEntry("flags", "k", "v", string(v) == "false")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"flags": {"beta": true, "dark": false}}`, 0)
+	expectMatches(t, genFilter, `{"flags": {"beta": true, "dark": "maybe"}}`)
+	expectMatches(t, genFilter, `{"flags": {"beta": true}, "a": 1}`, 0, 4)
+	expectMatches(t, genFilter, `{"config": {"min": 5, "max": 50, "cap": 200}}`, 1, 2)
+	expectMatches(t, genFilter, `{"config": {"min": 500, "max": 5}}`, 1)
+	expectMatches(t, genFilter, `{"config": {"a": {"count": 2}, "skip": 0}}`, 3)
+	expectMatches(t, genFilter, `{"config": {"a": {"count": 2}, "b": {"count": 1}}}`)
+	// The empty object satisfies the condition over every entry, but not the condition for some entry
+	expectMatches(t, genFilter, `{"flags": {}, "config": {}, "a": 1}`, 0, 3, 4)
+}
+
+func TestForEachEntryErrors(t *testing.T) {
+	expectRuleEngineError(t, `forEachEntry("flags", "k", v == 1)`)
+	expectRuleEngineError(t, `forEachEntry("flags", k, "v", v == 1)`)
+	expectRuleEngineError(t, `forSomeEntry("flags", "k", "k", k == "x")`)
+}
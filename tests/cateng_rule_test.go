@@ -1,9 +1,12 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/atlasgurus/rulestone/cateng"
 	c "github.com/atlasgurus/rulestone/condition"
 	"github.com/atlasgurus/rulestone/types"
+	"reflect"
 	"testing"
 )
 
@@ -368,3 +371,55 @@ func TestFilterOpt4(t *testing.T) {
 
 	catFilter.PrintMetrics()
 }
+
+func TestFilterOptTrace(t *testing.T) {
+	newRule := func(id c.RuleIdType, orCats []types.Category, last types.Category) *c.Rule {
+		orConds := make([]c.Condition, len(orCats))
+		for i, cat := range orCats {
+			orConds[i] = c.NewCategoryCond(cat)
+		}
+		return c.NewRule(id, c.NewAndCond(
+			c.NewOrCond(c.NewCategoryCond(1)),
+			c.NewOrCond(c.NewCategoryCond(2)),
+			c.NewOrCond(orConds...),
+			c.NewOrCond(c.NewCategoryCond(last))))
+	}
+	repo := c.NewRuleRepo([]*c.Rule{
+		newRule(1, []types.Category{3, 4, 5}, 6),
+		newRule(2, []types.Category{3, 4, 5}, 7),
+		newRule(3, []types.Category{3, 5}, 8),
+	})
+	var trace bytes.Buffer
+	catFilter := cateng.NewCategoryEngine(repo, &cateng.Options{
+		OrOptimizationFreqThreshold:  1,
+		AndOptimizationFreqThreshold: 1,
+		TraceWriter:                  &trace,
+	})
+	if matches := catFilter.MatchEvent([]types.Category{1, 2, 5, 8}); len(matches) != 1 {
+		t.Fatalf("failed number of matches %d != 1", len(matches))
+	}
+
+	steps := make(map[string][]cateng.TraceRecord)
+	dec := json.NewDecoder(&trace)
+	for dec.More() {
+		var record cateng.TraceRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("failed to decode the trace record: %s", err)
+		}
+		if record.SyntheticCategory >= 0 {
+			t.Fatalf("failed trace record %+v with non-synthetic category", record)
+		}
+		steps[record.Step] = append(steps[record.Step], record)
+	}
+	orMerged := steps[cateng.TraceOrSetMerged]
+	if len(orMerged) == 0 || !reflect.DeepEqual(orMerged[0].Categories, []types.Category{3, 5}) || orMerged[0].Freq != 3 {
+		t.Fatalf("failed trace of the merged OR sets: %+v", orMerged)
+	}
+	andMerged := steps[cateng.TraceAndSetMerged]
+	if len(andMerged) == 0 || len(andMerged[0].Sets) != 2 || andMerged[0].Freq != 3 {
+		t.Fatalf("failed trace of the merged AND sets: %+v", andMerged)
+	}
+	if len(steps) == 0 || len(steps[cateng.TraceOrSetInlined])+len(steps[cateng.TraceAndOrSetInlined]) == 0 {
+		t.Fatalf("failed trace of the inlined sets: %v", steps)
+	}
+}
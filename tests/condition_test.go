@@ -3,6 +3,7 @@ package tests_test
 import (
 	"fmt"
 	c "github.com/atlasgurus/rulestone/condition"
+	"math"
 	"testing"
 )
 
@@ -27,3 +28,19 @@ func TestOf(t *testing.T) {
 		fmt.Println(rule)
 	})
 }
+
+func TestFloatOperandHash(t *testing.T) {
+	// The fractional and negative values must not be truncated to the same hash
+	distinct := []float64{0.3, 0.8, 1, 1.5, -1.2, -1.5, 1e20, 2e20}
+	for i, x := range distinct {
+		for _, y := range distinct[i+1:] {
+			if c.NewFloatOperand(x).GetHash() == c.NewFloatOperand(y).GetHash() {
+				t.Errorf("same hash for %v and %v", x, y)
+			}
+		}
+	}
+	// The equal values must have the same hash
+	if c.NewFloatOperand(0).GetHash() != c.NewFloatOperand(math.Copysign(0, -1)).GetHash() {
+		t.Errorf("different hash for 0 and -0")
+	}
+}
@@ -0,0 +1,389 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/engine"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTimeOfDayBetween(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`timeOfDayBetween(date(created), "09:00", "17:00")`,
+		`timeOfDayBetween(created, "22:00", "06:00:00")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"created": "2023-03-29T09:00:00Z"}`, 0)
+	expectMatches(t, genFilter, `{"created": "2023-03-29T12:30:00Z"}`, 0)
+	expectMatches(t, genFilter, `{"created": "2023-03-29T17:00:01Z"}`)
+	expectMatches(t, genFilter, `{"created": "2023-03-29T08:59:59Z"}`)
+
+	// Wrapping window
+	expectMatches(t, genFilter, `{"created": "2023-03-29T23:15:00Z"}`, 1)
+	expectMatches(t, genFilter, `{"created": "2023-03-30T02:00:00Z"}`, 1)
+	expectMatches(t, genFilter, `{"created": "2023-03-30T06:00:00Z"}`, 1)
+	expectMatches(t, genFilter, `{"created": "2023-03-30T21:59:00Z"}`)
+
+	// The clock time is taken in the date's own time zone
+	expectMatches(t, genFilter, `{"created": "2023-03-29T10:00:00+05:00"}`, 0)
+
+	expectMatches(t, genFilter, `{"created": null}`)
+	expectMatches(t, genFilter, `{"other": "2023-03-29T12:30:00Z"}`)
+}
+
+func TestTimeOfDayBetweenErrors(t *testing.T) {
+	expectRuleEngineError(t, `timeOfDayBetween(created, "9am", "17:00")`)
+	expectRuleEngineError(t, `timeOfDayBetween(created, start, "17:00")`)
+	expectRuleEngineError(t, `timeOfDayBetween(created, "09:00")`)
+}
+
+func TestDateParts(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`year(created) == 2023 && month(created) == 12`,
+		`day(created) == 25`,
+		`hour(created) >= 9 && hour(created) < 17`,
+		`minute(created) == 30`,
+		`weekday(created) == 0 || weekday(created) == 6`,
+		`year(created) - year(dob) >= 18`,
+		`hour(inZone(created, "America/New_York")) == 4`)
+	expectNoErrors(t, repo)
+
+	// Monday
+	expectMatches(t, genFilter, `{"created": "2023-12-25T09:30:00Z"}`, 0, 1, 2, 3, 6)
+	// Saturday
+	expectMatches(t, genFilter, `{"created": "2023-03-25T17:00:00Z", "dob": "2005-01-01"}`, 1, 4, 5)
+	// Sunday, the parts are in the date's own time zone
+	expectMatches(t, genFilter, `{"created": "2023-12-31T23:45:00-05:00", "dob": "2006-12-31"}`, 0, 4)
+
+	// Undefined values propagate
+	expectMatches(t, genFilter, `{"created": null}`)
+	expectMatches(t, genFilter, `{"other": "2023-12-25T09:30:00Z"}`)
+
+	expectRuleEngineError(t, `year(created, dob) > 2000`)
+	expectRuleEngineError(t, `month() == 1`)
+}
+
+func TestTimeBucket(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`timeBucket(ts, "1h") == timeBucket(prev, "1h")`,
+		`timeBucket(ts, "5m") == timeBucket(prev, "5m")`,
+		`timeBucket(ts, "1d") == timeBucket(prev, "1d")`,
+		`timeBucket(ts, "1m") == date("2024-03-10T10:15:00Z")`,
+		`dateFormat(timeBucket(ts, "1h"), "15:04") == "10:00"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T10:15:30Z", "prev": "2024-03-10T10:59:59Z"}`, 0, 2, 3, 4)
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T10:04:59Z", "prev": "2024-03-10T10:00:00Z"}`, 0, 1, 2, 4)
+	// The bucket starts are inclusive and the ends exclusive
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T10:00:00Z", "prev": "2024-03-10T09:59:59.999Z"}`, 2, 4)
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T23:59:59Z", "prev": "2024-03-11T00:00:00Z"}`)
+	// The buckets are aligned on UTC while the result keeps the time zone of the date
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T01:30:00+02:00", "prev": "2024-03-09T23:10:00Z"}`, 0, 2)
+
+	// Undefined values propagate
+	expectMatches(t, genFilter, `{"ts": null, "prev": "2024-03-10T10:00:00Z"}`)
+	expectMatches(t, genFilter, `{"prev": "2024-03-10T10:00:00Z"}`)
+
+	expectRuleEngineError(t, `timeBucket(ts) == prev`)
+	expectRuleEngineError(t, `timeBucket(ts, "0h") == prev`)
+	expectRuleEngineError(t, `timeBucket(ts, "-1h") == prev`)
+	expectRuleEngineError(t, `timeBucket(ts, "xd") == prev`)
+	expectRuleEngineError(t, `timeBucket(ts, granularity) == prev`)
+	// The granularity must divide the day evenly or be a whole number of days
+	expectRuleEngineError(t, `timeBucket(ts, "7h") == prev`)
+	expectRuleEngineError(t, `timeBucket(ts, "25h") == prev`)
+}
+
+func TestTimeBucketMidnightAlignment(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`timeBucket(ts, "6h") == date("2024-03-10T00:00:00Z")`,
+		`timeBucket(ts, "90m") == date("2024-03-10T00:00:00Z")`,
+		`timeBucket(ts, "2d") == timeBucket(prev, "2d")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T01:00:00Z", "prev": "2024-03-10T23:00:00Z"}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"ts": "2024-03-10T05:59:59Z", "prev": "2024-03-09T00:00:00Z"}`, 0)
+	expectMatches(t, genFilter, `{"ts": "2024-03-09T23:59:59Z", "prev": "2024-03-10T00:00:00Z"}`)
+}
+
+func TestDayOfMonthIn(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`dayOfMonthIn(billed, 1, 15)`,
+		`!dayOfMonthIn(billed, 1, 15)`,
+		`dayOfMonthIn(billed, 30, 31)`,
+		`dayOfMonthIn(billed, 31)`,
+		`dayOfMonthIn(billed, 29)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"billed": "2024-03-01T10:00:00Z"}`, 0)
+	expectMatches(t, genFilter, `{"billed": "2024-03-15"}`, 0)
+	expectMatches(t, genFilter, `{"billed": "2024-03-14T23:59:59Z"}`, 1)
+	// The day of the date's own time zone
+	expectMatches(t, genFilter, `{"billed": "2024-03-14T23:30:00-05:00"}`, 1)
+	expectMatches(t, genFilter, `{"billed": "2024-03-15T01:00:00+02:00"}`, 0)
+	// Month ends
+	expectMatches(t, genFilter, `{"billed": "2024-03-31"}`, 1, 2, 3)
+	expectMatches(t, genFilter, `{"billed": "2024-04-30"}`, 1, 2)
+	expectMatches(t, genFilter, `{"billed": "2024-12-31T23:59:59Z"}`, 1, 2, 3)
+	expectMatches(t, genFilter, `{"billed": "2025-01-01T00:00:00Z"}`, 0)
+	// The last day of February is day 29 only in the leap years
+	expectMatches(t, genFilter, `{"billed": "2023-02-28"}`, 1)
+	expectMatches(t, genFilter, `{"billed": "2024-02-28"}`, 1)
+	expectMatches(t, genFilter, `{"billed": "2024-02-29"}`, 1, 4)
+
+	// Undefined date
+	expectMatches(t, genFilter, `{"billed": null}`, 1)
+	expectMatches(t, genFilter, `{"other": "2024-03-01"}`, 1)
+
+	expectRuleEngineError(t, `dayOfMonthIn(billed)`)
+	expectRuleEngineError(t, `dayOfMonthIn(billed, day)`)
+	expectRuleEngineError(t, `dayOfMonthIn(billed, 32)`)
+	expectRuleEngineError(t, `dayOfMonthIn(billed, 0)`)
+	expectRuleEngineError(t, `dayOfMonthIn(billed, 0 - 1)`)
+	expectRuleEngineError(t, `dayOfMonthIn(billed, 1.5)`)
+}
+
+func TestBusinessDaysBetween(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`businessDaysBetween(opened, closed) == 0`,
+		`businessDaysBetween(opened, closed) == 1`,
+		`businessDaysBetween(opened, closed) == 5`,
+		`businessDaysBetween(opened, closed) == 12`,
+		`businessDaysBetween(opened, closed) < 0`,
+		`businessDaysBetween(opened, "2023-04-03") > 4`)
+	expectNoErrors(t, repo)
+
+	// Monday to Tuesday
+	expectMatches(t, genFilter, `{"opened": "2023-03-27", "closed": "2023-03-28"}`, 1, 5)
+	// Friday to Monday spans a weekend
+	expectMatches(t, genFilter, `{"opened": "2023-03-31T18:00:00Z", "closed": "2023-04-03T08:00:00Z"}`, 1)
+	// Saturday to Monday
+	expectMatches(t, genFilter, `{"opened": "2023-04-01", "closed": "2023-04-03"}`, 0)
+	// Same day
+	expectMatches(t, genFilter, `{"opened": "2023-03-29T08:00:00Z", "closed": "2023-03-29T17:00:00Z"}`, 0)
+	// Monday to the next Monday
+	expectMatches(t, genFilter, `{"opened": "2023-03-27", "closed": "2023-04-03"}`, 2, 5)
+	// Wednesday to the Friday two weeks later
+	expectMatches(t, genFilter, `{"opened": "2023-03-29", "closed": "2023-04-14"}`, 3)
+	expectMatches(t, genFilter, `{"opened": "2023-04-14", "closed": "2023-03-29"}`, 4)
+
+	expectMatches(t, genFilter, `{"opened": "2023-03-27", "closed": null}`, 5)
+	expectMatches(t, genFilter, `{"closed": "2023-04-03"}`)
+}
+
+func TestBusinessDaysBetweenHolidays(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`businessDaysBetween(opened, closed, "holidays") == 3`,
+		`businessDaysBetween(opened, closed, "holidays") == 5`,
+		`businessDaysBetween(opened, closed) == 5`,
+		`businessDaysBetween(opened, closed, "holidays") + 3 == 0`)
+	// Good Friday, Easter Sunday and Easter Monday 2023
+	repo.RegisterSet("holidays", []string{"2023-04-07", "2023-04-09", "2023-04-10"})
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	// The holidays on the weekdays are not counted, the ones on the weekends do not count twice
+	expectMatches(t, genFilter, `{"opened": "2023-04-05", "closed": "2023-04-12"}`, 0, 2)
+	expectMatches(t, genFilter, `{"opened": "2023-04-12", "closed": "2023-04-05"}`, 3)
+	// The closing day is excluded
+	expectMatches(t, genFilter, `{"opened": "2023-03-31", "closed": "2023-04-07"}`, 1, 2)
+
+	// The engine sees the updated holidays
+	if err := repo.UpdateSet("holidays", []string{"2023-04-07", "2023-04-10", "2023-04-11"}); err != nil {
+		t.Fatalf("failed UpdateSet: %s", err)
+	}
+	expectMatches(t, genFilter, `{"opened": "2023-04-05", "closed": "2023-04-12"}`, 2)
+	expectMatches(t, genFilter, `{"opened": "2023-04-04", "closed": "2023-04-12"}`, 0)
+}
+
+func TestBusinessDaysBetweenErrors(t *testing.T) {
+	expectRuleEngineError(t, `businessDaysBetween(opened) > 1`)
+	expectRuleEngineError(t, `businessDaysBetween(opened, closed, holidays) > 1`)
+	expectRuleEngineError(t, `businessDaysBetween(opened, closed, "unknown") > 1`)
+	expectRuleEngineError(t, `businessDaysBetween(opened, closed, "holidays", 1) > 1`)
+}
+
+func TestDaysSinceAt(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`daysSince(created) > 30`,
+		`daysSince(created) < 1`)
+	expectNoErrors(t, repo)
+
+	at := time.Date(2023, 3, 29, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		event    string
+		expected []condition.RuleIdType
+	}{
+		{`{"created": "2023-02-20"}`, []condition.RuleIdType{0}},
+		{`{"created": "2023-02-28T13:00:00Z"}`, []condition.RuleIdType{}},
+		{`{"created": "2023-03-29T00:00:00Z"}`, []condition.RuleIdType{1}},
+		// Future dates are negative
+		{`{"created": "2023-06-01"}`, []condition.RuleIdType{1}},
+		{`{"created": null}`, []condition.RuleIdType{}},
+	} {
+		var event interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed Unmarshal: %s", err)
+		}
+		matches := genFilter.MatchEventAt(event, at)
+		sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+		if !reflect.DeepEqual(matches, tc.expected) {
+			t.Fatalf("failed matches %v != %v for event %s", matches, tc.expected, tc.event)
+		}
+	}
+
+	expectRuleEngineError(t, `daysSince() > 1`)
+	expectRuleEngineError(t, `daysSince(a, b) > 1`)
+}
+
+func TestNowAndDuration(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`date(createdAt) > now() - duration("24h")`,
+		`date(createdAt) + duration("1h30m") < now()`,
+		`date(closedAt) - date(createdAt) > duration("48h")`,
+		`duration("2h") + date(createdAt) == date(closedAt)`)
+	expectNoErrors(t, repo)
+
+	at := time.Date(2023, 3, 29, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		event    string
+		expected []condition.RuleIdType
+	}{
+		{`{"createdAt": "2023-03-29T11:00:00Z"}`, []condition.RuleIdType{0}},
+		{`{"createdAt": "2023-03-29T10:00:00Z"}`, []condition.RuleIdType{0, 1}},
+		{`{"createdAt": "2023-03-28T10:00:00Z"}`, []condition.RuleIdType{1}},
+		{`{"createdAt": "2023-03-20T10:00:00Z", "closedAt": "2023-03-23T10:00:00Z"}`, []condition.RuleIdType{1, 2}},
+		{`{"createdAt": "2023-03-20T10:00:00Z", "closedAt": "2023-03-20T12:00:00Z"}`, []condition.RuleIdType{1, 3}},
+		{`{"createdAt": null}`, []condition.RuleIdType{}},
+	} {
+		var event interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed Unmarshal: %s", err)
+		}
+		matches := genFilter.MatchEventAt(event, at)
+		sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+		if !reflect.DeepEqual(matches, tc.expected) {
+			t.Fatalf("failed matches %v != %v for event %s", matches, tc.expected, tc.event)
+		}
+	}
+
+	// Without MatchEventAt now() is the wall clock
+	expectMatches(t, genFilter, `{"createdAt": "`+time.Now().Add(-time.Hour).Format(time.RFC3339)+`"}`, 0)
+
+	expectRuleEngineError(t, `now(a) > date(b)`)
+	expectRuleEngineError(t, `date(a) > now() - duration("1x")`)
+	expectRuleEngineError(t, `date(a) > now() - duration(d)`)
+}
+
+func TestDateDiff(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`dateDiff(opened, closed, "seconds") == 5400`,
+		`dateDiff(opened, closed, "minutes") == 90`,
+		`dateDiff(opened, closed, "hours") == 1.5`,
+		`dateDiff(opened, closed, "days") < 0`,
+		`dateDiff(opened, closed, "weeks") > 1.9`,
+		`dateDiff(opened, "2023-03-01", "days") == 3`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"opened": "2023-03-29T10:00:00Z", "closed": "2023-03-29T11:30:00Z"}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"opened": "2023-03-29T10:00:00Z", "closed": "2023-03-28T10:00:00Z"}`, 3)
+	expectMatches(t, genFilter, `{"opened": "2023-03-01", "closed": "2023-03-15"}`, 4)
+	expectMatches(t, genFilter, `{"opened": "2023-02-26"}`, 5)
+
+	// Undefined dates make the difference undefined
+	expectMatches(t, genFilter, `{"opened": null, "closed": "2023-03-29"}`)
+	expectMatches(t, genFilter, `{"closed": "2023-03-29"}`)
+
+	expectRuleEngineError(t, `dateDiff(a, b, "fortnights") > 1`)
+	expectRuleEngineError(t, `dateDiff(a, b, unit) > 1`)
+	expectRuleEngineError(t, `dateDiff(a, b) > 1`)
+}
+
+func TestAfterByAtLeast(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`afterByAtLeast(shipped, paid, 1, "hours")`,
+		`afterByAtLeast(shipped, paid, gap, "days")`,
+		`!afterByAtLeast(shipped, paid, 1, "hours")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"shipped": "2023-03-29T12:00:00Z", "paid": "2023-03-29T10:00:00Z"}`, 0)
+	// Exactly the gap satisfies the condition
+	expectMatches(t, genFilter, `{"shipped": "2023-03-29T11:00:00Z", "paid": "2023-03-29T10:00:00Z"}`, 0)
+	// Too soon and before
+	expectMatches(t, genFilter, `{"shipped": "2023-03-29T10:59:59Z", "paid": "2023-03-29T10:00:00Z"}`, 2)
+	expectMatches(t, genFilter, `{"shipped": "2023-03-29T09:00:00Z", "paid": "2023-03-29T10:00:00Z"}`, 2)
+	expectMatches(t, genFilter, `{"shipped": "2023-03-31", "paid": "2023-03-29", "gap": 2}`, 0, 1)
+	expectMatches(t, genFilter, `{"shipped": "2023-03-30", "paid": "2023-03-29", "gap": 2}`, 0)
+
+	// Undefined dates make the result undefined, only the negation matches
+	expectMatches(t, genFilter, `{"shipped": null, "paid": "2023-03-29"}`, 2)
+	expectMatches(t, genFilter, `{"paid": "2023-03-29"}`, 2)
+
+	expectRuleEngineError(t, `afterByAtLeast(a, b, 1, "ages")`)
+	expectRuleEngineError(t, `afterByAtLeast(a, b, 1)`)
+}
+
+func TestDateParseFormat(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`dateParse(shipped, "02/01/2006") == date("2023-03-01")`,
+		`date(shipped) == date("2023-03-01")`,
+		`dateFormat(dateParse(shipped, "02/01/2006"), "2006-01-02") == "2023-03-01"`,
+		`dateFormat(created, "2006-01") == "2023-03"`,
+		`dateParse(shipped, "02/01/2006") > date("2023-02-01")`)
+	expectNoErrors(t, repo)
+
+	// The explicit layout reads the day first, unlike the guessing of date()
+	expectMatches(t, genFilter, `{"shipped": "01/03/2023"}`, 0, 2, 4)
+	expectMatches(t, genFilter, `{"shipped": "03/01/2023"}`, 1)
+	expectMatches(t, genFilter, `{"created": "2023-03-29T10:00:00Z"}`, 3)
+
+	// The values not matching the layout are errors rather than guesses
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"shipped": "2023-03-01"}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	if _, err := genFilter.MatchEventExplain(event); err == nil {
+		t.Fatalf("expected dateParse error")
+	}
+	expectMatches(t, genFilter, `{"shipped": null}`)
+}
+
+func TestDefaultTimeZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %s", err)
+	}
+	expressions := []string{
+		`date(local) == date(utc)`,
+		`date(utc) == date(offset)`,
+		`dateParse(local, "2006-01-02 15:04") == date(utc)`,
+		`dateFormat(inZone(date(utc), "America/New_York"), "15:04") == "10:00"`,
+		`timeOfDayBetween(inZone(date(utc), "Asia/Tokyo"), "22:00", "23:59")`,
+	}
+	event := `{"local": "2023-03-29 10:00", "utc": "2023-03-29T14:00:00Z", "offset": "2023-03-29T16:00:00+02:00"}`
+
+	// The same instant expressed in two zones is equal, and the dates without an offset are in the default zone
+	repo := newRuleEngineRepoFromExpressions(t, expressions...)
+	genFilter, err := engine.NewRuleEngine(repo, engine.WithDefaultTimeZone(newYork))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	expectMatches(t, genFilter, event, 0, 1, 2, 3, 4)
+	expectMatches(t, genFilter, `{"utc": "2023-03-29T12:30:00Z"}`)
+
+	// Without the option the dates without an offset are in UTC
+	repo, genFilter = newRuleEngineFromExpressions(t, expressions...)
+	expectNoErrors(t, repo)
+	expectMatches(t, genFilter, event, 1, 3, 4)
+	expectMatches(t, genFilter, `{"local": "2023-03-29 14:00", "utc": "2023-03-29T14:00:00Z"}`, 0, 2, 3, 4)
+
+	expectRuleEngineError(t, `dateFormat(inZone(date(a), "Mars/Olympus"), "15:04") == "10:00"`)
+	expectRuleEngineError(t, `dateFormat(inZone(date(a), zone), "15:04") == "10:00"`)
+}
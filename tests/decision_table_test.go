@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/engine"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecisionTableCSV(t *testing.T) {
+	table := `tier, amount, country, age, action
+gold, >100, *, , approve
+=silver, 10..100, US|CA, , review
+, <=10, , >=65, senior
+!=gold, , -, <18, reject
+`
+	repo := engine.NewRuleEngineRepo()
+	ruleIds, err := repo.RegisterDecisionTableCSV(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("failed RegisterDecisionTableCSV: %s", err)
+	}
+	if len(ruleIds) != 4 {
+		t.Fatalf("failed number of rules %d != 4", len(ruleIds))
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"tier": "gold", "amount": 150, "country": "FR"}`, 0)
+	expectMatches(t, genFilter, `{"tier": "gold", "amount": 100}`)
+	expectMatches(t, genFilter, `{"tier": "silver", "amount": 100, "country": "CA"}`, 1)
+	expectMatches(t, genFilter, `{"tier": "silver", "amount": 50, "country": "FR"}`)
+	expectMatches(t, genFilter, `{"tier": "bronze", "amount": 5, "age": 70}`, 2)
+	expectMatches(t, genFilter, `{"tier": "silver", "amount": 15, "country": "US", "age": 16}`, 1, 3)
+
+	if rule := genFilter.GetRuleDefinition(1); rule.Metadata["action"] != "review" || rule.Metadata["row"] != 2 {
+		t.Fatalf("failed rule metadata %v", rule.Metadata)
+	}
+}
+
+func TestDecisionTableCSVPriorityEnabled(t *testing.T) {
+	table := `customer.tier, Priority, enabled, action
+gold, 10, , approve
+gold, , false, audit
+gold, -5, true, review
+`
+	repo := engine.NewRuleEngineRepo()
+	if _, err := repo.RegisterDecisionTableCSV(strings.NewReader(table)); err != nil {
+		t.Fatalf("failed RegisterDecisionTableCSV: %s", err)
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	// The priority and enabled columns are not conditions
+	event := map[string]interface{}{"customer": map[string]interface{}{"tier": "gold"}}
+	if result := genFilter.MatchEventSorted(event); !reflect.DeepEqual(result, []condition.RuleIdType{0, 2}) {
+		t.Fatalf("failed MatchEventSorted: %v", result)
+	}
+	if err := genFilter.SetRuleEnabled(1, true); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	if result := genFilter.MatchEventSorted(event); !reflect.DeepEqual(result, []condition.RuleIdType{0, 1, 2}) {
+		t.Fatalf("failed MatchEventSorted after enabling the rule: %v", result)
+	}
+
+	if rule := genFilter.GetRuleDefinition(0); rule.Metadata["priority"] != 10 || rule.Priority != 10 {
+		t.Fatalf("failed rule priority %v", rule.Metadata)
+	}
+	if rule := genFilter.GetRuleDefinition(1); rule.Metadata["enabled"] != false || !rule.Disabled {
+		t.Fatalf("failed rule enabled flag %v", rule.Metadata)
+	}
+}
+
+func TestDecisionTableCSVErrors(t *testing.T) {
+	for _, table := range []string{
+		"tier, amount\ngold, >100\n",
+		"tier, action\n>, approve\n",
+		"amount, action\n10..x, approve\n",
+		"tier, action\n, approve\n",
+		"tier, action\ngold, approve, extra\n",
+		"tier > 1, action\ngold, approve\n",
+		"tier), action\ngold, approve\n",
+		"(tier), action\ngold, approve\n",
+		"customer.tier[0], action\ngold, approve\n",
+		"type, action\ngold, approve\n",
+		", action\ngold, approve\n",
+		"tier, priority, action\ngold, high, approve\n",
+		"tier, enabled, action\ngold, maybe, approve\n",
+	} {
+		repo := engine.NewRuleEngineRepo()
+		if _, err := repo.RegisterDecisionTableCSV(strings.NewReader(table)); err == nil {
+			t.Fatalf("expected error for decision table %q", table)
+		}
+		if len(repo.Rules) != 0 {
+			t.Fatalf("failed: no rules must be registered for decision table %q", table)
+		}
+	}
+}
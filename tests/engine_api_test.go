@@ -1,9 +1,22 @@
 package tests
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/atlasgurus/rulestone/cateng"
+	"github.com/atlasgurus/rulestone/condition"
 	"github.com/atlasgurus/rulestone/engine"
+	"github.com/atlasgurus/rulestone/types"
 	"github.com/atlasgurus/rulestone/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestFilterApiExpression0(t *testing.T) {
@@ -265,3 +278,1502 @@ func TestMultipleRulesPerFile(t *testing.T) {
 		repo.GetAppCtx().PrintErrors()
 	}
 }
+
+func TestConditionCache(t *testing.T) {
+	cache := engine.NewConditionCache(2)
+	engine.SetConditionCache(cache)
+	defer engine.SetConditionCache(nil)
+
+	expr := `name == "Frank" && age > 10`
+	repo1, genFilter1 := newRuleEngineFromExpressions(t, expr)
+	repo2, genFilter2 := newRuleEngineFromExpressions(t, expr)
+	expectNoErrors(t, repo1)
+	expectNoErrors(t, repo2)
+
+	if genFilter1.GetRuleDefinition(0).Condition != genFilter2.GetRuleDefinition(0).Condition {
+		t.Fatalf("failed to reuse the cached condition")
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 1 {
+		t.Fatalf("failed cache stats hits=%d misses=%d", hits, misses)
+	}
+	expectMatches(t, genFilter2, `{"name": "Frank", "age": 20}`, 0)
+
+	// The cache is bounded
+	newRuleEngineFromExpressions(t, "a == 1", "b == 2", "c == 3")
+	if cache.Len() != 2 {
+		t.Fatalf("failed cache size %d != 2", cache.Len())
+	}
+
+	// Syntax errors are reported at registration and not cached
+	repo := engine.NewRuleEngineRepo()
+	if _, err := repo.RegisterRuleFromString(`[{"expression": "a == "}]`, "json"); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("failed cache size %d != 2", cache.Len())
+	}
+}
+
+func TestExprParseErrors(t *testing.T) {
+	broken := `[{"metadata": {"rule_id": "BROKEN_RULE"}, "expression": "a == 1 && (b > 2"}]`
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{`[{"metadata": {"rule_id": "GOOD_RULE"}, "expression": "a == 1"}]`, broken} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	_, err := engine.NewRuleEngine(repo)
+	if err == nil {
+		t.Fatalf("expected syntax error")
+	}
+	var parseErr *engine.ExprParseError
+	if !errors.As(err, &parseErr) || parseErr.Offset != 16 || parseErr.Line != 1 || parseErr.Column != 17 {
+		t.Fatalf("failed parse error position %v", err)
+	}
+	for _, s := range []string{"rule 1", "BROKEN_RULE", "offset 16"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("parse error %q does not contain %q", err, s)
+		}
+	}
+	if n := repo.GetAppCtx().NumErrors(); n != 1 || repo.GetAppCtx().GetError(0) != err {
+		t.Fatalf("failed to record the parse error, %d errors", n)
+	}
+
+	// The syntax errors are reported at registration with the condition cache
+	engine.SetConditionCache(engine.NewConditionCache(10))
+	defer engine.SetConditionCache(nil)
+	repo = engine.NewRuleEngineRepo()
+	_, err = repo.RegisterRuleFromString(broken, "json")
+	if err == nil || !errors.As(err, &parseErr) || parseErr.Offset != 16 || !strings.Contains(err.Error(), "BROKEN_RULE") {
+		t.Fatalf("failed parse error %v", err)
+	}
+}
+
+func TestMatchEventFunc(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a == 1`,
+		`b == 2`,
+		`a == 1 && b == 3`,
+		`c == 4`)
+	expectNoErrors(t, repo)
+
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"a": 1, "b": 2, "c": 4}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+
+	matched := make(map[condition.RuleIdType]int)
+	genFilter.MatchEventFunc(event, func(ruleId condition.RuleIdType) bool {
+		matched[ruleId]++
+		return true
+	})
+	if len(matched) != 3 || matched[0] != 1 || matched[1] != 1 || matched[3] != 1 {
+		t.Fatalf("failed matches %v", matched)
+	}
+
+	numCalls := 0
+	genFilter.MatchEventFunc(event, func(ruleId condition.RuleIdType) bool {
+		numCalls++
+		return false
+	})
+	if numCalls != 1 {
+		t.Fatalf("failed to stop matching, number of calls %d != 1", numCalls)
+	}
+}
+
+func TestPredicateCostOrdering(t *testing.T) {
+	expressions := []string{
+		`forSome("items", "item", regexpMatch("^gift", item.name) && item.qty > 100)`,
+		`forSome("items", "item", similarity(item.name, "gift card") > 0.8 || item.qty < 0 || item.price == 0)`,
+		`forAll("items", "item", (regexpMatch("^gift", item.name) && item.qty > 1) || item.price > 10)`,
+	}
+	events := []string{
+		`{"items": [{"name": "gift card", "qty": 200, "price": 5}]}`,
+		`{"items": [{"name": "gift card", "qty": 2, "price": 5}, {"name": "book", "qty": 1, "price": 20}]}`,
+		`{"items": [{"name": "book", "qty": -1, "price": 0}]}`,
+		`{"items": [{"name": "gift cart", "qty": 101, "price": 11}]}`,
+		`{"items": [{"name": "book", "qty": 2, "price": 1}]}`,
+		`{"items": []}`,
+	}
+
+	repo := newRuleEngineRepoFromExpressions(t, expressions...)
+	sourceOrder, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	costOrder, err := engine.NewRuleEngine(repo, engine.WithPredicateCostOrdering(true))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	for _, event := range events {
+		expected := matchJsonEvent(t, sourceOrder, event)
+		expectMatches(t, costOrder, event, expected...)
+	}
+	expectMatches(t, costOrder, events[0], 0, 1, 2)
+	expectMatches(t, costOrder, events[4])
+}
+
+func TestLenientNumericStrings(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`zip == 12345`,
+		`zip != 12345`,
+		`isEqualToAny(zip, 10001, 12345)`,
+		`zip == "12345"`)
+	strict, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	lenient, err := engine.NewRuleEngine(repo, engine.WithLenientNumericStrings(true))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	// By default the string is not equal to the number
+	expectMatches(t, strict, `{"zip": "12345"}`, 1, 3)
+	expectMatches(t, strict, `{"zip": 12345}`, 0, 2)
+	expectMatches(t, strict, `{"zip": "abc"}`, 1)
+
+	expectMatches(t, lenient, `{"zip": "12345"}`, 0, 2, 3)
+	expectMatches(t, lenient, `{"zip": 12345}`, 0, 2, 3)
+	expectMatches(t, lenient, `{"zip": "12345.0"}`, 0, 2, 3)
+	expectMatches(t, lenient, `{"zip": "10001"}`, 1, 2)
+	// Only the strings that parse cleanly are numbers
+	expectMatches(t, lenient, `{"zip": " 12345"}`, 1)
+	expectMatches(t, lenient, `{"zip": "abc"}`, 1)
+}
+
+func TestDebugMatchedCategories(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t, `a == 1 && b > 2`)
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		event    string
+		expected []types.Category
+	}{
+		{`{"a": 1, "b": 3}`, []types.Category{1, 2}},
+		{`{"a": 1, "b": 2}`, []types.Category{1}},
+		{`{"a": 2, "b": 3}`, []types.Category{2}},
+		{`{"c": 1}`, []types.Category{}},
+	} {
+		var event interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed Unmarshal: %s", err)
+		}
+		cats := genFilter.DebugMatchedCategories(event)
+		if !reflect.DeepEqual(cats, tc.expected) {
+			t.Fatalf("failed matched categories %v != %v for event %s", cats, tc.expected, tc.event)
+		}
+	}
+}
+
+func TestPerRuleTimeout(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`slow(a) > 0.5`,
+		`status == "ok"`,
+		`status == "ok" || slow(a) > 0.5`,
+		`hasValue(a)`)
+	// The slow function blocks well past the budget, regardless of the machine load
+	repo.RegisterFunction("slow", func(args []condition.Operand) condition.Operand {
+		if args[0].Convert(condition.StringOperandKind) == condition.NewStringOperand("slow") {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return condition.NewFloatOperand(1)
+	})
+	genFilter, err := engine.NewRuleEngine(repo, engine.WithPerRuleTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"a": "fast", "status": "ok"}`, 0, 1, 2, 3)
+	if genFilter.Metrics.NumRuleTimeouts != 0 {
+		t.Fatalf("unexpected timeouts: %d", genFilter.Metrics.NumRuleTimeouts)
+	}
+
+	expectMatches(t, genFilter, `{"a": "slow", "status": "ok"}`, 1, 3)
+	if genFilter.Metrics.NumRuleTimeouts != 2 {
+		t.Fatalf("expected the timeouts of rules 0 and 2, got %d", genFilter.Metrics.NumRuleTimeouts)
+	}
+	// The timeouts are not logged to the repo errors
+	expectNoErrors(t, repo)
+
+	// The concurrent matches count the timeouts safely
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			genFilter.MatchEvents([]map[string]interface{}{{"a": "slow", "status": "ok"}})
+		}()
+	}
+	wg.Wait()
+	if genFilter.Metrics.NumRuleTimeouts != 10 {
+		t.Fatalf("expected 10 timeouts, got %d", genFilter.Metrics.NumRuleTimeouts)
+	}
+}
+
+func TestMatchEventElements(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`forSome("orders", "order", order.status == "failed")`,
+		`forSome("orders", "order", order.amount > 100) && forSome("refunds", "refund", refund.amount > 0)`,
+		`!forSome("orders", "order", order.status == "failed")`)
+	expectNoErrors(t, repo)
+
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{
+		"orders": [
+			{"status": "ok", "amount": 50},
+			{"status": "failed", "amount": 150},
+			{"status": "failed", "amount": 20},
+			{"status": "ok", "amount": 300}],
+		"refunds": [{"amount": 0}, {"amount": 10}]}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	matches, elements := genFilter.MatchEventElements(event)
+	sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{0, 1}) {
+		t.Fatalf("failed matches %v != [0 1]", matches)
+	}
+	expected := []engine.ElementMatch{
+		{RuleId: 0, Path: "orders", Indexes: []int{1, 2}},
+		{RuleId: 1, Path: "orders", Indexes: []int{1, 3}},
+		{RuleId: 1, Path: "refunds", Indexes: []int{1}},
+	}
+	if !reflect.DeepEqual(elements, expected) {
+		t.Fatalf("failed element matches %v != %v", elements, expected)
+	}
+
+	// The elements are not reported for the rules that did not match
+	matches, elements = genFilter.MatchEventElements(map[string]interface{}{
+		"orders": []interface{}{map[string]interface{}{"status": "ok", "amount": 200.0}}})
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{2}) || elements != nil {
+		t.Fatalf("failed matches %v, element matches %v", matches, elements)
+	}
+
+	// MatchEvent is not affected
+	expectMatches(t, genFilter, `{"orders": [{"status": "failed"}, {"status": "failed"}]}`, 0)
+}
+
+func TestMatchEventElementsShared(t *testing.T) {
+	// The same forSome() in an expression position compiled before the rule level one
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`majority(forSome("orders", "order", order.status == "failed"), a == 1, b == 1)`,
+		`forSome("orders", "order", order.status == "failed")`,
+		`count("orders", "order", order.status == "failed") >= 2`,
+		`count("orders", "order", order.amount > 100) * 2 > count("refunds", "refund", refund.amount > 0)`)
+	expectNoErrors(t, repo)
+
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{
+		"a": 1,
+		"orders": [
+			{"status": "ok", "amount": 50},
+			{"status": "failed", "amount": 150},
+			{"status": "failed", "amount": 20}],
+		"refunds": [{"amount": 10}]}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	matches, elements := genFilter.MatchEventElements(event)
+	sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{0, 1, 2, 3}) {
+		t.Fatalf("failed matches %v != [0 1 2 3]", matches)
+	}
+	// The comparison counting the elements of two arrays is not reported
+	expected := []engine.ElementMatch{
+		{RuleId: 1, Path: "orders", Indexes: []int{1, 2}},
+		{RuleId: 2, Path: "orders", Indexes: []int{1, 2}},
+	}
+	if !reflect.DeepEqual(elements, expected) {
+		t.Fatalf("failed element matches %v != %v", elements, expected)
+	}
+	expectMatches(t, genFilter, `{"a": 1, "orders": [{"status": "failed"}]}`, 0, 1)
+}
+
+func TestMatchEventExplain(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a == 1 && b > 2`,
+		`a == 1 || c == "x"`,
+		`log(d) > 1`)
+	expectNoErrors(t, repo)
+
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"a": 1, "b": 2}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	explanations, err := genFilter.MatchEventExplain(event)
+	if err != nil {
+		t.Fatalf("failed MatchEventExplain: %s", err)
+	}
+	expected := []engine.MatchExplanation{
+		{RuleId: 0, Expression: `a == 1 && b > 2`, Matched: false,
+			TrueCategories: []types.Category{1}, FalseCategories: []types.Category{2}},
+		{RuleId: 1, Expression: `a == 1 || c == "x"`, Matched: true,
+			TrueCategories: []types.Category{1}, FalseCategories: []types.Category{3}},
+		{RuleId: 2, Expression: `log(d) > 1`, Matched: false,
+			FalseCategories: []types.Category{4}},
+	}
+	if !reflect.DeepEqual(explanations, expected) {
+		t.Fatalf("failed explanations %+v != %+v", explanations, expected)
+	}
+
+	// The evaluation errors are reported rather than silently not matching
+	explanations, err = genFilter.MatchEventExplain(map[string]interface{}{"d": 0.0, "c": "x"})
+	if err == nil || !strings.Contains(err.Error(), "log()") {
+		t.Fatalf("expected log() evaluation error, got %v", err)
+	}
+	if !explanations[1].Matched || explanations[2].Matched {
+		t.Fatalf("failed explanations %+v", explanations)
+	}
+}
+
+func TestStrictEvaluation(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`dayOfMonthIn(date(closedAt), 1)`,
+		`!dayOfMonthIn(date(closedAt), 1)`,
+		`status == "open"`,
+		`forSome("orders", "o", log(o.total) > 1)`,
+		`log(a + b) > 1`)
+	lenient, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	strict, err := engine.NewRuleEngine(repo, engine.WithStrictEvaluation(true))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	event := map[string]interface{}{"closedAt": "garbage", "status": "open"}
+	// The failed evaluation does not match, so its negation does
+	expectMatches(t, lenient, `{"closedAt": "garbage", "status": "open"}`, 1, 2)
+	matches, evalErrors := lenient.MatchEventStrict(event)
+	sortRuleIds(matches)
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{1, 2}) || evalErrors != nil {
+		t.Fatalf("failed MatchEventStrict %v %v without the option", matches, evalErrors)
+	}
+
+	matches, evalErrors = strict.MatchEventStrict(event)
+	sortRuleIds(matches)
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{2}) {
+		t.Fatalf("failed MatchEventStrict %v", matches)
+	}
+	if len(evalErrors) != 2 || evalErrors[0].RuleId != 0 || evalErrors[1].RuleId != 1 {
+		t.Fatalf("failed MatchEventStrict errors %v", evalErrors)
+	}
+	for _, evalError := range evalErrors {
+		if evalError.Attribute != "closedAt" || !strings.Contains(evalError.Error(), "garbage") {
+			t.Fatalf("failed MatchEventStrict error %v", evalError)
+		}
+	}
+	// MatchEvent is not affected by the option
+	expectMatches(t, strict, `{"closedAt": "garbage", "status": "open"}`, 1, 2)
+
+	for _, tc := range []struct {
+		event     string
+		matches   []condition.RuleIdType
+		attribute string
+	}{
+		{`{"closedAt": "2023-05-01", "status": "closed"}`, []condition.RuleIdType{0}, ""},
+		{`{"orders": [{"total": 0}]}`, []condition.RuleIdType{1}, "orders[]"},
+		{`{"a": 1, "b": -1}`, []condition.RuleIdType{1}, "a, b"},
+	} {
+		var event interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed Unmarshal: %s", err)
+		}
+		matches, evalErrors := strict.MatchEventStrict(event)
+		sortRuleIds(matches)
+		if len(matches) != len(tc.matches) || len(matches) > 0 && !reflect.DeepEqual(matches, tc.matches) {
+			t.Fatalf("failed MatchEventStrict %v != %v for event %s", matches, tc.matches, tc.event)
+		}
+		if tc.attribute == "" && len(evalErrors) > 0 ||
+			tc.attribute != "" && (len(evalErrors) != 1 || evalErrors[0].Attribute != tc.attribute) {
+			t.Fatalf("failed MatchEventStrict errors %v for event %s", evalErrors, tc.event)
+		}
+	}
+}
+
+func TestDiffRepos(t *testing.T) {
+	oldRepo := newRuleEngineRepoFromExpressions(t,
+		`a == 1 && b > 2`,
+		`status == "ok"`)
+	newRepo := newRuleEngineRepoFromExpressions(t,
+		`a==1 && b>2`,
+		`status == "failed"`,
+		`hasValue(c)`)
+
+	diff := engine.DiffRepos(oldRepo, newRepo)
+	expected := engine.RuleDiff{Added: []uint{2}, Modified: []uint{1}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("failed diff %+v != %+v", diff, expected)
+	}
+	diff = engine.DiffRepos(newRepo, oldRepo)
+	expected = engine.RuleDiff{Removed: []uint{2}, Modified: []uint{1}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("failed diff %+v != %+v", diff, expected)
+	}
+	if !reflect.DeepEqual(engine.DiffRepos(oldRepo, oldRepo), engine.RuleDiff{}) {
+		t.Fatalf("failed diff of the same repo %+v", engine.DiffRepos(oldRepo, oldRepo))
+	}
+}
+
+func TestMatchEventFirst(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a > 1 && b == "x"`,
+		`a > 2`,
+		`c == 1`)
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		event   map[string]interface{}
+		matches []condition.RuleIdType
+	}{
+		{map[string]interface{}{"a": 5.0, "b": "x"}, []condition.RuleIdType{0, 1}},
+		{map[string]interface{}{"a": 5.0}, []condition.RuleIdType{1}},
+		{map[string]interface{}{"c": 1.0}, []condition.RuleIdType{2}},
+		{map[string]interface{}{"a": 0.0, "c": 2.0}, nil},
+	} {
+		ruleId, ok := genFilter.MatchEventFirst(tc.event)
+		if ok != (len(tc.matches) > 0) {
+			t.Fatalf("failed MatchEventFirst %v for event %v", ok, tc.event)
+		}
+		if !ok {
+			continue
+		}
+		found := false
+		for _, m := range tc.matches {
+			found = found || m == ruleId
+		}
+		if !found {
+			t.Fatalf("failed MatchEventFirst %d not in %v for event %v", ruleId, tc.matches, tc.event)
+		}
+		// The result is deterministic
+		for i := 0; i < 10; i++ {
+			if again, _ := genFilter.MatchEventFirst(tc.event); again != ruleId {
+				t.Fatalf("failed MatchEventFirst %d != %d for event %v", again, ruleId, tc.event)
+			}
+		}
+	}
+}
+
+func TestMatchEvents(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a > 1 && b == "x"`,
+		`a > 2`,
+		`!(c == 1)`,
+		`forSome("items", "item", item.price > 10)`)
+	expectNoErrors(t, repo)
+
+	var events []map[string]interface{}
+	for i := 0; i < 50; i++ {
+		event := map[string]interface{}{"a": float64(i % 5), "b": []string{"x", "y"}[i%2], "c": float64(i % 3)}
+		if i%4 == 0 {
+			event["items"] = []interface{}{
+				map[string]interface{}{"price": float64(i % 20)}, map[string]interface{}{"price": 1.0}}
+		}
+		events = append(events, event)
+	}
+	expected := make([][]condition.RuleIdType, len(events))
+	for i, event := range events {
+		expected[i] = genFilter.MatchEvent(event)
+	}
+
+	check := func(results [][]condition.RuleIdType) string {
+		if len(results) != len(events) {
+			return fmt.Sprintf("got %d results for %d events", len(results), len(events))
+		}
+		for i := range events {
+			got := append([]condition.RuleIdType{}, results[i]...)
+			want := append([]condition.RuleIdType{}, expected[i]...)
+			sort.Slice(got, func(a, b int) bool { return got[a] < got[b] })
+			sort.Slice(want, func(a, b int) bool { return want[a] < want[b] })
+			if !reflect.DeepEqual(got, want) {
+				return fmt.Sprintf("event %v matched %v instead of %v", events[i], got, want)
+			}
+		}
+		return ""
+	}
+	if msg := check(genFilter.MatchEvents(events)); msg != "" {
+		t.Fatalf("failed MatchEvents: %s", msg)
+	}
+	if results := genFilter.MatchEvents(nil); len(results) != 0 {
+		t.Fatalf("failed MatchEvents: %v for no events", results)
+	}
+
+	// The batches may be matched concurrently
+	var wg sync.WaitGroup
+	failures := make([]string, 8)
+	for g := range failures {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20 && failures[g] == ""; i++ {
+				failures[g] = check(genFilter.MatchEvents(events))
+			}
+		}(g)
+	}
+	wg.Wait()
+	for _, msg := range failures {
+		if msg != "" {
+			t.Fatalf("failed concurrent MatchEvents: %s", msg)
+		}
+	}
+}
+
+func TestUnregisterRuleKeepsBuiltEngineDefinitions(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t, `a == 1`, `b == 2`)
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	if err := repo.UnregisterRule(1); err != nil {
+		t.Fatalf("failed UnregisterRule: %s", err)
+	}
+	if _, err := repo.RegisterRuleFromString(`[{"expression": "c == 3"}]`, "json"); err != nil {
+		t.Fatalf("failed RegisterRuleFromString: %s", err)
+	}
+
+	// The engine built before keeps the definitions of its rules
+	rule := genFilter.GetRuleDefinition(1)
+	if rule == nil || rule.Condition.(*condition.ExprCondition).Expr != "b == 2" {
+		t.Fatalf("failed GetRuleDefinition of the unregistered rule: %v", rule)
+	}
+	if genFilter.GetRuleDefinition(2) != nil {
+		t.Fatalf("failed GetRuleDefinition of the rule registered after the engine")
+	}
+
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"a": 1, "b": 2, "c": 3}`), &event); err != nil {
+		t.Fatalf("failed json.Unmarshal: %s", err)
+	}
+	explanations, err := genFilter.MatchEventExplain(event)
+	if err != nil {
+		t.Fatalf("failed MatchEventExplain: %s", err)
+	}
+	if len(explanations) != 2 {
+		t.Fatalf("expected explanations of 2 rules, got %d", len(explanations))
+	}
+	if !explanations[1].Matched || explanations[1].Expression != "b == 2" {
+		t.Fatalf("failed MatchEventExplain of the unregistered rule: %+v", explanations[1])
+	}
+}
+
+func TestUnregisterRule(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t, `a == 1`, `b == 2`, `a == 1 && c == 3`)
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectMatches(t, genFilter, `{"a": 1, "b": 2, "c": 3}`, 0, 1, 2)
+
+	if err := repo.UnregisterRule(1); err != nil {
+		t.Fatalf("failed UnregisterRule: %s", err)
+	}
+	// The engine built before is not affected
+	expectMatches(t, genFilter, `{"a": 1, "b": 2, "c": 3}`, 0, 1, 2)
+
+	genFilter, err = engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	// The ids of the other rules are preserved
+	expectMatches(t, genFilter, `{"a": 1, "b": 2, "c": 3}`, 0, 2)
+	if genFilter.GetRuleDefinition(1) != nil {
+		t.Fatalf("failed GetRuleDefinition for unregistered rule")
+	}
+	// The condition referenced only by the removed rule is no longer evaluated
+	numCatEvals := genFilter.Metrics.NumCatEvals
+	expectMatches(t, genFilter, `{"b": 2}`)
+	if genFilter.Metrics.NumCatEvals != numCatEvals {
+		t.Fatalf("failed to drop the condition of the unregistered rule")
+	}
+	expectNoErrors(t, repo)
+
+	if err := repo.UnregisterRule(1); err == nil {
+		t.Fatalf("expected error unregistering the rule twice")
+	}
+	if err := repo.UnregisterRule(3); err == nil {
+		t.Fatalf("expected error unregistering unknown rule")
+	}
+
+	if id, err := repo.RegisterRuleFromString(`[{"expression": "b == 2"}]`, "json"); err != nil || id != 3 {
+		t.Fatalf("failed RegisterRuleFromString: %d %v", id, err)
+	}
+	diff := engine.DiffRepos(newRuleEngineRepoFromExpressions(t, `a == 1`, `b == 2`, `a == 1 && c == 3`), repo)
+	if !reflect.DeepEqual(diff, engine.RuleDiff{Added: []uint{3}, Removed: []uint{1}}) {
+		t.Fatalf("failed DiffRepos: %+v", diff)
+	}
+}
+
+func TestMatchEventSorted(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{
+		`[{"metadata": {"priority": 1}, "expression": "a > 0"}]`,
+		`[{"metadata": {"priority": 5}, "expression": "a > 1"}]`,
+		`[{"expression": "a > 2"}]`,
+		`[{"metadata": {"priority": 5}, "expression": "a > 3"}]`,
+		`[{"metadata": {"priority": -1}, "expression": "a > 4"}]`,
+	} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	if _, err := repo.RegisterRuleFromString("- metadata:\n    priority: 5\n  expression: a > 5\n", "yaml"); err != nil {
+		t.Fatalf("failed RegisterRuleFromString: %s", err)
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	if genFilter.GetRuleDefinition(1).Priority != 5 || genFilter.GetRuleDefinition(5).Priority != 5 {
+		t.Fatalf("failed priority metadata parsing")
+	}
+
+	for _, tc := range []struct {
+		event   map[string]interface{}
+		matches []condition.RuleIdType
+	}{
+		// The ties are ordered by the registration order
+		{map[string]interface{}{"a": 10.0}, []condition.RuleIdType{1, 3, 5, 0, 2, 4}},
+		{map[string]interface{}{"a": 3.0}, []condition.RuleIdType{1, 0, 2}},
+		{map[string]interface{}{"a": 0.0}, []condition.RuleIdType{}},
+	} {
+		if matches := genFilter.MatchEventSorted(tc.event); !reflect.DeepEqual(matches, tc.matches) {
+			t.Fatalf("failed MatchEventSorted %v != %v for event %v", matches, tc.matches, tc.event)
+		}
+	}
+
+	if _, err := repo.RegisterRuleFromString(`[{"metadata": {"priority": 1.5}, "expression": "a > 0"}]`, "json"); err == nil {
+		t.Fatalf("expected error for a non-integer priority")
+	}
+	if _, err := repo.RegisterRuleFromString(`[{"metadata": {"priority": "high"}, "expression": "a > 0"}]`, "json"); err == nil {
+		t.Fatalf("expected error for a non-integer priority")
+	}
+}
+
+func TestSetRuleEnabled(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{
+		`[{"expression": "a == 1"}]`,
+		`[{"metadata": {"enabled": true}, "expression": "a == 1 && b == 2"}]`,
+		`[{"metadata": {"enabled": false}, "expression": "b == 2"}]`,
+	} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	event := `{"a": 1, "b": 2}`
+	expectMatches(t, genFilter, event, 0, 1)
+
+	if err := genFilter.SetRuleEnabled(0, false); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	if genFilter.RuleEnabled(0) {
+		t.Fatalf("failed RuleEnabled for disabled rule")
+	}
+	expectMatches(t, genFilter, event, 1)
+	if results := genFilter.MatchEvents([]map[string]interface{}{{"a": 1.0}}); len(results[0]) != 0 {
+		t.Fatalf("failed MatchEvents matched disabled rule: %v", results)
+	}
+	if ruleId, ok := genFilter.MatchEventFirst(map[string]interface{}{"a": 1.0}); ok {
+		t.Fatalf("failed MatchEventFirst matched disabled rule %d", ruleId)
+	}
+
+	if err := genFilter.SetRuleEnabled(0, true); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	if err := genFilter.SetRuleEnabled(2, true); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	expectMatches(t, genFilter, event, 0, 1, 2)
+	// Setting the same state again has no effect
+	_ = genFilter.SetRuleEnabled(1, true)
+	_ = genFilter.SetRuleEnabled(2, false)
+	_ = genFilter.SetRuleEnabled(2, false)
+	expectMatches(t, genFilter, event, 0, 1)
+	_ = genFilter.SetRuleEnabled(2, true)
+	expectMatches(t, genFilter, event, 0, 1, 2)
+
+	if err := genFilter.SetRuleEnabled(3, true); err == nil {
+		t.Fatalf("expected error for unknown rule")
+	}
+	if _, err := repo.RegisterRuleFromString(`[{"metadata": {"enabled": "no"}, "expression": "a == 1"}]`, "json"); err == nil {
+		t.Fatalf("expected error for a non-boolean enabled flag")
+	}
+}
+
+func TestMatchEventForTenant(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{
+		`[{"metadata": {"tenant": "a"}, "expression": "amount > 100"}]`,
+		`[{"metadata": {"tenant": "b"}, "expression": "amount > 100"}]`,
+		`[{"metadata": {"tenant": "a"}, "expression": "amount > 50 && country == \"US\""}]`,
+		`[{"expression": "amount > 0"}]`,
+		`[{"metadata": {"tenant": "b", "enabled": false}, "expression": "country == \"US\""}]`,
+		`[{"metadata": {"tenant": "b"}, "expression": "!(country == \"US\")"}]`,
+	} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	if genFilter.GetRuleDefinition(1).Tenant != "b" || genFilter.GetRuleDefinition(3).Tenant != "" {
+		t.Fatalf("failed tenant metadata parsing")
+	}
+
+	for _, tc := range []struct {
+		event   string
+		tenant  string
+		matches []condition.RuleIdType
+	}{
+		{`{"amount": 150, "country": "US"}`, "a", []condition.RuleIdType{0, 2}},
+		{`{"amount": 150, "country": "US"}`, "b", []condition.RuleIdType{1}},
+		{`{"amount": 60, "country": "US"}`, "a", []condition.RuleIdType{2}},
+		{`{"amount": 60, "country": "US"}`, "b", []condition.RuleIdType{}},
+		{`{"amount": 60}`, "a", []condition.RuleIdType{}},
+		{`{"amount": 60}`, "b", []condition.RuleIdType{5}},
+		{`{"amount": 150, "country": "US"}`, "c", []condition.RuleIdType{}},
+		{`{"amount": 150, "country": "US"}`, "", []condition.RuleIdType{}},
+	} {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed to parse %s: %s", tc.event, err)
+		}
+		matches := genFilter.MatchEventForTenant(event, tc.tenant)
+		sortRuleIds(matches)
+		if len(matches) != len(tc.matches) || len(matches) > 0 && !reflect.DeepEqual(matches, tc.matches) {
+			t.Fatalf("failed MatchEventForTenant %v != %v for tenant %q and event %s",
+				matches, tc.matches, tc.tenant, tc.event)
+		}
+	}
+	// MatchEvent still matches the rules of all the tenants
+	expectMatches(t, genFilter, `{"amount": 150, "country": "US"}`, 0, 1, 2, 3)
+
+	if err := genFilter.SetRuleEnabled(4, true); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	matches := genFilter.MatchEventForTenant(map[string]interface{}{"country": "US"}, "b")
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{4}) {
+		t.Fatalf("failed MatchEventForTenant %v for the enabled rule", matches)
+	}
+
+	if _, err := repo.RegisterRuleFromString(`[{"metadata": {"tenant": 1}, "expression": "a > 0"}]`, "json"); err == nil {
+		t.Fatalf("expected error for a non-string tenant")
+	}
+}
+
+func TestDefaultRule(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{
+		`[{"expression": "route == \"eu\""}]`,
+		`[{"expression": "route == \"us\""}]`,
+		`[{"metadata": {"default": true}, "expression": "!(route == \"none\")"}]`,
+		`[{"metadata": {"enabled": false}, "expression": "route == \"asia\""}]`,
+		`[{"metadata": {"default": true}, "expression": "priority > 5"}]`,
+	} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	if !genFilter.GetRuleDefinition(2).Default || genFilter.GetRuleDefinition(0).Default {
+		t.Fatalf("failed default metadata parsing")
+	}
+
+	expectMatches(t, genFilter, `{"route": "eu", "priority": 9}`, 0)
+	expectMatches(t, genFilter, `{"route": "us"}`, 1)
+	// No other rule matches, the matching default rules do
+	expectMatches(t, genFilter, `{"route": "mars"}`, 2)
+	expectMatches(t, genFilter, `{"route": "mars", "priority": 9}`, 2, 4)
+	expectMatches(t, genFilter, `{"route": "none", "priority": 9}`, 4)
+	expectMatches(t, genFilter, `{"route": "none"}`)
+	// The disabled rules do not suppress the default rules
+	expectMatches(t, genFilter, `{"route": "asia"}`, 2)
+
+	first, ok := genFilter.MatchEventFirst(map[string]interface{}{"route": "mars"})
+	if !ok || first != 2 {
+		t.Fatalf("failed MatchEventFirst %d %v for the default rule", first, ok)
+	}
+	first, ok = genFilter.MatchEventFirst(map[string]interface{}{"route": "eu"})
+	if !ok || first != 0 {
+		t.Fatalf("failed MatchEventFirst %d %v suppressing the default rule", first, ok)
+	}
+	var matches []condition.RuleIdType
+	genFilter.MatchEventFunc(map[string]interface{}{"route": "us"}, func(ruleId condition.RuleIdType) bool {
+		matches = append(matches, ruleId)
+		return true
+	})
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{1}) {
+		t.Fatalf("failed MatchEventFunc %v suppressing the default rule", matches)
+	}
+
+	if err := genFilter.SetRuleEnabled(3, true); err != nil {
+		t.Fatalf("failed SetRuleEnabled: %s", err)
+	}
+	expectMatches(t, genFilter, `{"route": "asia"}`, 3)
+
+	if _, err := repo.RegisterRuleFromString(`[{"metadata": {"default": "yes"}, "expression": "a > 0"}]`, "json"); err == nil {
+		t.Fatalf("expected error for a non-boolean default")
+	}
+}
+
+func TestMatchEventGrouped(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{
+		`[{"metadata": {"severity": "high"}, "expression": "amount > 1000"}]`,
+		`[{"metadata": {"severity": "low"}, "expression": "amount > 10"}]`,
+		`[{"metadata": {"severity": "high"}, "expression": "country == \"XX\""}]`,
+		`[{"expression": "amount > 0"}]`,
+		`[{"metadata": {"severity": 3}, "expression": "amount > 500"}]`,
+	} {
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		event    string
+		expected map[string][]condition.RuleIdType
+	}{
+		{`{"amount": 5000, "country": "XX"}`,
+			map[string][]condition.RuleIdType{"high": {0, 2}, "low": {1}, "": {3}, "3": {4}}},
+		{`{"amount": 50}`, map[string][]condition.RuleIdType{"low": {1}, "": {3}}},
+		{`{"country": "XX"}`, map[string][]condition.RuleIdType{"high": {2}}},
+		{`{"country": "US"}`, map[string][]condition.RuleIdType{}},
+	} {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.event), &event); err != nil {
+			t.Fatalf("failed to parse %s: %s", tc.event, err)
+		}
+		if groups := genFilter.MatchEventGrouped(event, "severity"); !reflect.DeepEqual(groups, tc.expected) {
+			t.Fatalf("failed MatchEventGrouped %v != %v for event %s", groups, tc.expected, tc.event)
+		}
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`isBusinessDay(day) && a == 1`,
+		`isBusinessDay(day) && b == 2`,
+		`!isBusinessDay(day)`,
+		`double(x) > 10`,
+		`double(2) == x`)
+	calls := 0
+	repo.RegisterFunction("isBusinessDay", func(args []condition.Operand) condition.Operand {
+		calls++
+		s := args[0].Convert(condition.StringOperandKind)
+		if s.GetKind() != condition.StringOperandKind {
+			return s
+		}
+		day := string(s.(condition.StringOperand))
+		return condition.NewBooleanOperand(day != "sat" && day != "sun")
+	})
+	repo.RegisterFunction("double", func(args []condition.Operand) condition.Operand {
+		f := args[0].Convert(condition.FloatOperandKind)
+		if f.GetKind() != condition.FloatOperandKind {
+			return f
+		}
+		return condition.NewFloatOperand(2 * float64(f.(condition.FloatOperand)))
+	})
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"day": "mon", "a": 1, "b": 2}`, 0, 1)
+	// The identical calls are evaluated once
+	if calls != 1 {
+		t.Fatalf("failed to dedupe the identical calls: %d calls", calls)
+	}
+	expectMatches(t, genFilter, `{"day": "sun", "a": 1}`, 2)
+	// The negated condition is true when the day is missing
+	expectMatches(t, genFilter, `{"x": 6}`, 2, 3)
+	expectMatches(t, genFilter, `{"x": 4}`, 2, 4)
+	// The function is not called for undefined arguments
+	calls = 0
+	expectMatches(t, genFilter, `{"day": null, "a": 1}`, 2)
+	if calls != 0 {
+		t.Fatalf("failed called with undefined argument")
+	}
+
+	expectRuleEngineError(t, `isWeekend(day)`)
+}
+
+func TestNamedClauses(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for _, rule := range []string{`
+- when:
+    highValue: amount > 1000 && currency == "EUR"
+  expression: clause("highValue") && region == "eu"
+`, `
+- expression: clause("highValue") && region == "us"
+`, `
+- expression: '!clause("highValue")'
+`} {
+		if _, err := repo.RegisterRuleFromString(rule, "yaml"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"amount": 2000, "currency": "EUR", "region": "eu"}`, 0)
+	expectMatches(t, genFilter, `{"amount": 2000, "currency": "EUR", "region": "us"}`, 1)
+	expectMatches(t, genFilter, `{"amount": 10, "currency": "EUR", "region": "us"}`, 2)
+
+	// Both rules reference the single category of the clause
+	var event interface{}
+	if err := json.Unmarshal([]byte(`{"amount": 2000, "currency": "EUR", "region": "eu"}`), &event); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	explanations, err := genFilter.MatchEventExplain(event)
+	if err != nil {
+		t.Fatalf("failed MatchEventExplain: %s", err)
+	}
+	if len(explanations[1].TrueCategories) != 1 {
+		t.Fatalf("failed clause categories: %v", explanations[1].TrueCategories)
+	}
+	shared := explanations[1].TrueCategories[0]
+	found := false
+	for _, cat := range explanations[0].TrueCategories {
+		found = found || cat == shared
+	}
+	if !found {
+		t.Fatalf("failed to share clause category %d: %v", shared, explanations[0].TrueCategories)
+	}
+}
+
+func TestNamedClausesErrors(t *testing.T) {
+	for _, rules := range [][]string{
+		{`[{"expression": "clause(\"missing\")"}]`},
+		{`[{"when": {"c": "a == 1"}, "expression": "clause(c)"}]`},
+		{`[{"when": {"c": "clause(\"c\") || a == 1"}, "expression": "clause(\"c\")"}]`},
+		{`[{"when": {"c": "a == 1"}, "expression": "clause(\"c\")"}]`,
+			`[{"when": {"c": "a == 2"}, "expression": "b == 1"}]`},
+	} {
+		repo := engine.NewRuleEngineRepo()
+		for _, rule := range rules {
+			if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+				t.Fatalf("failed RegisterRuleFromString: %s", err)
+			}
+		}
+		if _, err := engine.NewRuleEngine(repo); err == nil {
+			t.Fatalf("expected NewRuleEngine error for %v", rules)
+		}
+	}
+}
+
+func TestMatchEventStream(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`customer.tier == "gold" && amount > 100`,
+		`forSome("orders", "o", o.total > 100 && o.status == "open")`,
+		`!forAll("orders", "o", o.status == "open")`,
+		`length(tags) == 2`,
+		`forSomeEntry("limits", "k", "v", v > 10)`,
+		`missing == 1`)
+	expectNoErrors(t, repo)
+
+	for _, event := range []string{
+		`{"customer": {"tier": "gold", "name": "Frank", "history": [1, 2, 3]}, "amount": 150, "blob": {"a": [{"b": 1}]}}`,
+		`{"orders": [{"total": 150, "status": "open", "items": [{"sku": "x"}]}, {"total": 10, "note": "n"}]}`,
+		`{"orders": [], "tags": ["a", "b"], "ignored": [[1, 2], {"x": null}]}`,
+		`{"limits": {"cpu": 5, "mem": {"nested": true}, "disk": 20}, "amount": null}`,
+		`{"customer": "gold", "orders": {"total": 150}, "tags": "ab"}`,
+		`[1, 2, 3]`,
+		`"scalar"`,
+		`{}`,
+	} {
+		matches := matchJsonEvent(t, genFilter, event)
+		streamed, err := genFilter.MatchEventStream(strings.NewReader(event))
+		if err != nil {
+			t.Fatalf("failed MatchEventStream for event %s: %s", event, err)
+		}
+		sortRuleIds(matches)
+		sortRuleIds(streamed)
+		if !reflect.DeepEqual(matches, streamed) {
+			t.Fatalf("failed MatchEventStream %v != %v for event %s", streamed, matches, event)
+		}
+	}
+
+	expected := []condition.RuleIdType{0, 1}
+	streamed, err := genFilter.MatchEventStream(strings.NewReader(
+		`{"amount": 101, "orders": [{"total": 101, "status": "open"}], "customer": {"tier": "gold"}}`))
+	if err != nil || !reflect.DeepEqual(streamed, expected) {
+		t.Fatalf("failed MatchEventStream %v != %v: %v", streamed, expected, err)
+	}
+
+	for _, event := range []string{``, `{"amount": `, `{"amount": 1]`, `{"customer": {"tier": "gold"}`} {
+		if _, err := genFilter.MatchEventStream(strings.NewReader(event)); err == nil {
+			t.Fatalf("expected MatchEventStream error for event %q", event)
+		}
+	}
+}
+
+type structTestBase struct {
+	Id      int    `json:"id"`
+	Country string `json:"country,omitempty"`
+}
+
+type structTestOrder struct {
+	Total  float64 `json:"total"`
+	Status string  `json:"status"`
+	Note   *string `json:"note"`
+}
+
+type structTestEvent struct {
+	structTestBase
+	Customer struct {
+		Tier  string `json:"tier"`
+		Since time.Time
+	} `json:"customer"`
+	Amount  int32              `json:"amount"`
+	Orders  []structTestOrder  `json:"orders"`
+	Tags    []string           `json:"tags"`
+	Limits  map[string]float64 `json:"limits"`
+	Secret  string             `json:"-"`
+	private int
+}
+
+func TestMatchStruct(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`customer.tier == "gold" && amount > 100`,
+		`forSome("orders", "o", o.total > 100 && o.status == "open")`,
+		`!forAll("orders", "o", o.status == "open")`,
+		`length(tags) == 2`,
+		`forSomeEntry("limits", "k", "v", v > 10)`,
+		`id == 7 && country == "NL"`,
+		`customer.Since == "2024-01-02T03:04:05Z"`,
+		`forSome("orders", "o", o.note == "rush")`,
+		`Secret == "x" || private == 1`)
+	expectNoErrors(t, repo)
+
+	rush := "rush"
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := []structTestEvent{
+		{Amount: 150, Secret: "x", private: 1},
+		{Orders: []structTestOrder{{Total: 150, Status: "open", Note: &rush}, {Total: 10}}, Tags: []string{"a", "b"}},
+		{Orders: []structTestOrder{}, Limits: map[string]float64{"cpu": 5, "disk": 20}},
+		{structTestBase: structTestBase{Id: 7, Country: "NL"}, Limits: map[string]float64{}},
+		{},
+	}
+	events[0].Customer.Tier = "gold"
+	events[0].Customer.Since = since
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %s", event, err)
+		}
+		matches := matchJsonEvent(t, genFilter, string(data))
+		structMatches := genFilter.MatchStruct(&event)
+		sortRuleIds(matches)
+		sortRuleIds(structMatches)
+		if !reflect.DeepEqual(matches, structMatches) {
+			t.Fatalf("failed MatchStruct %v != %v for event %s", structMatches, matches, data)
+		}
+	}
+
+	expected := []condition.RuleIdType{0, 2, 6}
+	matches := genFilter.MatchStruct(events[0])
+	sortRuleIds(matches)
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("failed MatchStruct %v != %v", matches, expected)
+	}
+	expected = matchJsonEvent(t, genFilter, `null`)
+	if matches := genFilter.MatchStruct((*structTestEvent)(nil)); !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("failed MatchStruct %v != %v for nil event", matches, expected)
+	}
+}
+
+func TestMatchRecord(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`amount > 100`,
+		`country == "US" && qty >= 2`,
+		`!(country == "US")`,
+		`code == "007"`,
+		`hasValue(note)`)
+	expectNoErrors(t, repo)
+
+	header := []string{"id", "amount", "country", "qty", "code", "note"}
+	for _, tc := range []struct {
+		row     []string
+		matches []condition.RuleIdType
+	}{
+		{[]string{"1", "150.5", "US", "2", "x", "n"}, []condition.RuleIdType{0, 1, 4}},
+		{[]string{"2", "99", "US", "10"}, []condition.RuleIdType{1}},
+		// The numeric strings are compared as numbers
+		{[]string{"3", "1e3", "CA", "1", "007"}, []condition.RuleIdType{0, 2}},
+		{[]string{"4", "abc", "", "", "", ""}, []condition.RuleIdType{2}},
+		{[]string{"5"}, []condition.RuleIdType{2}},
+	} {
+		matches := genFilter.MatchRecord(header, tc.row)
+		sortRuleIds(matches)
+		if len(matches) != len(tc.matches) || len(matches) > 0 && !reflect.DeepEqual(matches, tc.matches) {
+			t.Fatalf("failed MatchRecord %v != %v for row %v", matches, tc.matches, tc.row)
+		}
+	}
+
+	// The columns missing from the header are undefined too
+	matches := genFilter.MatchRecord([]string{"amount", "other"}, []string{"101", "US"})
+	sortRuleIds(matches)
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{0, 2}) {
+		t.Fatalf("failed MatchRecord %v for the missing columns", matches)
+	}
+}
+
+func TestFlatDottedKeys(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`user.profile.age > 18`,
+		`forSome("user.orders", "o", o.total > 100)`)
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		event   string
+		matches []condition.RuleIdType
+	}{
+		{`{"user": {"profile": {"age": 30}}}`, []condition.RuleIdType{0}},
+		// The dotted keys resolve as the nested paths
+		{`{"user.profile.age": 30}`, []condition.RuleIdType{0}},
+		{`{"user": {"profile.age": 30}}`, []condition.RuleIdType{0}},
+		{`{"user.profile": {"age": 10}}`, nil},
+		{`{"user.orders": [{"total": 50}, {"total": 150}]}`, []condition.RuleIdType{1}},
+	} {
+		matches := matchJsonEvent(t, genFilter, tc.event)
+		streamed, err := genFilter.MatchEventStream(strings.NewReader(tc.event))
+		if err != nil {
+			t.Fatalf("failed MatchEventStream for event %s: %s", tc.event, err)
+		}
+		sortRuleIds(matches)
+		sortRuleIds(streamed)
+		if len(matches) != len(tc.matches) || len(matches) > 0 && !reflect.DeepEqual(matches, tc.matches) {
+			t.Fatalf("failed MatchEvent %v != %v for event %s", matches, tc.matches, tc.event)
+		}
+		if len(streamed) != len(tc.matches) || len(streamed) > 0 && !reflect.DeepEqual(streamed, tc.matches) {
+			t.Fatalf("failed MatchEventStream %v != %v for event %s", streamed, tc.matches, tc.event)
+		}
+	}
+}
+
+func TestMatchEventWithStats(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`amount > 100 && country == "US"`,
+		`forSome("orders", "o", o.total > 10)`,
+		`count("items", "i", i.qty > 1) >= 2`)
+	expectNoErrors(t, repo)
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"amount": 150, "country": "US",
+		"orders": [{"total": 1}, {"total": 2}, {"total": 3}], "items": [{"qty": 2}, {"qty": 3}]}`), &event); err != nil {
+		t.Fatalf("failed to parse the event: %s", err)
+	}
+	matches, stats := genFilter.MatchEventWithStats(event)
+	sortRuleIds(matches)
+	if !reflect.DeepEqual(matches, []condition.RuleIdType{0, 2}) {
+		t.Fatalf("failed MatchEventWithStats matches %v", matches)
+	}
+	if stats.NumCatEvals == 0 || stats.NumIterations != 5 || stats.NumAttributeAccesses < 7 {
+		t.Fatalf("failed MatchEventWithStats stats %+v", stats)
+	}
+
+	// The stats are per event rather than cumulative
+	matches, stats2 := genFilter.MatchEventWithStats(event)
+	if len(matches) != 2 || stats2 != stats {
+		t.Fatalf("failed MatchEventWithStats stats %+v != %+v", stats2, stats)
+	}
+	matches, stats = genFilter.MatchEventWithStats(map[string]interface{}{"amount": 50.0})
+	if len(matches) != 0 || stats.NumCatEvals == 0 || stats.NumIterations != 0 || stats.NumAttributeAccesses == 0 ||
+		stats.NumAttributeAccesses >= stats2.NumAttributeAccesses {
+		t.Fatalf("failed MatchEventWithStats stats %+v for the small event", stats)
+	}
+	if genFilter.Metrics.NumCatEvals < 2*stats2.NumCatEvals+stats.NumCatEvals {
+		t.Fatalf("failed MatchEventWithStats to count NumCatEvals in the engine metrics")
+	}
+}
+
+func TestNotEqualToConstLookup(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	for i := 0; i < 50; i++ {
+		rule := fmt.Sprintf(`[{"expression": "status != \"s%d\""}]`, i)
+		if _, err := repo.RegisterRuleFromString(rule, "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	// All the not-equal categories are resolved by the single lookup of the attribute value
+	for _, tc := range []struct {
+		event      map[string]interface{}
+		numMatches int
+		numEvals   uint64
+	}{
+		{map[string]interface{}{"status": "s7"}, 49, 1},
+		{map[string]interface{}{"status": "other"}, 50, 1},
+		{map[string]interface{}{"status": 7.0}, 50, 1},
+		// The missing attribute is not equal to any of the constants without being evaluated
+		{map[string]interface{}{}, 50, 0},
+	} {
+		matches, stats := genFilter.MatchEventWithStats(tc.event)
+		if len(matches) != tc.numMatches || stats.NumCatEvals != tc.numEvals || stats.NumAttributeAccesses != tc.numEvals {
+			t.Fatalf("failed not-equal lookup %d matches and stats %+v for event %v", len(matches), stats, tc.event)
+		}
+	}
+	for _, ruleId := range genFilter.MatchEvent(map[string]interface{}{"status": "s7"}) {
+		if ruleId == 7 {
+			t.Fatalf("failed not-equal lookup matched the rule of the equal constant")
+		}
+	}
+}
+
+func TestEngineStats(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`a == 1`,
+		`a == 1 && b == 2`,
+		`!(c == 3)`,
+		`a == 1 && b == 2 && d == 4`,
+		`a == 1 && b == 2 && e == 5`)
+	expectNoErrors(t, repo)
+
+	// The AND of a == 1 and b == 2 shared by the last three rules is merged into the filter of the second rule
+	expected := engine.EngineStats{
+		NumRules:             5,
+		NumEvalCategories:    5,
+		NumCatSetFilters:     5,
+		NumNegatedCategories: 1,
+		BuilderMetrics:       cateng.BuilderMetrics{AndSetsRemoved: 2},
+	}
+	if stats := genFilter.Stats(); stats != expected {
+		t.Fatalf("failed Stats %+v != %+v", stats, expected)
+	}
+}
+
+func TestRegexpCache(t *testing.T) {
+	var exprs []string
+	for i := 0; i < 100; i++ {
+		exprs = append(exprs, fmt.Sprintf(`regexpMatch("^ord-[0-9]+$", id) && n == %d`, i))
+	}
+	exprs = append(exprs, `regexpMatchAny("^ord-[0-9]+$", "ids", "i")`, `regexpMatch("^inv-", id)`)
+	repo, genFilter := newRuleEngineFromExpressions(t, exprs...)
+	expectNoErrors(t, repo)
+
+	// The rules sharing the pattern share the compiled regexp
+	if stats := genFilter.Stats(); stats.NumRegexps != 2 {
+		t.Fatalf("failed NumRegexps %d != 2", stats.NumRegexps)
+	}
+	expectMatches(t, genFilter, `{"id": "ord-17", "n": 42}`, 42)
+	expectMatches(t, genFilter, `{"ids": ["inv-1", "ord-2"]}`, 100)
+	// The same argument matched against different patterns is not shared
+	expectMatches(t, genFilter, `{"id": "inv-17", "n": 42}`, 101)
+}
+
+func sortRuleIds(ids []condition.RuleIdType) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+const testEventSchema = `{
+  "type": "object",
+  "properties": {
+    "amount": {"type": "number"},
+    "count": {"type": "integer"},
+    "country": {"type": "string"},
+    "zip": {"type": ["string", "null"]},
+    "active": {"type": "boolean"},
+    "customer": {"type": "object", "properties": {"tier": {"type": "string"}, "age": {"type": "integer"}}},
+    "orders": {"type": "array", "items": {"type": "object", "properties": {"total": {"type": "number"}, "sku": {"type": "string"}}}}
+  }
+}`
+
+func TestSchemaValidation(t *testing.T) {
+	schema, err := engine.ParseSchema([]byte(testEventSchema))
+	if err != nil {
+		t.Fatalf("failed ParseSchema: %s", err)
+	}
+	repo := newRuleEngineRepoFromExpressions(t,
+		`amount > 100 && country == "US"`,
+		`count * 2 >= amount`,
+		`customer.age >= 18 && customer.tier != "gold"`,
+		`forSome("orders", "o", o.total > 100 && o.sku == "x")`,
+		`zip == "94103" || undeclared == 1`,
+		`country > "M"`)
+	genFilter, err := engine.NewRuleEngine(repo, engine.WithSchema(schema))
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+	expectMatches(t, genFilter, `{"amount": 150, "country": "US", "count": 100}`, 0, 1, 5)
+
+	for _, tc := range []struct {
+		expr  string
+		error string
+	}{
+		{`country > 100`, "country of type string is compared with a number"},
+		{`amount == "100"`, "amount of type number is compared with a string"},
+		{`customer.tier * 2 > 1`, "customer.tier of type string is used in arithmetic"},
+		{`customer.age < amount && 10 <= customer.tier`, "customer.tier of type string is compared with a number"},
+		{`active == 1`, "active of type boolean is compared with a number"},
+		{`forSome("orders", "o", o.sku >= 5)`, "o.sku of type string is compared with a number"},
+		{`country == amount * 3`, "country of type string is compared with a number"},
+	} {
+		repo := newRuleEngineRepoFromExpressions(t, `amount > 1`, tc.expr)
+		_, err := engine.NewRuleEngine(repo, engine.WithSchema(schema))
+		if err == nil || !strings.Contains(err.Error(), "rule 1") || !strings.Contains(err.Error(), tc.error) {
+			t.Fatalf("expected schema error %q for %s, got %v", tc.error, tc.expr, err)
+		}
+		if repo.GetAppCtx().NumErrors() != 1 {
+			t.Fatalf("expected one error for %s, got %d", tc.expr, repo.GetAppCtx().NumErrors())
+		}
+	}
+
+	// The rules are not validated without the schema
+	_, genFilter = newRuleEngineFromExpressions(t, `country > 100`)
+	expectMatches(t, genFilter, `{"country": 200}`, 0)
+
+	if _, err := engine.ParseSchema([]byte(`{"type": 1}`)); err == nil {
+		t.Fatalf("expected ParseSchema error")
+	}
+}
+
+func TestRuleMacros(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := `
+macros:
+  isAdult: age >= 18
+  isLocalAdult: '@isAdult && country == "US"'
+rules:
+  - metadata:
+      name: us adult
+    expression: '@isAdult && country == "US"'
+  - expression: '@isLocalAdult || handle == "@isAdult"'
+  - expression: '!@isAdult'
+`
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatalf("failed WriteFile: %s", err)
+	}
+	repo := engine.NewRuleEngineRepo()
+	if _, err := repo.RegisterRulesFromFile(path); err != nil {
+		t.Fatalf("failed RegisterRulesFromFile: %s", err)
+	}
+	if _, err := repo.RegisterRuleFromString(
+		`{"macros": {"isAdult": "age >= 21"}, "rules": [{"expression": "@isAdult"}]}`, "json"); err != nil {
+		t.Fatalf("failed RegisterRuleFromString: %s", err)
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"age": 30, "country": "US"}`, 0, 1, 3)
+	expectMatches(t, genFilter, `{"age": 19, "country": "US"}`, 0, 1)
+	expectMatches(t, genFilter, `{"age": 30, "country": "CA"}`, 3)
+	expectMatches(t, genFilter, `{"age": 10, "country": "US"}`, 2)
+	// The references in the string literals are not expanded
+	expectMatches(t, genFilter, `{"age": 10, "handle": "@isAdult"}`, 1, 2)
+
+	if rule := genFilter.GetRuleDefinition(0); rule.Metadata["name"] != "us adult" {
+		t.Fatalf("failed rule metadata %v", rule.Metadata)
+	}
+}
+
+func TestRuleMacrosErrors(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules": [{"expression": "@missing && a == 1"}]}`,
+		`{"macros": {"a": "@b || x == 1", "b": "@a"}, "rules": [{"expression": "@a"}]}`,
+		`{"macros": {"a": "@a"}, "rules": [{"expression": "b == 1"}, {"expression": "@a"}]}`,
+		`{"macros": {"a": "x == 1"}, "rules": [{"expression": "@ && b == 1"}]}`,
+	} {
+		repo := engine.NewRuleEngineRepo()
+		if _, err := repo.RegisterRuleFromString(rules, "json"); err == nil {
+			t.Fatalf("expected RegisterRuleFromString error for %s", rules)
+		}
+	}
+}
+
+func TestCompilePredicate(t *testing.T) {
+	for _, tc := range []struct {
+		rule   string
+		format string
+	}{
+		{`amount > 100 && country == "US"`, "expr"},
+		{`[{"expression": "amount > 100 && country == \"US\""}]`, "json"},
+		{"- expression: 'amount > 100 && country == \"US\"'", "yaml"},
+	} {
+		match, err := engine.CompilePredicate(tc.rule, tc.format)
+		if err != nil {
+			t.Fatalf("failed CompilePredicate: %s", err)
+		}
+		for _, event := range []struct {
+			value    map[string]interface{}
+			expected bool
+		}{
+			{map[string]interface{}{"amount": 150, "country": "US"}, true},
+			{map[string]interface{}{"amount": 50, "country": "US"}, false},
+			{map[string]interface{}{"amount": 150, "country": "CA"}, false},
+			{map[string]interface{}{"other": 1}, false},
+		} {
+			if match(event.value) != event.expected {
+				t.Fatalf("failed predicate %s for event %v", tc.rule, event.value)
+			}
+		}
+	}
+
+	// The predicate can be applied concurrently
+	match, err := engine.CompilePredicate(`amount > 100`, "expr")
+	if err != nil {
+		t.Fatalf("failed CompilePredicate: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if !match(map[string]interface{}{"amount": 101 + i*j}) {
+					t.Errorf("failed concurrent predicate")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, tc := range []struct {
+		rule   string
+		format string
+	}{
+		{`amount >`, "expr"},
+		{`unknownFunc(a)`, "expr"},
+		{`amount > 1`, "xml"},
+	} {
+		if _, err := engine.CompilePredicate(tc.rule, tc.format); err == nil {
+			t.Fatalf("expected CompilePredicate error for %s", tc.rule)
+		}
+	}
+}
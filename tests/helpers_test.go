@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/engine"
+	"testing"
+)
+
+// newRuleEngineRepoFromExpressions registers one rule per expression.
+// Rule ids are assigned in the order of the expressions.
+func newRuleEngineRepoFromExpressions(t *testing.T, expressions ...string) *engine.RuleEngineRepo {
+	t.Helper()
+	repo := engine.NewRuleEngineRepo()
+	for _, expr := range expressions {
+		rule, err := json.Marshal([]engine.ExternalRule{{Expression: expr}})
+		if err != nil {
+			t.Fatalf("failed Marshal: %s", err)
+		}
+		if _, err := repo.RegisterRuleFromString(string(rule), "json"); err != nil {
+			t.Fatalf("failed RegisterRuleFromString: %s", err)
+		}
+	}
+	return repo
+}
+
+// newRuleEngineFromExpressions registers one rule per expression and builds the rule engine.
+func newRuleEngineFromExpressions(t *testing.T, expressions ...string) (*engine.RuleEngineRepo, *engine.RuleEngine) {
+	t.Helper()
+	repo := newRuleEngineRepoFromExpressions(t, expressions...)
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	return repo, genFilter
+}
+
+// expectRuleEngineError checks that building the rule engine for the expressions fails.
+func expectRuleEngineError(t *testing.T, expressions ...string) {
+	t.Helper()
+	repo := newRuleEngineRepoFromExpressions(t, expressions...)
+	if _, err := engine.NewRuleEngine(repo); err == nil {
+		t.Fatalf("expected NewRuleEngine error for %v", expressions)
+	}
+}
+
+// matchJsonEvent decodes the JSON event and matches it against the rule engine.
+func matchJsonEvent(t *testing.T, genFilter *engine.RuleEngine, event string) []condition.RuleIdType {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(event), &v); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	return genFilter.MatchEvent(v)
+}
+
+// expectMatches matches the event and checks that exactly the expected rules matched.
+func expectMatches(t *testing.T, genFilter *engine.RuleEngine, event string, expected ...condition.RuleIdType) {
+	t.Helper()
+	matches := matchJsonEvent(t, genFilter, event)
+	if len(matches) != len(expected) {
+		t.Fatalf("failed matches %v != %v for event %s", matches, expected, event)
+	}
+	matched := make(map[condition.RuleIdType]bool)
+	for _, m := range matches {
+		matched[m] = true
+	}
+	for _, e := range expected {
+		if !matched[e] {
+			t.Fatalf("failed matches %v != %v for event %s", matches, expected, event)
+		}
+	}
+}
+
+func expectNoErrors(t *testing.T, repo *engine.RuleEngineRepo) {
+	t.Helper()
+	if repo.GetAppCtx().NumErrors() > 0 {
+		repo.GetAppCtx().PrintErrors()
+		t.Fatalf("failed due to %d errors", repo.GetAppCtx().NumErrors())
+	}
+}
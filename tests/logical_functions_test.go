@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMajority(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`majority(a > 10, b == "x", c < 0)`,
+		`majority(a > 10, b == "x", c < 0, d)`)
+	expectNoErrors(t, repo)
+
+	// Clear majority
+	expectMatches(t, genFilter, `{"a": 11, "b": "x", "c": 1, "d": true}`, 0, 1)
+	expectMatches(t, genFilter, `{"a": 1, "b": "y", "c": -1, "d": true}`)
+
+	// Tie is not a majority
+	expectMatches(t, genFilter, `{"a": 11, "b": "x", "c": 1, "d": false}`, 0)
+	expectMatches(t, genFilter, `{"a": 11, "b": "y", "c": -1, "d": false}`, 0)
+	expectMatches(t, genFilter, `{"a": 11, "b": "y"}`)
+
+	// Undefined sub-conditions are not counted
+	expectMatches(t, genFilter, `{"a": 11}`, 0, 1)
+	expectMatches(t, genFilter, `{"a": 11, "c": 1}`)
+	expectMatches(t, genFilter, `{"a": 11, "b": null, "c": 1, "d": true}`, 1)
+
+	// All sub-conditions undefined
+	expectMatches(t, genFilter, `{"e": 1}`)
+}
+
+func TestMajorityAllUndefined(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`!majority(a > 10, b == "x")`,
+		`!majority(a > 10, b == "x") && e == 1`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"a": 1, "b": "y"}`, 0)
+	expectMatches(t, genFilter, `{"a": 11, "b": "x"}`)
+
+	// Negation of the undefined result is true, the same as negating comparison of a missing attribute
+	expectMatches(t, genFilter, `{"e": 1}`, 0, 1)
+}
+
+func TestChainedComparison(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`18 <= age < 65`,
+		`low < value <= high`,
+		`0 < a < b < 10`,
+		`!(1 < level < 5)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"age": 18}`, 0)
+	expectMatches(t, genFilter, `{"age": 30}`, 0)
+	expectMatches(t, genFilter, `{"age": 65}`)
+	expectMatches(t, genFilter, `{"age": 10}`)
+
+	expectMatches(t, genFilter, `{"low": 1, "value": 5, "high": 5}`, 1)
+	expectMatches(t, genFilter, `{"low": 5, "value": 5, "high": 10}`)
+
+	expectMatches(t, genFilter, `{"a": 2, "b": 3}`, 2)
+	expectMatches(t, genFilter, `{"a": 3, "b": 2}`)
+	expectMatches(t, genFilter, `{"a": 2, "b": 12}`)
+
+	expectMatches(t, genFilter, `{"level": 3}`)
+	expectMatches(t, genFilter, `{"level": 5}`, 3)
+}
+
+func TestBetween(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`between(age, 18, 65)`,
+		`betweenExclusive(age, 18, 65)`,
+		`between(value, low, high)`,
+		`!between(age, 18, 65)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"age": 18}`, 0)
+	expectMatches(t, genFilter, `{"age": 40}`, 0, 1)
+	expectMatches(t, genFilter, `{"age": 65}`, 0)
+	expectMatches(t, genFilter, `{"age": 66}`, 3)
+	expectMatches(t, genFilter, `{"age": 17.5}`, 3)
+
+	expectMatches(t, genFilter, `{"value": 5, "low": 1, "high": 10}`, 2, 3)
+	expectMatches(t, genFilter, `{"value": 11, "low": 1, "high": 10}`, 3)
+	expectMatches(t, genFilter, `{"value": "b", "low": "a", "high": "c"}`, 2, 3)
+
+	// Undefined value or bounds
+	expectMatches(t, genFilter, `{"age": null}`, 3)
+	expectMatches(t, genFilter, `{"value": 5, "low": 1}`, 3)
+
+	// One category for the range test
+	if cats := genFilter.DebugMatchedCategories(map[string]interface{}{"age": 40.0}); len(cats) != 2 {
+		t.Fatalf("expected the between and betweenExclusive categories, got %v", cats)
+	}
+}
+
+func TestBetweenErrors(t *testing.T) {
+	expectRuleEngineError(t, `between(age, 18)`)
+	expectRuleEngineError(t, `betweenExclusive(age, 18, 65, 70)`)
+}
+
+func TestInSpec(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`inSpec(diameter, lowerSpec, upperSpec)`,
+		`!inSpec(diameter, lowerSpec, upperSpec)`,
+		`inSpec(weight, 9.5, 10.5)`,
+		`!inSpec(weight, 9.5, 10.5)`,
+		`forSome("parts", "p", !inSpec(p.diameter, p.lowerSpec, p.upperSpec))`,
+		`forSome("parts", "p", inSpec(p.diameter, p.lowerSpec, p.upperSpec))`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"diameter": 5.02, "lowerSpec": 4.98, "upperSpec": 5.02}`, 0)
+	expectMatches(t, genFilter, `{"diameter": 4.9, "lowerSpec": 4.98, "upperSpec": 5.02}`, 1)
+	expectMatches(t, genFilter, `{"diameter": 5.1, "lowerSpec": 4.98, "upperSpec": 5.02}`, 1)
+	expectMatches(t, genFilter, `{"weight": 10}`, 2)
+	expectMatches(t, genFilter, `{"weight": 11}`, 3)
+
+	// Neither in nor out of spec without the value or both limits, unlike !between()
+	expectMatches(t, genFilter, `{"diameter": 4.9, "lowerSpec": 4.98}`)
+	expectMatches(t, genFilter, `{"diameter": 4.9, "lowerSpec": 4.98, "upperSpec": null}`)
+	expectMatches(t, genFilter, `{"lowerSpec": 4.98, "upperSpec": 5.02}`)
+	expectMatches(t, genFilter, `{"diameter": "4.9", "lowerSpec": 4.98, "upperSpec": 5.02}`)
+	expectMatches(t, genFilter, `{"weight": null}`)
+
+	expectMatches(t, genFilter, `{"parts": [{"diameter": 5, "lowerSpec": 4.98, "upperSpec": 5.02}, {"diameter": 6}]}`, 5)
+	expectMatches(t, genFilter, `{"parts": [{"diameter": 6, "lowerSpec": 4.98, "upperSpec": 5.02}, {"diameter": 6}]}`, 4)
+	expectMatches(t, genFilter, `{"parts": [{"diameter": 6}]}`)
+}
+
+func TestInSpecErrors(t *testing.T) {
+	expectRuleEngineError(t, `inSpec(diameter, lowerSpec)`)
+	expectRuleEngineError(t, `!inSpec(diameter, lowerSpec, upperSpec, 1)`)
+}
+
+func TestCoalesce(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`coalesce(primary, fallback, 0) > 10`,
+		`coalesce(primary, fallback) == 5`,
+		`coalesce(nickname, name, "anonymous") == "anonymous" && hasValue(id)`,
+		`!(coalesce(primary, fallback) > 0)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"primary": 12, "fallback": 5}`, 0)
+	expectMatches(t, genFilter, `{"primary": null, "fallback": 12}`, 0)
+	expectMatches(t, genFilter, `{"fallback": 5}`, 1)
+	expectMatches(t, genFilter, `{"primary": 5, "fallback": 20}`, 1)
+	// The constant is the last resort
+	expectMatches(t, genFilter, `{"primary": null, "fallback": null}`, 3)
+	expectMatches(t, genFilter, `{"primary": -1}`, 3)
+
+	expectMatches(t, genFilter, `{"id": 1, "nickname": null, "name": null}`, 2)
+	expectMatches(t, genFilter, `{"id": 1, "name": "Frank"}`)
+	expectMatches(t, genFilter, `{"id": 1, "nickname": "anonymous", "name": "Frank"}`, 2)
+}
+
+func TestCoalesceErrors(t *testing.T) {
+	expectRuleEngineError(t, `coalesce(a) > 1`)
+}
+
+func TestOneOfNoneOf(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`oneOf(status, "open", "pending")`,
+		`noneOf(status, "open", "pending")`,
+		`!oneOf(status, "open", "pending")`,
+		`priority > 1 && oneOf(status, "open", "pending")`,
+		`forSome("tickets", "t", oneOf(t.status, "open", "pending") && t.priority > 1)`,
+		`forSome("tickets", "t", noneOf(t.status, "open", "pending"))`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"status": "open"}`, 0)
+	expectMatches(t, genFilter, `{"status": "pending", "priority": 3}`, 0, 3)
+	expectMatches(t, genFilter, `{"status": "closed", "priority": 3}`, 1, 2)
+	expectMatches(t, genFilter, `{"tickets": [{"status": "closed", "priority": 3}, {"status": "open", "priority": 2}]}`,
+		1, 2, 4, 5)
+	expectMatches(t, genFilter, `{"tickets": [{"status": "open", "priority": 1}]}`, 1, 2)
+	// Inside the expressions the values are compared too rather than only tested for presence
+	expectMatches(t, genFilter, `{"tickets": [{"status": "closed", "priority": 3}]}`, 1, 2, 5)
+}
+
+func TestOneOfSameCategory(t *testing.T) {
+	_, oneOf := newRuleEngineFromExpressions(t,
+		`oneOf(status, "open", "pending") && noneOf(region, "XX", "YY")`)
+	_, isEqualToAny := newRuleEngineFromExpressions(t,
+		`isEqualToAny(status, "open", "pending") && !isEqualToAny(region, "XX", "YY")`)
+
+	// The aliases compile to the same categories as the equivalent isEqualToAny
+	for _, event := range []map[string]interface{}{
+		{"status": "open", "region": "EU"},
+		{"status": "pending", "region": "XX"},
+		{"status": "closed"},
+	} {
+		if cats, expected := oneOf.DebugMatchedCategories(event), isEqualToAny.DebugMatchedCategories(event); !reflect.DeepEqual(cats, expected) {
+			t.Fatalf("failed matched categories %v != %v for event %v", cats, expected, event)
+		}
+	}
+
+	expectRuleEngineError(t, `oneOf(status)`)
+	expectRuleEngineError(t, `noneOf(status)`)
+}
+
+func TestHasAllValues(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`hasAllValues(id, kind, header.source)`,
+		`hasAllValues(id) && kind == "order"`,
+		`count("items", "item", hasAllValues(item.sku, item.qty)) == 1`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"id": 1, "kind": "order", "header": {"source": "web"}}`, 0, 1)
+	expectMatches(t, genFilter, `{"id": 1, "kind": "order"}`, 1)
+	expectMatches(t, genFilter, `{"id": 1, "kind": "order", "header": {"source": null}}`, 1)
+	expectMatches(t, genFilter, `{"kind": "refund", "header": {"source": "web"}}`)
+	expectMatches(t, genFilter, `{"other": 1}`)
+	expectMatches(t, genFilter, `{"items": [{"sku": "a", "qty": 1}, {"sku": "b"}]}`, 2)
+
+	expectRuleEngineError(t, `hasAllValues()`)
+	expectRuleEngineError(t, `hasAllValues(a, b + 1)`)
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"testing"
+)
+
+func TestWithinPercent(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`withinPercent(actual, expected, 5)`,
+		`!withinPercent(actual, expected, 5)`,
+		`withinPercent(actual, 200, tolerance)`)
+	expectNoErrors(t, repo)
+
+	// Within
+	expectMatches(t, genFilter, `{"actual": 104, "expected": 100}`, 0)
+	expectMatches(t, genFilter, `{"actual": 95, "expected": 100}`, 0)
+	expectMatches(t, genFilter, `{"actual": -98, "expected": -100}`, 0)
+	expectMatches(t, genFilter, `{"actual": "101", "expected": 100}`, 0)
+
+	// Outside
+	expectMatches(t, genFilter, `{"actual": 106, "expected": 100}`, 1)
+	expectMatches(t, genFilter, `{"actual": 94.9, "expected": 100}`, 1)
+
+	// Expected value of 0
+	expectMatches(t, genFilter, `{"actual": 0, "expected": 0}`, 0)
+	expectMatches(t, genFilter, `{"actual": 0.001, "expected": 0}`, 1)
+
+	expectMatches(t, genFilter, `{"actual": 210, "tolerance": 5}`, 1, 2)
+	expectMatches(t, genFilter, `{"actual": 220, "tolerance": 5}`, 1)
+
+	// Undefined values make the result undefined, which only matches the negated rule
+	expectMatches(t, genFilter, `{"actual": 100, "expected": null}`, 1)
+	expectMatches(t, genFilter, `{"actual": 210}`, 1)
+}
+
+func TestHaversineKm(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`haversineKm(userLat, userLon, storeLat, storeLon) < 5`,
+		`withinPercent(haversineKm(userLat, userLon, storeLat, storeLon), 343.9, 0.5)`,
+		`withinPercent(haversineKm(userLat, userLon, storeLat, storeLon), 20015.1, 0.1)`,
+		`haversineKm(userLat, userLon, 48.8566, 2.3522) < 1`)
+	expectNoErrors(t, repo)
+
+	// Same point
+	expectMatches(t, genFilter, `{"userLat": 48.8566, "userLon": 2.3522, "storeLat": 48.8566, "storeLon": 2.3522}`, 0, 3)
+	// About 3 km apart within Paris
+	expectMatches(t, genFilter, `{"userLat": 48.8566, "userLon": 2.3522, "storeLat": 48.8738, "storeLon": 2.2950}`, 0, 3)
+	// Paris to London
+	expectMatches(t, genFilter, `{"userLat": 48.8566, "userLon": 2.3522, "storeLat": 51.5074, "storeLon": -0.1278}`, 1, 3)
+	// Antipodal points are half the circumference apart
+	expectMatches(t, genFilter, `{"userLat": 0, "userLon": 0, "storeLat": 0, "storeLon": 180}`, 2)
+	expectMatches(t, genFilter, `{"userLat": 90, "userLon": 0, "storeLat": -90, "storeLon": 0}`, 2)
+
+	expectMatches(t, genFilter, `{"userLat": 48.8566, "userLon": 2.3522, "storeLat": null, "storeLon": 2.3522}`, 3)
+}
+
+func TestLogExp(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`log10(followers) > 2.9`,
+		`withinPercent(log(followers), 6.9078, 0.01)`,
+		`withinPercent(exp(log(followers)), followers, 0.0001)`,
+		`exp(followers) < 1`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"followers": 1000}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"followers": 10}`, 2)
+	// The logarithm of a non-positive value is an error rather than NaN
+	expectMatches(t, genFilter, `{"followers": 0}`)
+	expectMatches(t, genFilter, `{"followers": -2}`, 3)
+	expectMatches(t, genFilter, `{"followers": null}`)
+
+	expectRuleEngineError(t, `log() > 1`)
+	expectRuleEngineError(t, `log10(a, b) > 1`)
+	expectRuleEngineError(t, `exp(a, b) > 1`)
+}
+
+func TestTruncSign(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`trunc(x) == 0 - 2`,
+		`trunc(x) == 2`,
+		`sign(x) == 0 - 1`,
+		`sign(x) == 0`,
+		`sign(x) == 1`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"x": -2.7}`, 0, 2)
+	expectMatches(t, genFilter, `{"x": 2.7}`, 1, 4)
+	expectMatches(t, genFilter, `{"x": -5}`, 2)
+	expectMatches(t, genFilter, `{"x": 0}`, 3)
+	expectMatches(t, genFilter, `{"x": null}`)
+	expectMatches(t, genFilter, `{"y": 1}`)
+
+	expectRuleEngineError(t, `trunc() == 1`)
+	expectRuleEngineError(t, `sign(a, b) == 1`)
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"github.com/atlasgurus/rulestone/engine"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSetFromFile(t *testing.T) {
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "allowlist.txt")
+	if err := os.WriteFile(textPath, []byte("frank\n\n  tom  \n1234567\n"), 0644); err != nil {
+		t.Fatalf("failed WriteFile: %s", err)
+	}
+	jsonPath := filepath.Join(dir, "countries.json")
+	if err := os.WriteFile(jsonPath, []byte(`["us", "ca", 52]`), 0644); err != nil {
+		t.Fatalf("failed WriteFile: %s", err)
+	}
+
+	repo := newRuleEngineRepoFromExpressions(t,
+		`inSet(user, "allowlist")`,
+		`!inSet(country, "countries")`,
+		`inSet(id, "allowlist") || inSet(code, "countries")`)
+	if err := repo.LoadSetFromFile("allowlist", textPath, "text"); err != nil {
+		t.Fatalf("failed LoadSetFromFile: %s", err)
+	}
+	if err := repo.LoadSetFromFile("countries", jsonPath, ""); err != nil {
+		t.Fatalf("failed LoadSetFromFile: %s", err)
+	}
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+
+	expectMatches(t, genFilter, `{"user": "frank", "country": "us"}`, 0)
+	expectMatches(t, genFilter, `{"user": "tom", "country": "mx"}`, 0, 1)
+	expectMatches(t, genFilter, `{"user": "bob", "country": "ca"}`)
+	expectMatches(t, genFilter, `{"id": 1234567}`, 1, 2)
+	expectMatches(t, genFilter, `{"code": 52}`, 1, 2)
+
+	// Reload the set without rebuilding the engine
+	if err := os.WriteFile(textPath, []byte("bob\n"), 0644); err != nil {
+		t.Fatalf("failed WriteFile: %s", err)
+	}
+	if err := repo.LoadSetFromFile("allowlist", textPath, ""); err != nil {
+		t.Fatalf("failed LoadSetFromFile: %s", err)
+	}
+	expectMatches(t, genFilter, `{"user": "frank", "country": "us"}`)
+	expectMatches(t, genFilter, `{"user": "bob", "country": "ca"}`, 0)
+
+	if err := repo.UpdateSet("countries", []string{"mx"}); err != nil {
+		t.Fatalf("failed UpdateSet: %s", err)
+	}
+	expectMatches(t, genFilter, `{"user": "tom", "country": "mx"}`)
+}
+
+func TestNamedSetErrors(t *testing.T) {
+	repo := engine.NewRuleEngineRepo()
+	if err := repo.UpdateSet("missing", []string{"a"}); err == nil {
+		t.Fatalf("expected UpdateSet error for unknown set")
+	}
+	if err := repo.LoadSetFromFile("missing", filepath.Join(t.TempDir(), "missing.txt"), ""); err == nil {
+		t.Fatalf("expected LoadSetFromFile error for missing file")
+	}
+	path := filepath.Join(t.TempDir(), "set.json")
+	if err := os.WriteFile(path, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatalf("failed WriteFile: %s", err)
+	}
+	if err := repo.LoadSetFromFile("bad", path, ""); err == nil {
+		t.Fatalf("expected LoadSetFromFile error for invalid JSON array")
+	}
+
+	expectRuleEngineError(t, `inSet(user, "unknown")`)
+	expectRuleEngineError(t, `inSet(user)`)
+	expectRuleEngineError(t, `inSet(user, name)`)
+}
+
+func TestInNumericSet(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`inNumericSet(code, "validCodes")`,
+		`!inNumericSet(code, "validCodes")`,
+		`inSet(code, "validCodes")`)
+	repo.RegisterSet("validCodes", []string{"200", "201.0", "2.04e2", "ok"})
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"code": 200}`, 0, 2)
+	expectMatches(t, genFilter, `{"code": 200.0}`, 0, 2)
+	expectMatches(t, genFilter, `{"code": 201}`, 0)
+	expectMatches(t, genFilter, `{"code": 204}`, 0)
+	expectMatches(t, genFilter, `{"code": 200.5}`, 1)
+	// The strings are not numeric codes, even when they are members of the set
+	expectMatches(t, genFilter, `{"code": "200"}`, 1, 2)
+	expectMatches(t, genFilter, `{"code": "ok"}`, 1, 2)
+	expectMatches(t, genFilter, `{"other": 200}`, 1)
+
+	// The int values are matched the same as the floats decoded from JSON
+	for _, code := range []interface{}{200, int64(204), 201.0} {
+		matches := genFilter.MatchEvent(map[string]interface{}{"code": code})
+		sortRuleIds(matches)
+		if len(matches) == 0 || matches[0] != 0 {
+			t.Fatalf("failed inNumericSet %v for code %v", matches, code)
+		}
+	}
+
+	if err := repo.UpdateSet("validCodes", []string{"404"}); err != nil {
+		t.Fatalf("failed UpdateSet: %s", err)
+	}
+	expectMatches(t, genFilter, `{"code": 200}`, 1)
+	expectMatches(t, genFilter, `{"code": 404}`, 0, 2)
+
+	expectRuleEngineError(t, `inNumericSet(code, "unknown")`)
+	expectRuleEngineError(t, `inNumericSet(code)`)
+	expectRuleEngineError(t, `inNumericSet(code, name)`)
+}
+
+func TestSetMembershipCount(t *testing.T) {
+	repo := newRuleEngineRepoFromExpressions(t,
+		`setMembershipCount(ip, "feedA", "feedB", "feedC") >= 2`,
+		`setMembershipCount(ip, "feedA", "feedB", "feedC") == 0`,
+		`setMembershipCount(ip, "feedA", "feedB", "feedC") == 1`,
+		`setMembershipCount(port, "feedA", "feedC") == 1`)
+	repo.RegisterSet("feedA", []string{"10.0.0.1", "10.0.0.2", "8080"})
+	repo.RegisterSet("feedB", []string{"10.0.0.1", "10.0.0.3"})
+	repo.RegisterSet("feedC", []string{"10.0.0.1", "10.0.0.2"})
+	genFilter, err := engine.NewRuleEngine(repo)
+	if err != nil {
+		t.Fatalf("failed NewRuleEngine: %s", err)
+	}
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"ip": "10.0.0.9"}`, 1)
+	expectMatches(t, genFilter, `{"ip": "10.0.0.3"}`, 2)
+	expectMatches(t, genFilter, `{"ip": "10.0.0.2"}`, 0)
+	expectMatches(t, genFilter, `{"ip": "10.0.0.1", "port": 8080}`, 0, 3)
+	// The count of a missing value is undefined
+	expectMatches(t, genFilter, `{"other": "10.0.0.1"}`)
+
+	if err := repo.UpdateSet("feedB", []string{"10.0.0.2"}); err != nil {
+		t.Fatalf("failed UpdateSet: %s", err)
+	}
+	expectMatches(t, genFilter, `{"ip": "10.0.0.3"}`, 1)
+	expectMatches(t, genFilter, `{"ip": "10.0.0.2"}`, 0)
+
+	expectRuleEngineError(t, `setMembershipCount(ip) > 0`)
+	expectRuleEngineError(t, `setMembershipCount(ip, "unknown") > 0`)
+	expectRuleEngineError(t, `setMembershipCount(ip, name) > 0`)
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+// newOrderMessageDescriptor builds the descriptor of the following sample proto:
+//
+//	syntax = "proto3";
+//	enum Status { NEW = 0; SHIPPED = 1; }
+//	message Customer { string name = 1; int32 age = 2; }
+//	message Item { string sku = 1; int64 qty = 2; double price = 3; }
+//	message Order {
+//	  string id = 1;
+//	  double amount = 2;
+//	  Customer customer = 3;
+//	  repeated Item items = 4;
+//	  repeated string tags = 5;
+//	  Status status = 6;
+//	  map<string, string> labels = 7;
+//	}
+func newOrderMessageDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string,
+		label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		result := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+		if typeName != "" {
+			result.TypeName = proto.String(typeName)
+		}
+		return result
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	fileProto := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("order.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("NEW"), Number: proto.Int32(0)},
+				{Name: proto.String("SHIPPED"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Customer"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, "", optional),
+					field("age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", optional),
+				},
+			},
+			{
+				Name: proto.String("Item"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("sku", 1, str, "", optional),
+					field("qty", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", optional),
+					field("price", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "", optional),
+				},
+			},
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, str, "", optional),
+					field("amount", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "", optional),
+					field("customer", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Customer", optional),
+					field("items", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Item", repeated),
+					field("tags", 5, str, "", repeated),
+					field("status", 6, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status", optional),
+					field("labels", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Order.LabelsEntry", repeated),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str, "", optional),
+						field("value", 2, str, "", optional),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	file, err := protodesc.NewFile(fileProto, nil)
+	if err != nil {
+		t.Fatalf("failed NewFile: %s", err)
+	}
+	return file.Messages().ByName("Order")
+}
+
+func newOrderMessage(md protoreflect.MessageDescriptor, id string, amount float64, customerAge int32,
+	status protoreflect.EnumNumber, qtys ...int64) *dynamicpb.Message {
+	order := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	order.Set(fields.ByName("id"), protoreflect.ValueOfString(id))
+	order.Set(fields.ByName("amount"), protoreflect.ValueOfFloat64(amount))
+	order.Set(fields.ByName("status"), protoreflect.ValueOfEnum(status))
+
+	customer := order.Mutable(fields.ByName("customer")).Message()
+	customer.Set(customer.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("Frank"))
+	customer.Set(customer.Descriptor().Fields().ByName("age"), protoreflect.ValueOfInt32(customerAge))
+
+	items := order.Mutable(fields.ByName("items")).List()
+	for i, qty := range qtys {
+		item := items.NewElement().Message()
+		item.Set(item.Descriptor().Fields().ByName("sku"), protoreflect.ValueOfString("sku"+string(rune('a'+i))))
+		item.Set(item.Descriptor().Fields().ByName("qty"), protoreflect.ValueOfInt64(qty))
+		items.Append(protoreflect.ValueOfMessage(item))
+	}
+
+	tags := order.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("urgent"))
+
+	labels := order.Mutable(fields.ByName("labels")).Map()
+	labels.Set(protoreflect.ValueOfString("region").MapKey(), protoreflect.ValueOfString("emea"))
+	return order
+}
+
+func TestMatchProto(t *testing.T) {
+	md := newOrderMessageDescriptor(t)
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`amount > 100 && customer.name == "Frank"`,
+		`customer.age >= 18 && status == "SHIPPED"`,
+		`forSome("items", "item", item.qty > 10 && item.sku == "skub")`,
+		`regexpMatchAny("^urg", "tags", "tag") && labels.region == "emea"`,
+		`id == "o-2"`,
+		`customer.age == 0`)
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		msg      proto.Message
+		expected []condition.RuleIdType
+	}{
+		{newOrderMessage(md, "o-1", 150, 30, 1, 1, 20), []condition.RuleIdType{0, 1, 2, 3}},
+		{newOrderMessage(md, "o-2", 50, 16, 0, 20, 1), []condition.RuleIdType{3, 4}},
+		{newOrderMessage(md, "o-3", 50, 0, 1), []condition.RuleIdType{3, 5}},
+		{dynamicpb.NewMessage(md), []condition.RuleIdType{}},
+	} {
+		matches := genFilter.MatchProto(tc.msg)
+		matched := make(map[condition.RuleIdType]bool)
+		for _, m := range matches {
+			matched[m] = true
+		}
+		if len(matches) != len(tc.expected) {
+			t.Fatalf("failed matches %v != %v", matches, tc.expected)
+		}
+		for _, e := range tc.expected {
+			if !matched[e] {
+				t.Fatalf("failed matches %v != %v", matches, tc.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"github.com/atlasgurus/rulestone/condition"
+	"github.com/atlasgurus/rulestone/engine"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStatefulMatcher(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`amount > 2 * @avg("amount")`,
+		`@count("amount") >= 3 && @max("payment.fee") < fee`,
+		`amount > 0 && @sum("amount") == 0`,
+		`label == "@avg(amount)"`)
+	expectNoErrors(t, repo)
+
+	matcher, err := engine.NewStatefulMatcher(genFilter, 3)
+	if err != nil {
+		t.Fatalf("failed NewStatefulMatcher: %s", err)
+	}
+	for i, tc := range []struct {
+		event   map[string]interface{}
+		matches []condition.RuleIdType
+	}{
+		// No previous events, the aggregates are undefined
+		{map[string]interface{}{"amount": 10.0, "fee": 1.0, "payment": map[string]interface{}{"fee": 1.0}}, nil},
+		// The average of 10
+		{map[string]interface{}{"amount": 21.0}, []condition.RuleIdType{0}},
+		{map[string]interface{}{"amount": 12.0, "payment": map[string]interface{}{"fee": 3.0}}, nil},
+		// The average of 10, 21 and 12 is 14.33, the maximum fee is 3
+		{map[string]interface{}{"amount": 29.0, "fee": 4.0}, []condition.RuleIdType{0, 1}},
+		// The window holds the last 3 amounts: 21, 12 and 29, the average is 20.67
+		{map[string]interface{}{"amount": 41.0}, nil},
+		{map[string]interface{}{"amount": 60.0}, []condition.RuleIdType{0}},
+		// The string literals are not rewritten
+		{map[string]interface{}{"label": "@avg(amount)"}, []condition.RuleIdType{3}},
+	} {
+		matches := matcher.MatchEvent(tc.event)
+		sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+		if len(matches) != len(tc.matches) || (len(matches) > 0 && !reflect.DeepEqual(matches, tc.matches)) {
+			t.Fatalf("failed event %d %v: matches %v != %v", i, tc.event, matches, tc.matches)
+		}
+	}
+
+	// The aggregates are undefined outside the stateful matcher
+	expectMatches(t, genFilter, `{"amount": 100, "fee": 100}`)
+}
+
+func TestStatefulMatcherErrors(t *testing.T) {
+	expectRuleEngineError(t, `amount > @median("amount")`)
+	expectRuleEngineError(t, `amount > @avg(amount)`)
+	expectRuleEngineError(t, `forSome("items", "i", i.amount > @avg("amount"))`)
+	_, genFilter := newRuleEngineFromExpressions(t, `amount > @avg("amount")`)
+	if _, err := engine.NewStatefulMatcher(genFilter, 0); err == nil {
+		t.Fatalf("expected error for empty window")
+	}
+}
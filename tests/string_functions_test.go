@@ -0,0 +1,430 @@
+package tests
+
+import (
+	"github.com/atlasgurus/rulestone/engine"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`similarity(name, "reference") > 0.8`,
+		`similarity(name, "reference") > 0.3`,
+		`similarity(name, other) == 1`,
+		`similarity(name, "abc") < 0.7 && similarity(name, "abc") > 0.6`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"name": "reference", "other": "reference"}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"name": "referense"}`, 0, 1)
+	expectMatches(t, genFilter, `{"name": "referendum"}`, 1)
+	expectMatches(t, genFilter, `{"name": "xyz", "other": "abc"}`)
+	expectMatches(t, genFilter, `{"name": "x", "other": "x"}`, 2)
+	expectMatches(t, genFilter, `{"name": "abd"}`, 3)
+	expectMatches(t, genFilter, `{"name": "", "other": ""}`, 2)
+	expectMatches(t, genFilter, `{"other": "abc"}`)
+}
+
+func TestParseLeadingTrailingNumber(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`parseTrailingNumber(orderId) > 10000`,
+		`parseLeadingNumber(orderId) == 42`,
+		`!(parseTrailingNumber(orderId) > 10000)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"orderId": "ORD-10045"}`, 0)
+	expectMatches(t, genFilter, `{"orderId": "ORD-9"}`, 2)
+	expectMatches(t, genFilter, `{"orderId": "42-xyz-7"}`, 1, 2)
+	expectMatches(t, genFilter, `{"orderId": "0042abc10001"}`, 0, 1)
+	expectMatches(t, genFilter, `{"orderId": 42}`, 1, 2)
+
+	// No number and undefined values propagate as undefined
+	expectMatches(t, genFilter, `{"orderId": "ORD-X"}`, 2)
+	expectMatches(t, genFilter, `{"orderId": null}`, 2)
+	expectMatches(t, genFilter, `{"other": "ORD-10045"}`)
+}
+
+func TestShannonEntropy(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`shannonEntropy(token) > 4.5`,
+		`shannonEntropy(token) < 1`,
+		`shannonEntropy(token) == 2`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"token": "aaaaaaaaaaaaaaaaaaaaaaab"}`, 1)
+	expectMatches(t, genFilter, `{"token": "aaaa"}`, 1)
+	expectMatches(t, genFilter, `{"token": ""}`, 1)
+	expectMatches(t, genFilter, `{"token": "abcd"}`, 2)
+	expectMatches(t, genFilter, `{"token": "kX9#pQ2$vL7!mZ4&wR8*tB3^yN6@cF1%"}`, 0)
+	expectMatches(t, genFilter, `{"token": "password"}`)
+
+	// Undefined values propagate
+	expectMatches(t, genFilter, `{"token": null}`)
+	expectMatches(t, genFilter, `{"other": "aaaa"}`)
+}
+
+func TestDigestFunctions(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`crc32(payload) == "3610a686"`,
+		`md5(payload) == "5d41402abc4b2a76b9719d911017c592"`,
+		`sha256(payload) == "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"`,
+		`checksum == crc32(payload)`,
+		`crc32(payload) != "3610a686"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"payload": "hello", "checksum": "3610a686"}`, 0, 1, 2, 3)
+	expectMatches(t, genFilter, `{"payload": "hellO", "checksum": "3610a686"}`, 4)
+	expectMatches(t, genFilter, `{"payload": 42, "checksum": "3224b088"}`, 3, 4)
+
+	// Undefined values propagate, only != matches them
+	expectMatches(t, genFilter, `{"payload": null, "checksum": "3610a686"}`, 4)
+	expectMatches(t, genFilter, `{"checksum": "3610a686"}`, 4)
+}
+
+func TestLowerUpper(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`lower(country) == "us"`,
+		`upper(country) == upper(home)`,
+		`lower("ABC") == lower(code)`,
+		`lower(country) != "us"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"country": "US"}`, 0)
+	expectMatches(t, genFilter, `{"country": "uS", "home": "Us"}`, 0, 1)
+	expectMatches(t, genFilter, `{"country": "CA", "home": "us"}`, 3)
+	expectMatches(t, genFilter, `{"code": "aBc"}`, 2, 3)
+	expectMatches(t, genFilter, `{"country": 42}`, 3)
+
+	// Undefined propagates
+	expectMatches(t, genFilter, `{"country": null, "home": null}`, 3)
+}
+
+func TestLowerUpperErrors(t *testing.T) {
+	expectRuleEngineError(t, `lower() == "a"`)
+	expectRuleEngineError(t, `upper(a, b) == "a"`)
+}
+
+func TestCollapseSpaces(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`collapseSpaces(msg) == "disk full on node 7"`,
+		`collapseSpaces(msg) == ""`,
+		`collapseSpaces("  a \t b  ") == code`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"msg": "disk full on node 7"}`, 0)
+	expectMatches(t, genFilter, `{"msg": "disk  full   on node 7"}`, 0)
+	expectMatches(t, genFilter, `{"msg": "disk\tfull\t\ton\nnode 7"}`, 0)
+	expectMatches(t, genFilter, `{"msg": "  \tdisk full on node 7 \n"}`, 0)
+	expectMatches(t, genFilter, `{"msg": "diskfull on node 7"}`)
+	expectMatches(t, genFilter, `{"msg": " \t\n "}`, 1)
+
+	// The constant argument is folded
+	expectMatches(t, genFilter, `{"code": "a b"}`, 2)
+
+	// Undefined values propagate
+	expectMatches(t, genFilter, `{"msg": null}`)
+	expectMatches(t, genFilter, `{"other": "disk full on node 7"}`)
+}
+
+func TestTrim(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`trim(name) == "Frank"`,
+		`trimLeft(name) == "Frank "`,
+		`trimRight(name) == " Frank"`,
+		`trim(path, "/") == "home/frank"`,
+		`trimRight(path, "/") == "/home/frank"`,
+		`trim(path, "") == "/home/frank/"`,
+		`trimLeft(" x ", "") == code`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"name": " Frank "}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"name": "  Frank\t\n"}`, 0)
+	expectMatches(t, genFilter, `{"name": "Frank"}`, 0)
+	expectMatches(t, genFilter, `{"name": "\u00a0\u2003Frank\u3000"}`, 0)
+	expectMatches(t, genFilter, `{"name": "\u2003Frank "}`, 0, 1)
+	expectMatches(t, genFilter, `{"path": "/home/frank/"}`, 3, 4, 5)
+	expectMatches(t, genFilter, `{"path": "//home/frank//"}`, 3)
+	expectMatches(t, genFilter, `{"code": " x "}`, 6)
+
+	// Undefined propagates
+	expectMatches(t, genFilter, `{"name": null, "path": null}`)
+}
+
+func TestTrimErrors(t *testing.T) {
+	expectRuleEngineError(t, `trim() == "a"`)
+	expectRuleEngineError(t, `trimLeft(a, "b", "c") == "a"`)
+}
+
+func TestNegatedContainsAny(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`containsAny(log, "error", "fatal")`,
+		`!containsAny(log, "error", "fatal")`)
+	expectNoErrors(t, repo)
+
+	// Both forms share the string matcher built for the log attribute
+	condRepo, err := engine.RuleEngineRepoToCompareCondRepo(repo)
+	if err != nil {
+		t.Fatalf("failed RuleEngineRepoToCompareCondRepo: %s", err)
+	}
+	if condRepo.CondToStringMatcher.Size() != 1 {
+		t.Fatalf("expected one string matcher, got %d", condRepo.CondToStringMatcher.Size())
+	}
+
+	expectMatches(t, genFilter, `{"log": "disk error on /dev/sda"}`, 0)
+	expectMatches(t, genFilter, `{"log": "fatal: out of memory"}`, 0)
+	expectMatches(t, genFilter, `{"log": "all good"}`, 1)
+	expectMatches(t, genFilter, `{"log": ""}`, 1)
+	// A missing log does not contain any of the patterns
+	expectMatches(t, genFilter, `{"other": "error"}`, 1)
+}
+
+func TestContainsAll(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`containsAll(text, "refund", "urgent", "order")`,
+		`!containsAll(text, "refund", "urgent", "order")`,
+		`containsAll(text, "urgent")`,
+		`containsAny(text, "order")`)
+	expectNoErrors(t, repo)
+
+	// All the patterns share the string matcher built for the text attribute
+	condRepo, err := engine.RuleEngineRepoToCompareCondRepo(repo)
+	if err != nil {
+		t.Fatalf("failed RuleEngineRepoToCompareCondRepo: %s", err)
+	}
+	if condRepo.CondToStringMatcher.Size() != 1 {
+		t.Fatalf("expected one string matcher, got %d", condRepo.CondToStringMatcher.Size())
+	}
+
+	expectMatches(t, genFilter, `{"text": "urgent: refund my order 42"}`, 0, 2, 3)
+	expectMatches(t, genFilter, `{"text": "order refund, urgent, refund"}`, 0, 2, 3)
+	// Partial matches
+	expectMatches(t, genFilter, `{"text": "please refund my order"}`, 1, 3)
+	expectMatches(t, genFilter, `{"text": "urgent refund"}`, 1, 2)
+	expectMatches(t, genFilter, `{"text": "nothing to see"}`, 1)
+	// A missing text does not contain the patterns
+	expectMatches(t, genFilter, `{"other": "urgent refund order"}`, 1)
+
+	expectRuleEngineError(t, `containsAll(text)`)
+	expectRuleEngineError(t, `containsAll(text, "a", pattern)`)
+}
+
+func TestStartsWith(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`startsWith(path, "/api/")`,
+		`startsWith(path, "/api/v2/", "/admin/")`,
+		`startsWith(path, basePath)`,
+		`!startsWith(path, basePath)`,
+		`containsAny(path, "/api/")`,
+		`startsWith(path, concat(basePath, "/"))`)
+	expectNoErrors(t, repo)
+
+	// The constant prefixes share the prefix matcher built for the path attribute
+	condRepo, err := engine.RuleEngineRepoToCompareCondRepo(repo)
+	if err != nil {
+		t.Fatalf("failed RuleEngineRepoToCompareCondRepo: %s", err)
+	}
+	if condRepo.CondToPrefixMatcher.Size() != 1 {
+		t.Fatalf("expected one prefix matcher, got %d", condRepo.CondToPrefixMatcher.Size())
+	}
+
+	expectMatches(t, genFilter, `{"path": "/api/v2/users", "basePath": "/api/v2"}`, 0, 1, 2, 4, 5)
+	expectMatches(t, genFilter, `{"path": "/api/v2", "basePath": "/api/v2"}`, 0, 2, 4)
+	expectMatches(t, genFilter, `{"path": "/admin/users", "basePath": "/api"}`, 1, 3)
+	expectMatches(t, genFilter, `{"path": "/ap", "basePath": "/api"}`, 3)
+	// The undefined prefix matches nothing
+	expectMatches(t, genFilter, `{"path": "/api/users"}`, 0, 3, 4)
+	expectMatches(t, genFilter, `{"basePath": "/api"}`, 3)
+
+	expectRuleEngineError(t, `startsWith(path)`)
+	expectRuleEngineError(t, `startsWith(path, basePath, "/api")`)
+}
+
+func TestSplit(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`split(path, "/")[2] == "admin"`,
+		`length(split(path, "/")) > 3`,
+		`split(path, "/")[level] == "users"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"path": "/api/admin"}`, 0)
+	expectMatches(t, genFilter, `{"path": "/api/admin/users", "level": 3}`, 0, 1, 2)
+	expectMatches(t, genFilter, `{"path": "/api/users", "level": 2}`, 2)
+	// Index out of range is undefined
+	expectMatches(t, genFilter, `{"path": "admin", "level": 5}`)
+	expectMatches(t, genFilter, `{"path": null, "level": 1}`)
+	expectMatches(t, genFilter, `{"level": 1}`)
+}
+
+func TestIndexOf(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`indexOf(email, "@") > 0`,
+		`indexOf(email, "@") < 0`,
+		`indexOf(name, "é") == 2`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"email": "frank@example.com"}`, 0)
+	expectMatches(t, genFilter, `{"email": "@example.com"}`)
+	expectMatches(t, genFilter, `{"email": "frank"}`, 1)
+	// The index is in characters
+	expectMatches(t, genFilter, `{"name": "Renée"}`)
+	expectMatches(t, genFilter, `{"name": "Zoé"}`, 2)
+	expectMatches(t, genFilter, `{"email": null}`)
+}
+
+func TestSplitErrors(t *testing.T) {
+	expectRuleEngineError(t, `split(path)[0] == "a"`)
+	expectRuleEngineError(t, `indexOf(path, "a", "b") == 1`)
+}
+
+func TestReplace(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`replace(phone, "-", "") == "5551234"`,
+		`replace(name, " ", "_", 1) == "Frank_de Wit"`,
+		`replace(code, old, new) == "b-b"`,
+		`replace(phone, "-", "", count) == "555123-4"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"phone": "555-12-34"}`, 0)
+	expectMatches(t, genFilter, `{"phone": "5551234"}`, 0)
+	expectMatches(t, genFilter, `{"phone": "555-123-4", "count": 1}`, 0, 3)
+	expectMatches(t, genFilter, `{"phone": "555-123-4", "count": -1}`, 0)
+	expectMatches(t, genFilter, `{"name": "Frank de Wit"}`, 1)
+	expectMatches(t, genFilter, `{"name": "Frank_de_Wit"}`)
+	expectMatches(t, genFilter, `{"code": "a-a", "old": "a", "new": "b"}`, 2)
+
+	// Undefined operands
+	expectMatches(t, genFilter, `{"code": "a-a", "old": "a"}`)
+	expectMatches(t, genFilter, `{"phone": null}`)
+}
+
+func TestReplaceErrors(t *testing.T) {
+	expectRuleEngineError(t, `replace(name, "a") == "b"`)
+	expectRuleEngineError(t, `replace(name, "a", "b", 1, 2) == "b"`)
+}
+
+func TestConcat(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`concat(first, " ", last) == "Frank de Wit"`,
+		`regexpMatch("^us-[0-9]+$", concat(country, "-", zip))`,
+		`containsAny(concat(first, last), "nkde")`,
+		`concat(code, 1) == "A1"`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"first": "Frank", "last": "de Wit"}`, 0, 2)
+	expectMatches(t, genFilter, `{"country": "us", "zip": 94105}`, 1)
+	expectMatches(t, genFilter, `{"country": "us", "zip": "941-05"}`)
+	expectMatches(t, genFilter, `{"code": "A"}`, 3)
+
+	// Undefined argument makes the result undefined
+	expectMatches(t, genFilter, `{"first": "Frank"}`)
+	expectMatches(t, genFilter, `{"country": "us", "zip": null}`)
+}
+
+func TestConcatErrors(t *testing.T) {
+	expectRuleEngineError(t, `concat(a) == "a"`)
+	expectRuleEngineError(t, `concat() == ""`)
+}
+
+func TestEqualsFold(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`equalsFold(country, "US")`,
+		`equalsFold(country, "US") && tier == "gold"`,
+		`hasValue(email) && !equalsFold(email, confirmEmail)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"country": "us"}`, 0)
+	expectMatches(t, genFilter, `{"country": "Us", "tier": "gold"}`, 0, 1)
+	expectMatches(t, genFilter, `{"country": "usa"}`)
+	expectMatches(t, genFilter, `{"email": "Frank@Example.com", "confirmEmail": "frank@example.COM"}`)
+	expectMatches(t, genFilter, `{"email": "frank@example.com", "confirmEmail": "frank@example.org"}`, 2)
+	expectMatches(t, genFilter, `{"country": null}`)
+
+	// The identical equalsFold conditions share one category
+	condRepo, err := engine.RuleEngineRepoToCompareCondRepo(repo)
+	if err != nil {
+		t.Fatalf("failed RuleEngineRepoToCompareCondRepo: %s", err)
+	}
+	matched := genFilter.DebugMatchedCategories(map[string]interface{}{"country": "US"})
+	if len(matched) != 1 {
+		t.Fatalf("expected one category for equalsFold(country, \"US\"), got %v", matched)
+	}
+	// equalsFold(country, "US"), tier == "gold", hasValue(email) and equalsFold(email, confirmEmail)
+	if len(condRepo.EvalCategoryRecs) != 4 {
+		t.Fatalf("expected 4 categories, got %d", len(condRepo.EvalCategoryRecs))
+	}
+}
+
+func TestEqualsFoldErrors(t *testing.T) {
+	expectRuleEngineError(t, `equalsFold(country)`)
+	expectRuleEngineError(t, `equalsFold(country, "US", "CA")`)
+}
+
+func TestOnlyChars(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`onlyChars(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")`,
+		`onlyChars(pin, "0123456789") && length(pin) == 4`,
+		`onlyChars(name, "äöü")`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"code": "AB12Z"}`, 0)
+	expectMatches(t, genFilter, `{"code": "ab12"}`)
+	expectMatches(t, genFilter, `{"code": "AB-12"}`)
+	expectMatches(t, genFilter, `{"code": ""}`, 0)
+	expectMatches(t, genFilter, `{"pin": 1234}`, 1)
+	expectMatches(t, genFilter, `{"pin": "12a4"}`)
+	expectMatches(t, genFilter, `{"name": "üöä"}`, 2)
+	expectMatches(t, genFilter, `{"name": "üoa"}`)
+
+	expectMatches(t, genFilter, `{"code": null}`)
+}
+
+func TestOnlyCharsErrors(t *testing.T) {
+	expectRuleEngineError(t, `onlyChars(code)`)
+	expectRuleEngineError(t, `onlyChars(code, charset)`)
+}
+
+func TestLuhnValid(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`luhnValid(card)`,
+		`hasValue(card) && !luhnValid(card)`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"card": "4111111111111111"}`, 0)
+	expectMatches(t, genFilter, `{"card": "5500005555555559"}`, 0)
+	expectMatches(t, genFilter, `{"card": "79927398713"}`, 0)
+	expectMatches(t, genFilter, `{"card": 79927398713}`, 0)
+	expectMatches(t, genFilter, `{"card": "4111111111111112"}`, 1)
+	expectMatches(t, genFilter, `{"card": "79927398710"}`, 1)
+	expectMatches(t, genFilter, `{"card": "4111 1111 1111 1111"}`, 1)
+	expectMatches(t, genFilter, `{"card": ""}`, 1)
+	expectMatches(t, genFilter, `{"card": null}`)
+}
+
+func TestRegexpClassify(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`regexpClassify(path, "^/api/v1/", "^/api/", "^/admin/") == 0`,
+		`regexpClassify(path, "^/api/v1/", "^/api/", "^/admin/") == 1`,
+		`regexpClassify(path, "^/api/v1/", "^/api/", "^/admin/") == 2`,
+		`regexpClassify(path, "^/api/v1/", "^/api/", "^/admin/") < 0`,
+		`regexpClassify(code, "^\\d+$") == 0`)
+	expectNoErrors(t, repo)
+
+	expectMatches(t, genFilter, `{"path": "/api/v2/orders"}`, 1)
+	expectMatches(t, genFilter, `{"path": "/admin/users"}`, 2)
+	expectMatches(t, genFilter, `{"path": "/public/index.html"}`, 3)
+	// The first matching pattern wins even if a later one matches too
+	expectMatches(t, genFilter, `{"path": "/api/v1/orders"}`, 0)
+	// The numbers are matched as strings
+	expectMatches(t, genFilter, `{"code": 1234}`, 4)
+	expectMatches(t, genFilter, `{"code": null}`)
+	expectMatches(t, genFilter, `{"other": "/api/v1/orders"}`)
+
+	// Each pattern is compiled once for all the rules
+	if stats := genFilter.Stats(); stats.NumRegexps != 4 {
+		t.Fatalf("failed NumRegexps %d != 4", stats.NumRegexps)
+	}
+}
+
+func TestRegexpClassifyErrors(t *testing.T) {
+	expectRuleEngineError(t, `regexpClassify(path) == 0`)
+	expectRuleEngineError(t, `regexpClassify(path, "[") == 0`)
+	expectRuleEngineError(t, `regexpClassify(path, "^a", pattern) == 0`)
+}
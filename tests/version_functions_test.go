@@ -0,0 +1,46 @@
+package tests
+
+import "testing"
+
+func TestVersionCompare(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`versionEq(app, "1.10.0")`,
+		`versionLt(app, "1.10.0")`,
+		`versionLte(app, "1.10.0")`,
+		`versionGt(app, "1.10.0")`,
+		`versionGte(app, "1.10.0")`,
+		`versionLt(app, minApp)`,
+		`!versionLt(app, "1.10.0")`)
+	expectNoErrors(t, repo)
+
+	// Numeric segments are compared as numbers
+	expectMatches(t, genFilter, `{"app": "1.9.0"}`, 1, 2)
+	expectMatches(t, genFilter, `{"app": "1.10.0"}`, 0, 2, 4, 6)
+	expectMatches(t, genFilter, `{"app": "v1.10"}`, 0, 2, 4, 6)
+	expectMatches(t, genFilter, `{"app": "1.10.0+build.7"}`, 0, 2, 4, 6)
+	expectMatches(t, genFilter, `{"app": "1.100.0"}`, 3, 4, 6)
+	// Pre-release versions precede the release
+	expectMatches(t, genFilter, `{"app": "1.10.0-rc.1"}`, 1, 2)
+
+	// Invalid versions are undefined, which only matches the negated rule
+	expectMatches(t, genFilter, `{"app": "1.x"}`, 6)
+	expectMatches(t, genFilter, `{"app": "1.2.3.4"}`, 6)
+	expectMatches(t, genFilter, `{"app": "1.0.0-"}`, 6)
+	expectMatches(t, genFilter, `{"app": "1.0.0", "minApp": "latest"}`, 1, 2)
+
+	expectRuleEngineError(t, `versionLt(app)`)
+}
+
+func TestVersionPrecedence(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t, `versionLt(a, b)`, `versionGt(a, b)`)
+	expectNoErrors(t, repo)
+
+	// Each version precedes the next one
+	versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "2.0.0", "2.1.0", "2.1.1", "10.0.0"}
+	for i := 1; i < len(versions); i++ {
+		expectMatches(t, genFilter, `{"a": "`+versions[i-1]+`", "b": "`+versions[i]+`"}`, 0)
+		expectMatches(t, genFilter, `{"a": "`+versions[i]+`", "b": "`+versions[i-1]+`"}`, 1)
+	}
+	expectMatches(t, genFilter, `{"a": "1.0.0", "b": "1.0.0"}`)
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeWindow(t *testing.T, eventsJson string) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	if err := json.Unmarshal([]byte(eventsJson), &events); err != nil {
+		t.Fatalf("failed Unmarshal: %s", err)
+	}
+	return events
+}
+
+func TestMatchWindow(t *testing.T) {
+	repo, genFilter := newRuleEngineFromExpressions(t,
+		`windowCount(status == "failed") > 2`,
+		`windowCount(status == "failed" && user == "frank") == 2 && user == "frank"`,
+		`windowSum(amount) >= 100`,
+		`status == "ok"`)
+	expectNoErrors(t, repo)
+
+	for _, tc := range []struct {
+		window   string
+		expected []int
+	}{
+		{`[{"status": "failed", "user": "frank"}, {"status": "ok", "user": "frank"},
+		   {"status": "failed", "user": "tom"}, {"status": "failed", "user": "frank"}]`, []int{0, 1}},
+		{`[{"status": "failed", "user": "frank", "amount": 60}, {"status": "ok", "user": "tom", "amount": 50}]`,
+			[]int{2, 3}},
+		{`[{"status": "failed", "amount": 60}, {"status": "failed"}, {"amount": 30}]`, []int{}},
+		{`[{"status": "ok"}]`, []int{3}},
+		{`[]`, []int{}},
+	} {
+		matches := genFilter.MatchWindow(decodeWindow(t, tc.window))
+		if len(matches) != len(tc.expected) {
+			t.Fatalf("failed matches %v != %v for window %s", matches, tc.expected, tc.window)
+		}
+		matched := make(map[int]bool)
+		for _, m := range matches {
+			matched[int(m)] = true
+		}
+		for _, e := range tc.expected {
+			if !matched[e] {
+				t.Fatalf("failed matches %v != %v for window %s", matches, tc.expected, tc.window)
+			}
+		}
+	}
+
+	// Outside MatchWindow the window consists of the event alone
+	expectMatches(t, genFilter, `{"status": "failed", "amount": 120}`, 2)
+}
+
+func TestWindowFuncErrors(t *testing.T) {
+	expectRuleEngineError(t, `windowCount() > 1`)
+	expectRuleEngineError(t, `windowSum(a, b) > 1`)
+	expectRuleEngineError(t, `forSome("items", "item", windowCount(item.status == "failed") > 1)`)
+}